@@ -3,55 +3,105 @@ package openstack
 import (
 	"context"
 	"fmt"
-	"github.com/gophercloud/gophercloud/openstack/identity/v3/users"
 	"github.com/hashicorp/go-multierror"
-	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
-	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gophercloud/gophercloud"
-	"github.com/gophercloud/gophercloud/openstack"
-	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
 )
 
 const (
 	backendSecretTypeToken = "openstack_token"
 	backendSecretTypeUser  = "openstack_user"
+	backendSecretTypeTrust = "openstack_trust"
 	backendHelp            = "OpenStack Token Backend"
 )
 
 type sharedCloud struct {
 	name string
 
-	client    *gophercloud.ServiceClient
+	client    IdentityClient
 	expiresAt time.Time
 	lock      sync.Mutex
 
+	// newClient authenticates root identities of the cloud
+	newClient IdentityClientFactory
+
+	// rotating is set while root credentials are rotated
+	rotating int32
+	// unhealthyUntil is the time (in Unix nanoseconds) until which the root pool
+	// skips the identity after failed authentication
+	unhealthyUntil int64
+
 	passwords *Passwords
+
+	// breaker stops requests to the identity service of the cloud during outages,
+	// it's shared by all root identities of the cloud
+	breaker *circuitBreaker
+
+	// members hold clients of the additional root identities of the pool
+	members     map[string]*sharedCloud
+	membersLock sync.Mutex
+	next        uint32
 }
 
 type backend struct {
 	*framework.Backend
-	clouds               map[string]*sharedCloud
+
+	// clouds caches clients of the configured clouds, entries are evicted
+	// whenever the cloud configuration changes
+	clouds     map[string]*sharedCloud
+	cloudsLock sync.RWMutex
+	// breakers are kept when cached clients are evicted, so the cloud configuration
+	// being re-read doesn't close the breaker during outages
+	breakers map[string]*circuitBreaker
+
 	checkAutoRotateAfter time.Time
+
+	newIdentityClient IdentityClientFactory
 }
 
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
-	b := new(backend)
+	return FactoryWithIdentityClient(AuthenticateKeystone)(ctx, conf)
+}
+
+// FactoryWithIdentityClient returns backend factory using the given function to authenticate
+// in the identity service, which allows to use Keystone-compatible services other than Keystone itself
+func FactoryWithIdentityClient(newClient IdentityClientFactory) logical.Factory {
+	return func(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
+		b, err := newBackend(ctx, conf, newClient)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+}
+
+func newBackend(ctx context.Context, conf *logical.BackendConfig, newClient IdentityClientFactory) (*backend, error) {
+	b := &backend{newIdentityClient: newClient}
 	b.Backend = &framework.Backend{
 		Help: backendHelp,
 		PathsSpecial: &logical.Paths{
 			Unauthenticated: []string{
 				infoPattern,
 			},
+			// lease records of tokens keep live token IDs until they are revoked
+			SealWrapStorage: []string{
+				leasesStoragePath + "/",
+			},
 		},
 		Paths: []*framework.Path{
 			pathInfo,
 			b.pathCloud(),
 			b.pathClouds(),
+			b.pathCloudMember(),
+			b.pathCloudMembers(),
+			b.pathCloudHealth(),
+			b.pathImportClouds(),
 			b.pathRole(),
 			b.pathRoles(),
 			b.pathStaticRoles(),
@@ -64,9 +114,13 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 		Secrets: []*framework.Secret{
 			secretToken(b),
 			secretUser(b),
+			secretTrust(b),
 		},
 		BackendType:  logical.TypeLogical,
 		PeriodicFunc: b.periodicFunc,
+		WALRollback:  b.walRollback,
+		Invalidate:   b.invalidate,
+		Clean:        b.clean,
 	}
 
 	if err := b.Setup(ctx, conf); err != nil {
@@ -76,15 +130,38 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 	return b, nil
 }
 
+// HandleRequest passes the Vault request ID to Keystone requests made while handling the request
+func (b *backend) HandleRequest(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+	return b.Backend.HandleRequest(withVaultRequestID(ctx, req.ID), req)
+}
+
 func (b *backend) getSharedCloud(name string) *sharedCloud {
-	passwords := &Passwords{PolicyGenerator: b.System()}
+	b.cloudsLock.RLock()
+	c, ok := b.clouds[name]
+	b.cloudsLock.RUnlock()
+	if ok {
+		return c
+	}
+
+	b.cloudsLock.Lock()
+	defer b.cloudsLock.Unlock()
 	if c, ok := b.clouds[name]; ok {
-		if c.passwords == nil {
-			c.passwords = passwords
-		}
 		return c
 	}
-	cloud := &sharedCloud{name: name, passwords: passwords}
+	breaker, ok := b.breakers[name]
+	if !ok {
+		breaker = newCircuitBreaker(name)
+		if b.breakers == nil {
+			b.breakers = make(map[string]*circuitBreaker)
+		}
+		b.breakers[name] = breaker
+	}
+	cloud := &sharedCloud{
+		name:      name,
+		passwords: &Passwords{PolicyGenerator: b.System()},
+		newClient: b.newIdentityClient,
+		breaker:   breaker,
+	}
 	if b.clouds == nil {
 		b.clouds = make(map[string]*sharedCloud)
 	}
@@ -92,23 +169,86 @@ func (b *backend) getSharedCloud(name string) *sharedCloud {
 	return cloud
 }
 
-// getClient returns initialized Keystone service client
-func (c *sharedCloud) getClient(ctx context.Context, s logical.Storage) (*gophercloud.ServiceClient, error) {
+// evictCloud drops the cached clients of the cloud, so they are created
+// using the stored configuration on the next use. The circuit breaker of the cloud
+// is kept and is only reset if the `auth_url` of the cloud is changed.
+func (b *backend) evictCloud(name string) {
+	b.cloudsLock.Lock()
+	defer b.cloudsLock.Unlock()
+	delete(b.clouds, name)
+}
+
+// removeCloud drops the cached clients and stops the circuit breaker of the deleted cloud
+func (b *backend) removeCloud(name string) {
+	b.cloudsLock.Lock()
+	defer b.cloudsLock.Unlock()
+	if breaker, ok := b.breakers[name]; ok {
+		breaker.stop()
+	}
+	delete(b.breakers, name)
+	delete(b.clouds, name)
+}
+
+// invalidate is called when storage entries are changed outside of this node,
+// e.g. by the active node of the cluster
+func (b *backend) invalidate(_ context.Context, key string) {
+	switch {
+	case strings.HasPrefix(key, pathCloud+"/"):
+		b.evictCloud(strings.TrimPrefix(key, pathCloud+"/"))
+	case strings.HasPrefix(key, rootPoolStoragePath+"/"):
+		parts := strings.SplitN(strings.TrimPrefix(key, rootPoolStoragePath+"/"), "/", 2)
+		if len(parts) != 2 {
+			return
+		}
+		b.cloudsLock.RLock()
+		c, ok := b.clouds[parts[0]]
+		b.cloudsLock.RUnlock()
+		if ok {
+			c.evictMember(parts[1])
+		}
+	}
+}
+
+// clean stops scheduled probes of the circuit breakers when the backend is unmounted
+func (b *backend) clean(_ context.Context) {
+	b.cloudsLock.Lock()
+	defer b.cloudsLock.Unlock()
+	for _, breaker := range b.breakers {
+		breaker.stop()
+	}
+}
+
+// passwordsFor returns password generator using the password policy of the cloud
+func (c *sharedCloud) passwordsFor(cloud *OsCloud) *Passwords {
+	return &Passwords{
+		PolicyGenerator: c.passwords.PolicyGenerator,
+		PolicyName:      cloud.PasswordPolicy,
+	}
+}
+
+// getClient returns initialized Keystone service client sending requests within the context
+func (c *sharedCloud) getClient(ctx context.Context, s logical.Storage) (IdentityClient, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
 	if c.client != nil {
 		diff := time.Since(c.expiresAt)
 		if diff.Seconds() <= -120 {
-			return c.client, nil
+			if err := c.breaker.check(); err != nil {
+				return nil, err
+			}
+			return c.client.WithContext(ctx), nil
 		}
 	}
 
+	// the breaker is checked on authentication, once the configuration
+	// of the cloud is read and the breaker is bound to its identity service
+
 	if err := c.initClient(ctx, s); err != nil {
 		return nil, err
 	}
 
-	return c.client, nil
+	return c.client.WithContext(ctx), nil
 }
 
 func (c *sharedCloud) initClient(ctx context.Context, s logical.Storage) error {
@@ -120,41 +260,79 @@ func (c *sharedCloud) initClient(ctx context.Context, s logical.Storage) error {
 		return fmt.Errorf("no cloud found with name %s", c.name)
 	}
 
-	opts := gophercloud.AuthOptions{
-		IdentityEndpoint: cloud.AuthURL,
-		Username:         cloud.Username,
-		Password:         cloud.Password,
-		DomainName:       cloud.UserDomainName,
-		Scope: &gophercloud.AuthScope{
-			DomainName: cloud.UserDomainName,
-		},
-	}
-
-	pClient, err := openstack.AuthenticatedClient(opts)
+	client, expiresAt, err := c.authenticate(ctx, cloud)
 	if err != nil {
-		return fmt.Errorf("error creating provider client: %w", common.LogHttpError(err))
+		return err
 	}
 
-	sClient, err := openstack.NewIdentityV3(pClient, gophercloud.EndpointOpts{})
-	if err != nil {
-		return fmt.Errorf("error creating service client: %w", common.LogHttpError(err))
+	c.expiresAt = expiresAt
+	c.client = client
+
+	return nil
+}
+
+// authenticate creates identity client of the given root identity of the cloud bound to the context
+func (c *sharedCloud) authenticate(ctx context.Context, cloud *OsCloud) (IdentityClient, time.Time, error) {
+	if err := c.breaker.check(); err != nil {
+		return nil, time.Time{}, err
 	}
 
-	tokenResponse := tokens.Get(sClient, sClient.Token())
-	token, err := tokenResponse.ExtractToken()
-	if err != nil {
-		return fmt.Errorf("error extracting token: %w", common.LogHttpError(err))
+	withBreaker := *cloud
+	withBreaker.breaker = c.breaker
+	if c.newClient == nil {
+		return AuthenticateKeystone(ctx, &withBreaker)
 	}
+	return c.newClient(ctx, &withBreaker)
+}
 
-	c.expiresAt = token.ExpiresAt
-	c.client = sClient
+// authOptions returns options used to authenticate the root user
+func (cloud *OsCloud) authOptions() gophercloud.AuthOptions {
+	if cloud.AuthType == AuthTypeApplicationCredential {
+		// application credentials are bound to a scope, so no scope can be requested
+		return gophercloud.AuthOptions{
+			IdentityEndpoint:            cloud.AuthURL,
+			Username:                    cloud.Username,
+			DomainName:                  cloud.UserDomainName,
+			ApplicationCredentialID:     cloud.ApplicationCredentialID,
+			ApplicationCredentialName:   cloud.ApplicationCredentialName,
+			ApplicationCredentialSecret: cloud.ApplicationCredentialSecret,
+		}
+	}
+	return gophercloud.AuthOptions{
+		IdentityEndpoint: cloud.AuthURL,
+		Username:         cloud.Username,
+		Password:         cloud.Password,
+		DomainName:       cloud.UserDomainName,
+		Scope:            cloud.authScope(),
+	}
+}
 
-	return nil
+// authScope returns scope of the root token, which is the user domain unless a project is configured
+func (cloud *OsCloud) authScope() *gophercloud.AuthScope {
+	switch {
+	case cloud.ProjectID != "":
+		return &gophercloud.AuthScope{
+			ProjectID: cloud.ProjectID,
+		}
+	case cloud.ProjectName != "":
+		domainName := cloud.ProjectDomainName
+		if domainName == "" {
+			domainName = cloud.UserDomainName
+		}
+		return &gophercloud.AuthScope{
+			ProjectName: cloud.ProjectName,
+			DomainName:  domainName,
+		}
+	default:
+		return &gophercloud.AuthScope{
+			DomainName: cloud.UserDomainName,
+		}
+	}
 }
 
 func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
 	// Check for autorotation once an hour to avoid unnecessarily iterating
-	// over all keys too frequently.
+	// over all keys too frequently. Scheduled rotations can make it earlier.
 	if time.Now().Before(b.checkAutoRotateAfter) {
 		return nil
 	}
@@ -174,14 +352,16 @@ func (b *backend) autoRotateKeys(ctx context.Context, req *logical.Request) erro
 	// all keys from being rotated.
 	var errs *multierror.Error
 
+	now := time.Now()
 	for _, key := range keys {
 		cloudEntry := b.getSharedCloud(key)
 		if cloudEntry == nil {
 			continue
 		}
 
-		err = b.rotateIfRequired(ctx, req, cloudEntry)
+		err = b.rotateIfRequired(ctx, req, cloudEntry, now)
 		if err != nil {
+			b.Logger().Error("error rotating root credentials", "cloud", key, "error", err, "request_id", common.RequestIDOf(err))
 			errs = multierror.Append(errs, err)
 		}
 	}
@@ -189,44 +369,60 @@ func (b *backend) autoRotateKeys(ctx context.Context, req *logical.Request) erro
 	return errs.ErrorOrNil()
 }
 
-func (b *backend) rotateIfRequired(ctx context.Context, req *logical.Request, sCloud *sharedCloud) error {
+// rotateIfRequired rotates root identities of the cloud whose passwords are expired at the given time
+func (b *backend) rotateIfRequired(ctx context.Context, req *logical.Request, sCloud *sharedCloud, now time.Time) error {
 	cloudConfig, err := sCloud.getCloudConfig(ctx, req.Storage)
 	if err != nil {
 		return err
 	}
-	if time.Now().After(cloudConfig.RootPasswordExpirationDate) {
-		client, err := sCloud.getClient(ctx, req.Storage)
-		if err != nil {
-			return logical.CodedError(http.StatusConflict, common.LogHttpError(err).Error())
-		}
-		newPassword, err := sCloud.passwords.Generate(ctx)
-		if err != nil {
-			return err
-		}
 
-		// make sure we don't use this cloud until the password is changed
-		sCloud.lock.Lock()
-		defer sCloud.lock.Unlock()
+	var errs *multierror.Error
+	if err := b.rotateIdentityIfRequired(ctx, req.Storage, sCloud, cloudConfig, now); err != nil {
+		errs = multierror.Append(errs, err)
+	}
 
-		user, err := tokens.Get(client, client.Token()).ExtractUser()
+	// root pool members are rotated independently of each other
+	members, err := listRootMembers(ctx, req.Storage, sCloud.name)
+	if err != nil {
+		return multierror.Append(errs, err)
+	}
+	for _, name := range members {
+		member, err := getRootMember(ctx, req.Storage, sCloud.name, name)
 		if err != nil {
-			return logical.CodedError(http.StatusConflict, common.LogHttpError(err).Error())
+			errs = multierror.Append(errs, err)
+			continue
 		}
-		err = users.ChangePassword(client, user.ID, users.ChangePasswordOpts{
-			Password:         newPassword,
-			OriginalPassword: cloudConfig.Password,
-		}).ExtractErr()
-		if err != nil {
-			errorMessage := fmt.Sprintf("error changing root password: %s", common.LogHttpError(err).Error())
-			return logical.CodedError(http.StatusConflict, errorMessage)
+		if member == nil {
+			continue
 		}
-		cloudConfig.Password = newPassword
-		cloudConfig.RootPasswordExpirationDate = time.Now().Add(cloudConfig.RootPasswordTTL)
+		if err := b.rotateIdentityIfRequired(ctx, req.Storage, sCloud, cloudConfig.forMember(member), now); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs.ErrorOrNil()
+}
 
-		if err := cloudConfig.save(ctx, req.Storage); err != nil {
+func (b *backend) rotateIdentityIfRequired(ctx context.Context, s logical.Storage, sCloud *sharedCloud, cloudConfig *OsCloud, now time.Time) error {
+	if now.After(cloudConfig.RootPasswordExpirationDate) {
+		if !cloudConfig.rotationAllowed(now) {
+			// missed rotation is done in the next window
+			next := cloudConfig.nextRotation(now)
+			b.Logger().Debug("rotation postponed", "cloud", cloudConfig.identity(), "until", next)
+			b.scheduleRotationCheck(next)
+			return nil
+		}
+		if err := b.rotateRoot(ctx, s, sCloud, cloudConfig, rotationTriggerScheduled, ""); err != nil {
 			return err
 		}
-		b.Logger().Debug("password rotated", "cloud", cloudConfig.Name)
+		b.Logger().Debug("password rotated", "cloud", cloudConfig.identity())
 	}
+	b.scheduleRotationCheck(cloudConfig.RootPasswordExpirationDate)
 	return nil
 }
+
+// scheduleRotationCheck makes the periodic function check for rotation not later than the given time
+func (b *backend) scheduleRotationCheck(at time.Time) {
+	if at.Before(b.checkAutoRotateAfter) {
+		b.checkAutoRotateAfter = at
+	}
+}
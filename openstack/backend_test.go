@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/hashicorp/vault/sdk/helper/logging"
 	"net/http"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -15,9 +16,12 @@ import (
 	thClient "github.com/gophercloud/gophercloud/testhelper/client"
 	"github.com/hashicorp/go-hclog"
 	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/fixtures"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type failVerb int
@@ -61,9 +65,20 @@ func testBackend(t *testing.T, fvs ...failVerb) (*backend, logical.Storage) {
 	return b.(*backend), config.StorageView
 }
 
+func TestBackend_sealWrapLeases(t *testing.T) {
+	b, _ := testBackend(t)
+
+	lease := leaseStoragePath(testCloudName, "audit-id")
+	wrapped := false
+	for _, prefix := range b.PathsSpecial.SealWrapStorage {
+		wrapped = wrapped || strings.HasPrefix(lease, prefix)
+	}
+	assert.True(t, wrapped, "lease records keeping token IDs must be seal-wrapped")
+}
+
 func TestBackend_sharedCloud(t *testing.T) {
 	expected := &sharedCloud{
-		client:    new(gophercloud.ServiceClient),
+		client:    NewGophercloudIdentityClient(new(gophercloud.ServiceClient)),
 		passwords: new(Passwords),
 		lock:      sync.Mutex{},
 	}
@@ -87,6 +102,95 @@ func TestBackend_sharedCloud(t *testing.T) {
 	})
 }
 
+func TestBackend_invalidate(t *testing.T) {
+	b, _ := testBackend(t)
+	cloudName := tools.RandomString("cl", 5)
+
+	t.Run("cloud", func(t *testing.T) {
+		cached := b.getSharedCloud(cloudName)
+		assert.Same(t, cached, b.getSharedCloud(cloudName))
+
+		b.Invalidate(context.Background(), storageCloudKey(cloudName))
+		assert.NotSame(t, cached, b.getSharedCloud(cloudName))
+	})
+
+	t.Run("member", func(t *testing.T) {
+		sCloud := b.getSharedCloud(cloudName)
+		cached := sCloud.rootClientFor("member")
+
+		b.Invalidate(context.Background(), rootMemberStorageKey(cloudName, "member"))
+		assert.Same(t, sCloud, b.getSharedCloud(cloudName))
+		assert.NotSame(t, cached, sCloud.rootClientFor("member"))
+	})
+
+	t.Run("other", func(t *testing.T) {
+		cached := b.getSharedCloud(cloudName)
+		b.Invalidate(context.Background(), roleStoragePath(cloudName))
+		assert.Same(t, cached, b.getSharedCloud(cloudName))
+	})
+}
+
+func TestBackend_concurrentClouds(t *testing.T) {
+	userID, _ := uuid.GenerateUUID()
+	fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{
+		TokenPost: true,
+		TokenGet:  true,
+	})
+
+	b, s := testBackend(t)
+	cloudEntry, err := logical.StorageEntryJSON(storageCloudKey(testCloudName), &OsCloud{
+		Name:           testCloudName,
+		AuthURL:        thClient.ServiceClient().Endpoint + "v3",
+		UserDomainName: testUserDomainName,
+		Username:       testUsername,
+		Password:       testPassword1,
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Put(context.Background(), cloudEntry))
+	roleName := createSaveRandomRole(t, s, true, "", "token")
+
+	const workers = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*3)
+	for i := 0; i < workers; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			res, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      credsPath(roleName),
+				Storage:   s,
+			})
+			if err == nil && res.IsError() {
+				err = res.Error()
+			}
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      pathCloudKey(testCloudName),
+				Storage:   s,
+			})
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			b.Invalidate(context.Background(), storageCloudKey(testCloudName))
+			b.Invalidate(context.Background(), rootMemberStorageKey(testCloudName, "member"))
+			errs <- nil
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
 func TestSharedCloud_client(t *testing.T) {
 	th.SetupHTTP()
 	defer th.TeardownHTTP()
@@ -96,14 +200,14 @@ func TestSharedCloud_client(t *testing.T) {
 
 	t.Run("existing-client", func(t *testing.T) {
 		cloud := &sharedCloud{
-			client:    thClient.ServiceClient(),
+			client:    NewGophercloudIdentityClient(testClient),
 			expiresAt: time.Now().Add(time.Hour),
 			lock:      sync.Mutex{},
 		}
 
 		client, err := cloud.getClient(context.Background(), s)
 		assert.NoError(t, err)
-		assert.Equal(t, testClient, client)
+		assert.Equal(t, NewGophercloudIdentityClient(testClient).WithContext(context.Background()), client)
 	})
 
 	t.Run("new-client", func(t *testing.T) {
@@ -170,6 +274,37 @@ func TestSharedCloud_client(t *testing.T) {
 	})
 }
 
+func TestOsCloud_authScope(t *testing.T) {
+	cases := map[string]struct {
+		cloud    *OsCloud
+		expected *gophercloud.AuthScope
+	}{
+		"domain": {
+			cloud:    &OsCloud{UserDomainName: "user-domain"},
+			expected: &gophercloud.AuthScope{DomainName: "user-domain"},
+		},
+		"project-id": {
+			cloud:    &OsCloud{UserDomainName: "user-domain", ProjectID: "project-id"},
+			expected: &gophercloud.AuthScope{ProjectID: "project-id"},
+		},
+		"project-name": {
+			cloud:    &OsCloud{UserDomainName: "user-domain", ProjectName: "project", ProjectDomainName: "project-domain"},
+			expected: &gophercloud.AuthScope{ProjectName: "project", DomainName: "project-domain"},
+		},
+		"project-name-user-domain": {
+			cloud:    &OsCloud{UserDomainName: "user-domain", ProjectName: "project"},
+			expected: &gophercloud.AuthScope{ProjectName: "project", DomainName: "user-domain"},
+		},
+	}
+
+	for name, data := range cases {
+		data := data
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, data.expected, data.cloud.authScope())
+		})
+	}
+}
+
 func TestPeriodicFuncNilConfig(t *testing.T) {
 	th.SetupHTTP()
 	defer th.TeardownHTTP()
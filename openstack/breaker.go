@@ -0,0 +1,257 @@
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+	breakerProbeTimeout     = 10 * time.Second
+)
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half_open"
+)
+
+// circuitOpenError is returned for requests rejected by the open circuit breaker
+type circuitOpenError struct {
+	cloud   string
+	retryAt time.Time
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("identity service of cloud `%s` is unavailable, retry after %s",
+		e.cloud, e.retryAt.UTC().Format(time.RFC3339))
+}
+
+// circuitBreaker stops requests to the identity service of the cloud after repeated connection
+// errors or 5xx responses. When the breaker is open, the service is probed after each cooldown
+// and the breaker is closed as soon as the probe succeeds.
+type circuitBreaker struct {
+	cloud     string
+	threshold int
+	cooldown  time.Duration
+
+	lock     sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	lastErr  string
+	// authURL is the identity service the state of the breaker describes
+	authURL string
+	// probe checks if the service is reachable again, it's set by the transport which opened the breaker
+	probe   func() error
+	timer   *time.Timer
+	stopped bool
+}
+
+func newCircuitBreaker(cloud string) *circuitBreaker {
+	return &circuitBreaker{
+		cloud:     cloud,
+		threshold: defaultBreakerThreshold,
+		cooldown:  defaultBreakerCooldown,
+		state:     breakerClosed,
+	}
+}
+
+// check returns error if requests to the service are not allowed
+func (b *circuitBreaker) check() error {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == breakerClosed {
+		return nil
+	}
+	return &common.KeystoneError{
+		Kind: common.KindUnavailable,
+		Err:  &circuitOpenError{cloud: b.cloud, retryAt: b.openedAt.Add(b.cooldown)},
+	}
+}
+
+// success resets the failure counter
+func (b *circuitBreaker) success() {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.failures = 0
+}
+
+// failure counts the failed request and opens the breaker once the threshold is reached
+func (b *circuitBreaker) failure(err error, probe func() error) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.failures++
+	b.lastErr = err.Error()
+	if b.state == breakerClosed && b.failures >= b.threshold {
+		b.probe = probe
+		b.open()
+	}
+}
+
+// open opens the breaker and schedules the probe, the lock has to be held
+func (b *circuitBreaker) open() {
+	b.state = breakerOpen
+	b.openedAt = time.Now()
+	if b.stopped || b.probe == nil {
+		return
+	}
+	b.timer = time.AfterFunc(b.cooldown, b.runProbe)
+}
+
+func (b *circuitBreaker) runProbe() {
+	b.lock.Lock()
+	if b.stopped || b.state != breakerOpen {
+		b.lock.Unlock()
+		return
+	}
+	b.state = breakerHalfOpen
+	probe := b.probe
+	authURL := b.authURL
+	b.lock.Unlock()
+
+	err := probe()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.authURL != authURL {
+		// the breaker is reset while the previous service is probed
+		return
+	}
+	if err != nil {
+		b.lastErr = err.Error()
+		b.open()
+		return
+	}
+	b.state = breakerClosed
+	b.failures = 0
+	b.lastErr = ""
+}
+
+// target binds the breaker to the identity service of the cloud. The state of the breaker is
+// kept while the service stays the same and is reset once the service changes.
+func (b *circuitBreaker) target(authURL string) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.authURL == authURL {
+		return
+	}
+	if b.authURL != "" {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		b.lastErr = ""
+		b.probe = nil
+	}
+	b.authURL = authURL
+}
+
+// stop cancels the scheduled probe, the breaker isn't used after it
+func (b *circuitBreaker) stop() {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.stopped = true
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+}
+
+// status returns the breaker state for the cloud health endpoint
+func (b *circuitBreaker) status() map[string]interface{} {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	status := map[string]interface{}{
+		"state":    string(b.state),
+		"failures": b.failures,
+	}
+	if b.lastErr != "" {
+		status["last_error"] = b.lastErr
+	}
+	if b.state != breakerClosed {
+		status["opened_at"] = b.openedAt.UTC().Format(time.RFC3339)
+		status["next_probe"] = b.openedAt.Add(b.cooldown).UTC().Format(time.RFC3339)
+	}
+	return status
+}
+
+// breakerTransport rejects requests while the breaker is open and reports results of the requests to it
+type breakerTransport struct {
+	transport http.RoundTripper
+	breaker   *circuitBreaker
+	// probeURL is requested to check if the service is reachable again
+	probeURL string
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.check(); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	switch {
+	case err != nil:
+		if !errors.Is(err, context.Canceled) {
+			t.breaker.failure(err, t.probe)
+		}
+	case resp.StatusCode >= http.StatusInternalServerError:
+		t.breaker.failure(fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status), t.probe)
+	default:
+		t.breaker.success()
+	}
+	return resp, err
+}
+
+// probe requests version discovery of the identity service
+func (t *breakerTransport) probe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), breakerProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.probeURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("probe failed: %s", resp.Status)
+	}
+	return nil
+}
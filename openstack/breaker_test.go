@@ -0,0 +1,182 @@
+package openstack
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/acceptance/tools"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/fixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := newCircuitBreaker(testCloudName)
+	breaker.threshold = 2
+	breaker.cooldown = 30 * time.Millisecond
+	defer breaker.stop()
+
+	probeErr := errors.New("connection refused")
+	var recovered int32
+	probe := func() error {
+		if atomic.LoadInt32(&recovered) == 0 {
+			return probeErr
+		}
+		return nil
+	}
+
+	breaker.failure(probeErr, probe)
+	breaker.success()
+	breaker.failure(probeErr, probe)
+	require.NoError(t, breaker.check(), "failures have to be consecutive")
+
+	breaker.failure(probeErr, probe)
+	var open *circuitOpenError
+	require.ErrorAs(t, breaker.check(), &open)
+	assert.Contains(t, open.Error(), testCloudName)
+
+	status := breaker.status()
+	assert.Equal(t, string(breakerOpen), status["state"])
+	assert.Equal(t, probeErr.Error(), status["last_error"])
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Error(t, breaker.check(), "breaker stays open while probes fail")
+
+	atomic.StoreInt32(&recovered, 1)
+	require.Eventually(t, func() bool { return breaker.check() == nil }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 0, breaker.status()["failures"])
+}
+
+func TestCircuitBreaker_creds(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+	project := keystone.AddProject(fixtures.KeystoneDefaultDomainID, tools.RandomString("p", 5))
+
+	cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+	require.NoError(t, err)
+	cloudConfig.RetryMaxAttempts = 1
+	require.NoError(t, cloudConfig.save(context.Background(), s))
+	b.evictCloud(testCloudName)
+
+	breaker := b.getSharedCloud(testCloudName).breaker
+	breaker.threshold = 2
+	breaker.cooldown = 20 * time.Millisecond
+
+	roleName := randomRoleName()
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      rolePath(roleName),
+		Data: map[string]interface{}{
+			"cloud":       testCloudName,
+			"project_id":  project.ID,
+			"secret_type": "password",
+		},
+		Storage: s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	readCreds := func() error {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      credsPath(roleName),
+			Storage:   s,
+		})
+		return err
+	}
+	readHealth := func() *logical.Response {
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "clouds/" + testCloudName + "/health",
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		return res
+	}
+
+	res = readHealth()
+	assert.Equal(t, string(breakerClosed), res.Data["state"])
+
+	keystone.InjectFault(fixtures.Fault{StatusCode: http.StatusServiceUnavailable})
+	for i := 0; i < 2; i++ {
+		err := readCreds()
+		var coded logical.HTTPCodedError
+		require.ErrorAs(t, err, &coded)
+		assert.Equal(t, http.StatusServiceUnavailable, coded.Code())
+		assert.Contains(t, err.Error(), "Keystone responded with 503")
+	}
+
+	requests := keystone.RequestCount("", "")
+	err = readCreds()
+	var coded logical.HTTPCodedError
+	require.ErrorAs(t, err, &coded)
+	assert.Equal(t, http.StatusServiceUnavailable, coded.Code())
+	assert.Contains(t, err.Error(), "is unavailable")
+	assert.LessOrEqual(t, keystone.RequestCount("", ""), requests+1, "open breaker must fail fast")
+
+	res = readHealth()
+	assert.Equal(t, http.StatusServiceUnavailable, res.Data[logical.HTTPStatusCode])
+
+	keystone.ClearFaults()
+	require.Eventually(t, func() bool {
+		return breaker.check() == nil
+	}, time.Second, 5*time.Millisecond, "breaker must be closed after a successful probe")
+
+	res = readHealth()
+	assert.Equal(t, string(breakerClosed), res.Data["state"])
+	assert.NoError(t, readCreds())
+}
+
+func TestCircuitBreaker_cloudUpdate(t *testing.T) {
+	b, s := testBackend(t)
+
+	writeCloud := func(t *testing.T, data map[string]interface{}) {
+		t.Helper()
+		data["verify_connection"] = false
+		data["rotate_on_create"] = false
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      pathCloudKey(testCloudName),
+			Data:      data,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, res.IsError(), res.Error())
+	}
+
+	writeCloud(t, map[string]interface{}{
+		"auth_url":         "https://keystone.example.com/v3",
+		"username":         testUsername,
+		"password":         testPassword1,
+		"user_domain_name": testUserDomainName,
+	})
+
+	breaker := b.getSharedCloud(testCloudName).breaker
+	breaker.threshold = 1
+	breaker.cooldown = time.Hour
+	breaker.failure(errors.New("connection refused"), func() error { return nil })
+	require.Error(t, breaker.check())
+
+	t.Run("same-auth-url", func(t *testing.T) {
+		writeCloud(t, map[string]interface{}{"retry_jitter": false})
+		b.invalidate(context.Background(), storageCloudKey(testCloudName))
+
+		sCloud := b.getSharedCloud(testCloudName)
+		_, err := sCloud.getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.Same(t, breaker, sCloud.breaker)
+		assert.Error(t, sCloud.breaker.check(), "breaker must stay open")
+	})
+
+	t.Run("changed-auth-url", func(t *testing.T) {
+		writeCloud(t, map[string]interface{}{"auth_url": "https://keystone-2.example.com/v3"})
+
+		sCloud := b.getSharedCloud(testCloudName)
+		assert.NoError(t, sCloud.breaker.check(), "breaker must be reset")
+		assert.Equal(t, 0, sCloud.breaker.status()["failures"])
+	})
+}
@@ -0,0 +1,246 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	golangsdk "github.com/gophercloud/gophercloud"
+)
+
+// RequestIDHeader is the header Keystone identifies each request with
+const RequestIDHeader = "X-Openstack-Request-Id"
+
+// ErrorKind classifies errors of Keystone requests
+type ErrorKind string
+
+const (
+	KindBadRequest   ErrorKind = "bad_request"
+	KindUnauthorized ErrorKind = "unauthorized"
+	KindForbidden    ErrorKind = "forbidden"
+	KindNotFound     ErrorKind = "not_found"
+	KindConflict     ErrorKind = "conflict"
+	KindRateLimited  ErrorKind = "rate_limited"
+	KindUnavailable  ErrorKind = "unavailable"
+	KindInternal     ErrorKind = "internal"
+)
+
+// vaultCodes maps kinds of errors to HTTP codes of Vault responses. Keystone requests are sent
+// by the root identity, so Keystone rejecting its credentials (401) or its permissions (403)
+// is reported as 502, as the rejection is not caused by the Vault client.
+var vaultCodes = map[ErrorKind]int{
+	KindBadRequest:   http.StatusBadRequest,
+	KindUnauthorized: http.StatusBadGateway,
+	KindForbidden:    http.StatusBadGateway,
+	KindNotFound:     http.StatusNotFound,
+	KindConflict:     http.StatusConflict,
+	KindRateLimited:  http.StatusTooManyRequests,
+	KindUnavailable:  http.StatusServiceUnavailable,
+	KindInternal:     http.StatusInternalServerError,
+}
+
+// KeystoneError describes failed request to Keystone. It implements logical.HTTPCodedError,
+// so Vault responds with the code matching the kind of the error.
+type KeystoneError struct {
+	Kind ErrorKind
+	// StatusCode is the status of Keystone response, it's 0 if no response is received
+	StatusCode int
+	// RequestID is the value of X-Openstack-Request-Id header of Keystone response
+	RequestID string
+	// Context describes the failed operation
+	Context string
+	// Message is the error message returned by Keystone
+	Message string
+	Err     error
+}
+
+func (e *KeystoneError) Error() string {
+	var inner *KeystoneError
+	if e.Err != nil && errors.As(e.Err, &inner) {
+		// the error only adds the description to the classified one
+		if e.Context == "" {
+			return e.Err.Error()
+		}
+		return e.Context + ": " + e.Err.Error()
+	}
+
+	var sb strings.Builder
+	if e.Context != "" {
+		sb.WriteString(e.Context)
+		sb.WriteString(": ")
+	}
+	switch {
+	case e.StatusCode != 0:
+		sb.WriteString(fmt.Sprintf("Keystone responded with %d %s", e.StatusCode, http.StatusText(e.StatusCode)))
+		if e.Message != "" {
+			sb.WriteString(": ")
+			sb.WriteString(e.Message)
+		}
+	case e.Err != nil:
+		sb.WriteString(e.Err.Error())
+	default:
+		sb.WriteString(e.Message)
+	}
+	if e.RequestID != "" {
+		sb.WriteString(fmt.Sprintf(" (request ID: %s)", e.RequestID))
+	}
+	return sb.String()
+}
+
+func (e *KeystoneError) Unwrap() error {
+	return e.Err
+}
+
+// Code returns HTTP code of Vault response
+func (e *KeystoneError) Code() int {
+	if code, ok := vaultCodes[e.Kind]; ok {
+		return code
+	}
+	return http.StatusInternalServerError
+}
+
+// NewError returns KeystoneError of the given kind not caused by Keystone response
+func NewError(kind ErrorKind, format string, args ...interface{}) *KeystoneError {
+	return &KeystoneError{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// KeystoneErrorf classifies the error of Keystone request and adds the description of the failed operation to it.
+// Errors which are already classified keep their kind, other errors not caused by Keystone responses
+// are reported as internal.
+func KeystoneErrorf(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	context := fmt.Sprintf(format, args...)
+
+	var classified *KeystoneError
+	if errors.As(err, &classified) {
+		if context == "" && err == error(classified) {
+			return err
+		}
+		return &KeystoneError{
+			Kind:       classified.Kind,
+			StatusCode: classified.StatusCode,
+			RequestID:  classified.RequestID,
+			Context:    context,
+			Message:    classified.Message,
+			Err:        err,
+		}
+	}
+
+	if resp, ok := unexpectedResponse(err); ok {
+		return &KeystoneError{
+			Kind:       statusKind(resp.Actual),
+			StatusCode: resp.Actual,
+			RequestID:  resp.ResponseHeader.Get(RequestIDHeader),
+			Context:    context,
+			Message:    responseMessage(resp.Body),
+			Err:        err,
+		}
+	}
+
+	kind := KindInternal
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		kind = KindUnavailable
+	}
+	return &KeystoneError{Kind: kind, Context: context, Err: err}
+}
+
+// ErrorKindOf returns the kind of the error, errors not classified using KeystoneErrorf are internal
+func ErrorKindOf(err error) ErrorKind {
+	var classified *KeystoneError
+	if errors.As(KeystoneErrorf(err, ""), &classified) {
+		return classified.Kind
+	}
+	return KindInternal
+}
+
+// RequestIDOf returns the request ID of Keystone response which caused the error
+func RequestIDOf(err error) string {
+	var classified *KeystoneError
+	if errors.As(KeystoneErrorf(err, ""), &classified) {
+		return classified.RequestID
+	}
+	return ""
+}
+
+func statusKind(code int) ErrorKind {
+	switch code {
+	case http.StatusBadRequest:
+		return KindBadRequest
+	case http.StatusUnauthorized:
+		return KindUnauthorized
+	case http.StatusForbidden:
+		return KindForbidden
+	case http.StatusNotFound:
+		return KindNotFound
+	case http.StatusConflict:
+		return KindConflict
+	case http.StatusTooManyRequests:
+		return KindRateLimited
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return KindUnavailable
+	}
+	return KindInternal
+}
+
+// unexpectedResponse finds Keystone response in the chain of gophercloud errors
+func unexpectedResponse(err error) (golangsdk.ErrUnexpectedResponseCode, bool) {
+	for err != nil {
+		switch e := err.(type) {
+		case golangsdk.ErrUnexpectedResponseCode:
+			return e, true
+		case *golangsdk.ErrUnexpectedResponseCode:
+			return *e, true
+		case golangsdk.ErrDefault400:
+			return e.ErrUnexpectedResponseCode, true
+		case golangsdk.ErrDefault401:
+			return e.ErrUnexpectedResponseCode, true
+		case golangsdk.ErrDefault403:
+			return e.ErrUnexpectedResponseCode, true
+		case golangsdk.ErrDefault404:
+			return e.ErrUnexpectedResponseCode, true
+		case golangsdk.ErrDefault405:
+			return e.ErrUnexpectedResponseCode, true
+		case golangsdk.ErrDefault408:
+			return e.ErrUnexpectedResponseCode, true
+		case golangsdk.ErrDefault409:
+			return e.ErrUnexpectedResponseCode, true
+		case golangsdk.ErrDefault429:
+			return e.ErrUnexpectedResponseCode, true
+		case golangsdk.ErrDefault500:
+			return e.ErrUnexpectedResponseCode, true
+		case golangsdk.ErrDefault502:
+			return e.ErrUnexpectedResponseCode, true
+		case golangsdk.ErrDefault503:
+			return e.ErrUnexpectedResponseCode, true
+		case golangsdk.ErrDefault504:
+			return e.ErrUnexpectedResponseCode, true
+		case *golangsdk.ErrErrorAfterReauthentication:
+			err = e.ErrOriginal
+			continue
+		case *golangsdk.ErrUnableToReauthenticate:
+			err = e.ErrOriginal
+			continue
+		}
+		err = errors.Unwrap(err)
+	}
+	return golangsdk.ErrUnexpectedResponseCode{}, false
+}
+
+// responseMessage returns the message of Keystone error response, or the whole body if it can't be parsed
+func responseMessage(body []byte) string {
+	var keystoneErr struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &keystoneErr); err == nil && keystoneErr.Error.Message != "" {
+		return keystoneErr.Error.Message
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,92 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	golangsdk "github.com/gophercloud/gophercloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func keystoneResponse(code int, body string) golangsdk.ErrUnexpectedResponseCode {
+	return golangsdk.ErrUnexpectedResponseCode{
+		Method:         http.MethodPost,
+		URL:            "https://keystone/v3/users",
+		Actual:         code,
+		Body:           []byte(body),
+		ResponseHeader: http.Header{RequestIDHeader: []string{"req-1234"}},
+	}
+}
+
+func TestKeystoneErrorf(t *testing.T) {
+	cases := map[string]struct {
+		err       error
+		kind      ErrorKind
+		vaultCode int
+	}{
+		"bad-request":  {golangsdk.ErrDefault400{ErrUnexpectedResponseCode: keystoneResponse(400, "")}, KindBadRequest, 400},
+		"unauthorized": {golangsdk.ErrDefault401{ErrUnexpectedResponseCode: keystoneResponse(401, "")}, KindUnauthorized, 502},
+		"forbidden":    {golangsdk.ErrDefault403{ErrUnexpectedResponseCode: keystoneResponse(403, "")}, KindForbidden, 502},
+		"not-found":    {golangsdk.ErrDefault404{ErrUnexpectedResponseCode: keystoneResponse(404, "")}, KindNotFound, 404},
+		"conflict":     {golangsdk.ErrDefault409{ErrUnexpectedResponseCode: keystoneResponse(409, "")}, KindConflict, 409},
+		"rate-limited": {golangsdk.ErrDefault429{ErrUnexpectedResponseCode: keystoneResponse(429, "")}, KindRateLimited, 429},
+		"unavailable":  {golangsdk.ErrDefault503{ErrUnexpectedResponseCode: keystoneResponse(503, "")}, KindUnavailable, 503},
+		"server-error": {golangsdk.ErrDefault500{ErrUnexpectedResponseCode: keystoneResponse(500, "")}, KindInternal, 500},
+		"reauthenticated": {
+			&golangsdk.ErrErrorAfterReauthentication{ErrOriginal: golangsdk.ErrDefault404{ErrUnexpectedResponseCode: keystoneResponse(404, "")}},
+			KindNotFound, 404,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			err := KeystoneErrorf(c.err, "error creating a temporary user")
+
+			var keystoneErr *KeystoneError
+			require.ErrorAs(t, err, &keystoneErr)
+			assert.Equal(t, c.kind, keystoneErr.Kind)
+			assert.Equal(t, c.vaultCode, keystoneErr.Code())
+			assert.Equal(t, "req-1234", keystoneErr.RequestID)
+			assert.Contains(t, err.Error(), "error creating a temporary user: Keystone responded with")
+			assert.Contains(t, err.Error(), "(request ID: req-1234)")
+		})
+	}
+}
+
+func TestKeystoneErrorf_message(t *testing.T) {
+	src := golangsdk.ErrDefault409{ErrUnexpectedResponseCode: keystoneResponse(409,
+		`{"error": {"code": 409, "message": "Duplicate entry found with name vault-user", "title": "Conflict"}}`)}
+
+	err := KeystoneErrorf(src, "error creating a temporary user")
+	assert.Equal(t, "error creating a temporary user: Keystone responded with 409 Conflict: "+
+		"Duplicate entry found with name vault-user (request ID: req-1234)", err.Error())
+
+	wrapped := KeystoneErrorf(fmt.Errorf("no root identity can be used: %w", err), "error getting root client")
+	assert.Equal(t, KindConflict, ErrorKindOf(wrapped))
+	assert.Equal(t, "req-1234", RequestIDOf(wrapped))
+	assert.Equal(t, "error getting root client: no root identity can be used: "+err.Error(), wrapped.Error())
+	assert.ErrorIs(t, wrapped, err)
+}
+
+func TestKeystoneErrorf_other(t *testing.T) {
+	assert.NoError(t, KeystoneErrorf(nil, "context"))
+
+	connErr := &url.Error{Op: "Post", URL: "https://keystone/v3/auth/tokens", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	err := KeystoneErrorf(connErr, "error creating provider client")
+	assert.Equal(t, KindUnavailable, ErrorKindOf(err))
+	assert.Empty(t, RequestIDOf(err))
+
+	err = KeystoneErrorf(errors.New("storage failure"), "")
+	assert.Equal(t, KindInternal, ErrorKindOf(err))
+	assert.Equal(t, "storage failure", err.Error())
+
+	notFound := NewError(KindNotFound, "role `%s` doesn't exist", "test")
+	assert.Equal(t, http.StatusNotFound, notFound.Code())
+	assert.Equal(t, "role `test` doesn't exist", notFound.Error())
+	assert.Equal(t, notFound, KeystoneErrorf(notFound, ""))
+}
@@ -1,8 +1,6 @@
 package common
 
 import (
-	"fmt"
-	golangsdk "github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/groups"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/roles"
 )
@@ -37,35 +35,3 @@ func sliceSubtraction(a, b []string) (diff []string) {
 	}
 	return
 }
-
-func LogHttpError(err error) error {
-	switch httpErr := err.(type) {
-	case golangsdk.ErrDefault400:
-		return fmt.Errorf("%s\n %s", httpErr.Error(), httpErr.Body)
-	case golangsdk.ErrDefault401:
-		return fmt.Errorf("%s\n %s", httpErr.Error(), httpErr.Body)
-	case golangsdk.ErrDefault403:
-		return fmt.Errorf("%s\n %s", httpErr.Error(), httpErr.Body)
-	case golangsdk.ErrDefault404:
-		return fmt.Errorf("%s\n %s", httpErr.Error(), httpErr.Body)
-	case golangsdk.ErrDefault405:
-		return fmt.Errorf("%s\n %s", httpErr.Error(), httpErr.Body)
-	case golangsdk.ErrDefault408:
-		return fmt.Errorf("%s\n %s", httpErr.Error(), httpErr.Body)
-	case golangsdk.ErrDefault409:
-		return fmt.Errorf("%s\n %s", httpErr.Error(), httpErr.Body)
-	case golangsdk.ErrDefault429:
-		return fmt.Errorf("%s\n %s", httpErr.Error(), httpErr.Body)
-	case golangsdk.ErrDefault500:
-		return fmt.Errorf("%s\n %s", httpErr.Error(), httpErr.Body)
-	case golangsdk.ErrDefault502:
-		return fmt.Errorf("%s\n %s", httpErr.Error(), httpErr.Body)
-	case golangsdk.ErrDefault503:
-		return fmt.Errorf("%s\n %s", httpErr.Error(), httpErr.Body)
-	case golangsdk.ErrDefault504:
-		return fmt.Errorf("%s\n %s", httpErr.Error(), httpErr.Body)
-	case golangsdk.ErrUnexpectedResponseCode:
-		return fmt.Errorf("%s\n %s", httpErr.Error(), httpErr.Body)
-	}
-	return err
-}
@@ -63,6 +63,9 @@ func handleCreateToken(t *testing.T, w http.ResponseWriter, r *http.Request) {
 {
   "token": {
     "expires_at": "2014-10-02T13:45:00.000000Z",
+    "audit_ids": [
+      "VcxU2JYqT8OzfUVvrjEITQ"
+    ],
     "catalog": [
       {
         "endpoints": [
@@ -329,6 +332,43 @@ func handleListGroups(t *testing.T, w http.ResponseWriter, r *http.Request) {
 `)
 }
 
+func handleListRoles(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	t.Helper()
+
+	th.TestHeader(t, r, "Accept", "application/json")
+	th.TestMethod(t, r, "GET")
+
+	w.Header().Add("Content-Type", "application/json")
+
+	_, _ = fmt.Fprint(w, `
+{
+    "roles": [
+        {
+            "domain_id": null,
+            "id": "7d7d81cdaad4475e96d34817f1632eca",
+            "name": "reader",
+            "links": {
+                "self": "https://example.com/v3/roles/7d7d81cdaad4475e96d34817f1632eca"
+            }
+        },
+        {
+            "domain_id": null,
+            "id": "72badea89a5d4d9cb97a4d13e8d8c486",
+            "name": "member",
+            "links": {
+                "self": "https://example.com/v3/roles/72badea89a5d4d9cb97a4d13e8d8c486"
+            }
+        }
+    ],
+    "links": {
+        "next": null,
+        "previous": null,
+        "self": "https://example.com/v3/roles"
+    }
+}
+`)
+}
+
 func handleProjectList(t *testing.T, w http.ResponseWriter, r *http.Request, projectName string) {
 	t.Helper()
 
@@ -407,6 +447,63 @@ func handleDomainList(t *testing.T, w http.ResponseWriter, r *http.Request, proj
 }`, projectName)
 }
 
+func handleCreateApplicationCredential(t *testing.T, w http.ResponseWriter, r *http.Request, userID string) {
+	t.Helper()
+
+	th.TestHeader(t, r, "Content-Type", "application/json")
+	th.TestHeader(t, r, "Accept", "application/json")
+	th.TestMethod(t, r, "POST")
+
+	w.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprintf(w, `
+{
+    "application_credential": {
+        "description": "Vault's root application credential",
+        "expires_at": null,
+        "id": "58d61ff8e6e34accb35874016d1dba8b",
+        "name": "vault-root",
+        "project_id": "231c62fb0fbd485b995e8b060c3f0d98",
+        "secret": "rEaqvJka48mpv",
+        "unrestricted": true,
+        "links": {
+            "self": "https://example.com/identity/v3/users/%[1]s/application_credentials/58d61ff8e6e34accb35874016d1dba8b"
+        }
+    }
+}
+`, userID)
+}
+
+func handleListApplicationCredentials(t *testing.T, w http.ResponseWriter, r *http.Request, userID string) {
+	t.Helper()
+
+	th.TestHeader(t, r, "Accept", "application/json")
+	th.TestMethod(t, r, "GET")
+
+	w.Header().Add("Content-Type", "application/json")
+
+	_, _ = fmt.Fprintf(w, `
+{
+    "application_credentials": [
+        {
+            "description": "",
+            "expires_at": null,
+            "id": "c4859fb437df4b87a51a8f5adcfb0bc7",
+            "name": "%[2]s",
+            "project_id": "231c62fb0fbd485b995e8b060c3f0d98",
+            "unrestricted": true,
+            "links": {
+                "self": "https://example.com/identity/v3/users/%[1]s/application_credentials/c4859fb437df4b87a51a8f5adcfb0bc7"
+            }
+        }
+    ],
+    "links": {
+        "next": null,
+        "previous": null
+    }
+}
+`, userID, r.URL.Query().Get("name"))
+}
+
 type EnabledMocks struct {
 	TokenPost       bool
 	TokenGet        bool
@@ -420,6 +517,10 @@ type EnabledMocks struct {
 	UserGet         bool
 	GroupList       bool
 	AvailDomainList bool
+	RoleList        bool
+	AppCredPost     bool
+	AppCredList     bool
+	AppCredDelete   bool
 }
 
 func SetupKeystoneMock(t *testing.T, userID, projectName string, enabled EnabledMocks) {
@@ -505,6 +606,34 @@ func SetupKeystoneMock(t *testing.T, userID, projectName string, enabled Enabled
 		}
 	})
 
+	th.Mux.HandleFunc(fmt.Sprintf("/v3/users/%s/application_credentials", userID), func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			if enabled.AppCredPost {
+				handleCreateApplicationCredential(t, w, r, userID)
+			}
+		case "GET":
+			if enabled.AppCredList {
+				handleListApplicationCredentials(t, w, r, userID)
+			}
+		default:
+			w.WriteHeader(404)
+		}
+	})
+
+	th.Mux.HandleFunc(fmt.Sprintf("/v3/users/%s/application_credentials/", userID), func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "DELETE":
+			if enabled.AppCredDelete {
+				th.TestMethod(t, r, "DELETE")
+
+				w.WriteHeader(http.StatusNoContent)
+			}
+		default:
+			w.WriteHeader(404)
+		}
+	})
+
 	th.Mux.HandleFunc("/v3/groups", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case "GET":
@@ -516,6 +645,12 @@ func SetupKeystoneMock(t *testing.T, userID, projectName string, enabled Enabled
 		}
 	})
 
+	if enabled.RoleList {
+		th.Mux.HandleFunc("/v3/roles", func(w http.ResponseWriter, r *http.Request) {
+			handleListRoles(t, w, r)
+		})
+	}
+
 	th.Mux.HandleFunc("/v3/auth/domains", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case "GET":
@@ -0,0 +1,1681 @@
+package fixtures
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+)
+
+const (
+	// KeystoneDefaultDomainID is the ID of the domain created with every Keystone emulator
+	KeystoneDefaultDomainID = "default"
+	// KeystoneAdminRole is the role required for managing identities of the domain
+	KeystoneAdminRole = "admin"
+
+	defaultKeystoneTokenTTL = time.Hour
+	// keystoneTimeFormat is the format of expiration times of application credentials
+	keystoneTimeFormat = "2006-01-02T15:04:05.999999"
+	// keystoneTrustTimeFormat is the format of expiration times of trusts
+	keystoneTrustTimeFormat = "2006-01-02T15:04:05.000000Z"
+)
+
+// Domain is a Keystone domain
+type Domain struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+// Project is a Keystone project
+type Project struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	DomainID string `json:"domain_id"`
+	Enabled  bool   `json:"enabled"`
+}
+
+// User is a Keystone user
+type User struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	DomainID         string `json:"domain_id"`
+	DefaultProjectID string `json:"default_project_id,omitempty"`
+	Description      string `json:"description"`
+	Enabled          bool   `json:"enabled"`
+}
+
+// Group is a Keystone group
+type Group struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DomainID    string `json:"domain_id"`
+	Description string `json:"description"`
+}
+
+// Role is a global Keystone role
+type Role struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// RoleAssignment is a role of the user or the group in the project, the domain or the system
+type RoleAssignment struct {
+	RoleID    string
+	UserID    string
+	GroupID   string
+	ProjectID string
+	DomainID  string
+	// System is set for assignments on the system
+	System bool
+	// Inherited is set for assignments inherited to the projects of the domain or to the subprojects of the project
+	Inherited bool
+}
+
+// ApplicationCredential is an application credential of the user
+type ApplicationCredential struct {
+	ID           string       `json:"id"`
+	Name         string       `json:"name"`
+	Description  string       `json:"description"`
+	ProjectID    string       `json:"project_id"`
+	Unrestricted bool         `json:"unrestricted"`
+	AccessRules  []AccessRule `json:"access_rules,omitempty"`
+	// ExpiresAt is zero for credentials which don't expire
+	ExpiresAt time.Time `json:"-"`
+
+	userID   string
+	domainID string
+	secret   string
+}
+
+// AccessRule limits requests the application credential can be used for
+type AccessRule struct {
+	Service string `json:"service"`
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+}
+
+// EC2Credential is an EC2 credential of the user for the project
+type EC2Credential struct {
+	UserID    string `json:"user_id"`
+	ProjectID string `json:"tenant_id"`
+	Access    string `json:"access"`
+	Secret    string `json:"secret"`
+}
+
+// Trust delegates roles of the trustor in the project to the trustee
+type Trust struct {
+	ID            string
+	TrustorUserID string
+	TrusteeUserID string
+	ProjectID     string
+	Impersonation bool
+	// RoleIDs are IDs of the delegated roles
+	RoleIDs []string
+	// ExpiresAt is zero for trusts which don't expire
+	ExpiresAt time.Time
+}
+
+type keystoneUser struct {
+	User
+	password string
+	groups   map[string]bool
+}
+
+type keystoneToken struct {
+	id        string
+	auditID   string
+	methods   []string
+	userID    string
+	projectID string
+	domainID  string
+	system    bool
+	appCredID string
+	trustID   string
+	issuedAt  time.Time
+	expiresAt time.Time
+}
+
+// Fault describes a failure injected into the responses of the Keystone emulator
+type Fault struct {
+	// Method and PathPrefix select affected requests, empty values match all requests.
+	// PathPrefix includes the version, e.g. `/v3/users`.
+	Method     string
+	PathPrefix string
+	// StatusCode is returned instead of handling the request, e.g. 429 or 503.
+	// Requests are only delayed if no status code is set.
+	StatusCode int
+	// RetryAfter is returned in the Retry-After header of the failed response
+	RetryAfter time.Duration
+	// Latency delays the response
+	Latency time.Duration
+	// Times is the number of affected requests, all matching requests are affected if not set
+	Times int
+}
+
+func (f *Fault) matches(r *http.Request) bool {
+	if f.Method != "" && f.Method != r.Method {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, f.PathPrefix)
+}
+
+// Keystone is a stateful in-process Keystone v3 emulator. It keeps identities, role assignments, tokens
+// and application credentials in memory, enforces token scopes and supports fault injection.
+//
+// Identity management requires a token scoped to the domain or the project with the `admin` role,
+// other operations require a valid token only.
+type Keystone struct {
+	// TokenTTL is the lifetime of issued tokens, one hour by default
+	TokenTTL time.Duration
+
+	server *httptest.Server
+
+	lock        sync.Mutex
+	domains     map[string]*Domain
+	projects    map[string]*Project
+	users       map[string]*keystoneUser
+	groups      map[string]*Group
+	roles       map[string]*Role
+	assignments []RoleAssignment
+	tokens      map[string]*keystoneToken
+	appCreds    map[string]*ApplicationCredential
+	ec2Creds    map[string]*EC2Credential
+	trusts      map[string]*Trust
+	// endpoints are added to the catalog of issued tokens
+	endpoints map[string]string
+	faults    []*Fault
+	requests  []string
+	// globalRequestIDs are request IDs passed by the clients
+	globalRequestIDs []string
+}
+
+// NewKeystone starts Keystone emulator containing the `Default` domain. The server has to be closed after use.
+func NewKeystone() *Keystone {
+	k := &Keystone{
+		TokenTTL:  defaultKeystoneTokenTTL,
+		domains:   make(map[string]*Domain),
+		projects:  make(map[string]*Project),
+		users:     make(map[string]*keystoneUser),
+		groups:    make(map[string]*Group),
+		roles:     make(map[string]*Role),
+		tokens:    make(map[string]*keystoneToken),
+		appCreds:  make(map[string]*ApplicationCredential),
+		ec2Creds:  make(map[string]*EC2Credential),
+		trusts:    make(map[string]*Trust),
+		endpoints: make(map[string]string),
+	}
+	k.domains[KeystoneDefaultDomainID] = &Domain{ID: KeystoneDefaultDomainID, Name: "Default", Enabled: true}
+	k.server = httptest.NewServer(k)
+	return k
+}
+
+// SetupKeystone starts Keystone emulator closed at the end of the test
+func SetupKeystone(t *testing.T) *Keystone {
+	t.Helper()
+
+	k := NewKeystone()
+	t.Cleanup(k.Close)
+	return k
+}
+
+// Close stops the server
+func (k *Keystone) Close() {
+	k.server.Close()
+}
+
+// AuthURL returns the Keystone v3 endpoint
+func (k *Keystone) AuthURL() string {
+	return k.server.URL + "/v3"
+}
+
+// AddDomain creates a new domain
+func (k *Keystone) AddDomain(name string) Domain {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	domain := &Domain{ID: newKeystoneID(), Name: name, Enabled: true}
+	k.domains[domain.ID] = domain
+	return *domain
+}
+
+// AddProject creates a new project in the domain
+func (k *Keystone) AddProject(domainID, name string) Project {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	project := &Project{ID: newKeystoneID(), Name: name, DomainID: domainID, Enabled: true}
+	k.projects[project.ID] = project
+	return *project
+}
+
+// AddUser creates a new user in the domain
+func (k *Keystone) AddUser(domainID, name, password string) User {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	user := &keystoneUser{
+		User:     User{ID: newKeystoneID(), Name: name, DomainID: domainID, Enabled: true},
+		password: password,
+		groups:   make(map[string]bool),
+	}
+	k.users[user.ID] = user
+	return user.User
+}
+
+// AddAdmin creates a new user having the `admin` role in the domain
+func (k *Keystone) AddAdmin(domainID, name, password string) User {
+	user := k.AddUser(domainID, name, password)
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	role := k.findRole(KeystoneAdminRole)
+	if role == nil {
+		role = &Role{ID: newKeystoneID(), Name: KeystoneAdminRole}
+		k.roles[role.ID] = role
+	}
+	k.assignments = append(k.assignments, RoleAssignment{RoleID: role.ID, UserID: user.ID, DomainID: domainID})
+	return user
+}
+
+// AddGroup creates a new group in the domain
+func (k *Keystone) AddGroup(domainID, name string) Group {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	group := &Group{ID: newKeystoneID(), Name: name, DomainID: domainID}
+	k.groups[group.ID] = group
+	return *group
+}
+
+// AddRole creates a new role
+func (k *Keystone) AddRole(name string) Role {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	role := &Role{ID: newKeystoneID(), Name: name}
+	k.roles[role.ID] = role
+	return *role
+}
+
+// Assign adds the role assignment
+func (k *Keystone) Assign(assignment RoleAssignment) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.assignments = append(k.assignments, assignment)
+}
+
+// User returns the user by ID
+func (k *Keystone) User(id string) (User, bool) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	user, ok := k.users[id]
+	if !ok {
+		return User{}, false
+	}
+	return user.User, true
+}
+
+// Users returns all users
+func (k *Keystone) Users() []User {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	result := make([]User, 0, len(k.users))
+	for _, user := range k.users {
+		result = append(result, user.User)
+	}
+	return result
+}
+
+// ApplicationCredentials returns application credentials of the user
+func (k *Keystone) ApplicationCredentials(userID string) []ApplicationCredential {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	var result []ApplicationCredential
+	for _, credential := range k.appCreds {
+		if credential.userID == userID {
+			result = append(result, *credential)
+		}
+	}
+	return result
+}
+
+// EC2Credentials returns EC2 credentials of the user
+func (k *Keystone) EC2Credentials(userID string) []EC2Credential {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	var result []EC2Credential
+	for _, credential := range k.ec2Creds {
+		if credential.UserID == userID {
+			result = append(result, *credential)
+		}
+	}
+	return result
+}
+
+// Trusts returns trusts delegated by the trustor
+func (k *Keystone) Trusts(trustorUserID string) []Trust {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	var result []Trust
+	for _, trust := range k.trusts {
+		if trust.TrustorUserID == trustorUserID {
+			result = append(result, *trust)
+		}
+	}
+	return result
+}
+
+// AddEndpoint adds public endpoint of the service of the given type to the catalog
+func (k *Keystone) AddEndpoint(serviceType, url string) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.endpoints[serviceType] = url
+}
+
+// UserGroups returns IDs of the groups the user is member of
+func (k *Keystone) UserGroups(userID string) []string {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	user, ok := k.users[userID]
+	if !ok {
+		return nil
+	}
+	var groupIDs []string
+	for id := range user.groups {
+		groupIDs = append(groupIDs, id)
+	}
+	return groupIDs
+}
+
+// RoleAssignments returns role assignments of the user
+func (k *Keystone) RoleAssignments(userID string) []RoleAssignment {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	var assignments []RoleAssignment
+	for _, assignment := range k.assignments {
+		if assignment.UserID == userID {
+			assignments = append(assignments, assignment)
+		}
+	}
+	return assignments
+}
+
+// TokenValid checks if the token is issued and neither revoked nor expired
+func (k *Keystone) TokenValid(id string) bool {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	return k.validToken(id) != nil
+}
+
+// InjectFault makes the emulator fail or delay matching requests
+func (k *Keystone) InjectFault(fault Fault) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.faults = append(k.faults, &fault)
+}
+
+// ClearFaults removes all injected faults
+func (k *Keystone) ClearFaults() {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.faults = nil
+}
+
+// RequestCount returns number of received requests matching the method and the path prefix,
+// empty values match all requests
+func (k *Keystone) RequestCount(method, pathPrefix string) int {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	count := 0
+	for _, request := range k.requests {
+		parts := strings.SplitN(request, " ", 2)
+		if (method == "" || parts[0] == method) && strings.HasPrefix(parts[1], pathPrefix) {
+			count++
+		}
+	}
+	return count
+}
+
+// GlobalRequestIDs returns request IDs passed by the clients in the X-Openstack-Request-Id header
+func (k *Keystone) GlobalRequestIDs() []string {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	return append([]string(nil), k.globalRequestIDs...)
+}
+
+// fault returns the fault injected for the request
+func (k *Keystone) fault(r *http.Request) *Fault {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.requests = append(k.requests, r.Method+" "+r.URL.Path)
+	if id := r.Header.Get("X-Openstack-Request-Id"); id != "" {
+		k.globalRequestIDs = append(k.globalRequestIDs, id)
+	}
+	for i, fault := range k.faults {
+		if !fault.matches(r) {
+			continue
+		}
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				k.faults = append(k.faults[:i], k.faults[i+1:]...)
+			}
+		}
+		result := *fault
+		return &result
+	}
+	return nil
+}
+
+func (k *Keystone) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Openstack-Request-Id", "req-"+newKeystoneRequestID())
+
+	if fault := k.fault(r); fault != nil {
+		time.Sleep(fault.Latency)
+		if fault.StatusCode != 0 {
+			if fault.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(fault.RetryAfter.Seconds())))
+			}
+			writeKeystoneError(w, fault.StatusCode, "injected fault")
+			return
+		}
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v3"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "auth/tokens":
+		k.handleTokens(w, r)
+	case path == "auth/domains" && r.Method == http.MethodGet:
+		k.handleAuthDomains(w, r)
+	case path == "users":
+		k.handleUsers(w, r)
+	case len(parts) == 2 && parts[0] == "users":
+		k.handleUser(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "password" && r.Method == http.MethodPost:
+		k.handleChangePassword(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "application_credentials":
+		k.handleApplicationCredentials(w, r, parts[1])
+	case len(parts) == 4 && parts[0] == "users" && parts[2] == "application_credentials" && r.Method == http.MethodDelete:
+		k.handleDeleteApplicationCredential(w, r, parts[1], parts[3])
+	case len(parts) == 4 && parts[0] == "users" && parts[2] == "credentials" && parts[3] == "OS-EC2":
+		k.handleEC2Credentials(w, r, parts[1])
+	case len(parts) == 5 && parts[0] == "users" && parts[2] == "credentials" && parts[3] == "OS-EC2" && r.Method == http.MethodDelete:
+		k.handleDeleteEC2Credential(w, r, parts[1], parts[4])
+	case len(parts) == 4 && parts[0] == "groups" && parts[2] == "users" && r.Method == http.MethodPut:
+		k.handleAddToGroup(w, r, parts[1], parts[3])
+	case path == "OS-TRUST/trusts" && r.Method == http.MethodPost:
+		k.handleCreateTrust(w, r)
+	case len(parts) == 3 && parts[0] == "OS-TRUST" && parts[1] == "trusts" && r.Method == http.MethodDelete:
+		k.handleDeleteTrust(w, r, parts[2])
+	case path == "groups" && r.Method == http.MethodGet:
+		k.handleListGroups(w, r)
+	case path == "roles" && r.Method == http.MethodGet:
+		k.handleListRoles(w, r)
+	case path == "projects" && r.Method == http.MethodGet:
+		k.handleListProjects(w, r)
+	case path == "role_assignments" && r.Method == http.MethodGet:
+		k.handleListRoleAssignments(w, r)
+	case len(parts) == 4 && parts[0] == "system" && parts[1] == "users" && parts[3] == "roles" && r.Method == http.MethodGet:
+		k.handleListSystemRoles(w, r, parts[2])
+	case len(parts) == 5 && parts[0] == "system" && parts[1] == "users" && parts[3] == "roles" && r.Method == http.MethodPut:
+		k.handleAssignSystemRole(w, r, parts[2], parts[4])
+	case len(parts) == 6 && (parts[0] == "projects" || parts[0] == "domains") && parts[4] == "roles" && r.Method == http.MethodPut:
+		k.handleAssignRole(w, r, parts, false)
+	case len(parts) == 8 && parts[0] == "OS-INHERIT" && (parts[1] == "projects" || parts[1] == "domains") &&
+		parts[5] == "roles" && parts[7] == "inherited_to_projects" && r.Method == http.MethodPut:
+		k.handleAssignRole(w, r, parts[1:7], true)
+	default:
+		writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
+
+type authRequest struct {
+	Auth struct {
+		Identity struct {
+			Methods  []string `json:"methods"`
+			Password struct {
+				User authUser `json:"user"`
+			} `json:"password"`
+			ApplicationCredential struct {
+				ID     string   `json:"id"`
+				Name   string   `json:"name"`
+				Secret string   `json:"secret"`
+				User   authUser `json:"user"`
+			} `json:"application_credential"`
+			Token struct {
+				ID string `json:"id"`
+			} `json:"token"`
+		} `json:"identity"`
+		Scope *struct {
+			Project *struct {
+				ID     string     `json:"id"`
+				Name   string     `json:"name"`
+				Domain authDomain `json:"domain"`
+			} `json:"project"`
+			Domain *authDomain `json:"domain"`
+			System *struct {
+				All bool `json:"all"`
+			} `json:"system"`
+			Trust *struct {
+				ID string `json:"id"`
+			} `json:"OS-TRUST:trust"`
+		} `json:"scope"`
+	} `json:"auth"`
+}
+
+type authUser struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	Password string     `json:"password"`
+	Domain   authDomain `json:"domain"`
+}
+
+type authDomain struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func (k *Keystone) handleTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var req authRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeKeystoneError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		token, code, err := k.issueToken(&req)
+		if err != nil {
+			writeKeystoneError(w, code, err.Error())
+			return
+		}
+		w.Header().Set("X-Subject-Token", token.id)
+		writeKeystoneJSON(w, http.StatusCreated, map[string]interface{}{"token": k.renderToken(token)})
+	case http.MethodGet, http.MethodHead:
+		if _, ok := k.authorize(w, r, false); !ok {
+			return
+		}
+		token := k.validToken(r.Header.Get("X-Subject-Token"))
+		if token == nil {
+			writeKeystoneError(w, http.StatusNotFound, "token not found")
+			return
+		}
+		writeKeystoneJSON(w, http.StatusOK, map[string]interface{}{"token": k.renderToken(token)})
+	case http.MethodDelete:
+		if _, ok := k.authorize(w, r, false); !ok {
+			return
+		}
+		id := r.Header.Get("X-Subject-Token")
+		if k.validToken(id) == nil {
+			writeKeystoneError(w, http.StatusNotFound, "token not found")
+			return
+		}
+		delete(k.tokens, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeKeystoneError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// issueToken authenticates the request and returns the new token together with the error status code
+func (k *Keystone) issueToken(req *authRequest) (*keystoneToken, int, error) {
+	identity := req.Auth.Identity
+	token := &keystoneToken{
+		id:       newKeystoneID(),
+		auditID:  newKeystoneID()[:22],
+		methods:  identity.Methods,
+		issuedAt: time.Now().UTC(),
+	}
+	token.expiresAt = token.issuedAt.Add(k.TokenTTL)
+
+	var method string
+	if len(identity.Methods) > 0 {
+		method = identity.Methods[0]
+	}
+	switch method {
+	case "password":
+		user := k.findAuthUser(identity.Password.User)
+		if user == nil || user.password != identity.Password.User.Password {
+			return nil, http.StatusUnauthorized, fmt.Errorf("the request you have made requires authentication")
+		}
+		token.userID = user.ID
+	case "application_credential":
+		credential := k.findAuthApplicationCredential(identity.ApplicationCredential.ID, identity.ApplicationCredential.Name, identity.ApplicationCredential.User)
+		if credential == nil || credential.secret != identity.ApplicationCredential.Secret ||
+			(!credential.ExpiresAt.IsZero() && time.Now().After(credential.ExpiresAt)) {
+			return nil, http.StatusUnauthorized, fmt.Errorf("the request you have made requires authentication")
+		}
+		if req.Auth.Scope != nil {
+			return nil, http.StatusUnauthorized, fmt.Errorf("application credentials can't request a scope")
+		}
+		token.userID = credential.userID
+		token.appCredID = credential.ID
+		token.projectID = credential.ProjectID
+		token.domainID = credential.domainID
+		return k.saveToken(token)
+	case "token":
+		existing := k.validToken(identity.Token.ID)
+		if existing == nil {
+			return nil, http.StatusUnauthorized, fmt.Errorf("the request you have made requires authentication")
+		}
+		token.userID = existing.userID
+		token.auditID = existing.auditID
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("unsupported authentication method `%s`", method)
+	}
+
+	if user := k.users[token.userID]; !user.Enabled {
+		return nil, http.StatusUnauthorized, fmt.Errorf("user `%s` is disabled", user.Name)
+	}
+
+	if scope := req.Auth.Scope; scope != nil {
+		switch {
+		case scope.Project != nil:
+			project := k.findProject(scope.Project.ID, scope.Project.Name, scope.Project.Domain)
+			if project == nil {
+				return nil, http.StatusUnauthorized, fmt.Errorf("project is not found")
+			}
+			token.projectID = project.ID
+		case scope.Domain != nil:
+			domain := k.findDomain(*scope.Domain)
+			if domain == nil {
+				return nil, http.StatusUnauthorized, fmt.Errorf("domain is not found")
+			}
+			token.domainID = domain.ID
+		case scope.System != nil:
+			if !scope.System.All {
+				return nil, http.StatusBadRequest, fmt.Errorf("only the `all` system scope is supported")
+			}
+			token.system = true
+		case scope.Trust != nil:
+			trust, ok := k.trusts[scope.Trust.ID]
+			if !ok || (!trust.ExpiresAt.IsZero() && time.Now().After(trust.ExpiresAt)) {
+				return nil, http.StatusUnauthorized, fmt.Errorf("trust is not found")
+			}
+			if trust.TrusteeUserID != token.userID {
+				return nil, http.StatusForbidden, fmt.Errorf("user `%s` is not the trustee", token.userID)
+			}
+			token.trustID = trust.ID
+			token.projectID = trust.ProjectID
+			if !trust.ExpiresAt.IsZero() && trust.ExpiresAt.Before(token.expiresAt) {
+				token.expiresAt = trust.ExpiresAt
+			}
+		}
+		if len(k.tokenRoles(token)) == 0 {
+			return nil, http.StatusUnauthorized, fmt.Errorf("user `%s` has no access to the requested scope", token.userID)
+		}
+	}
+
+	return k.saveToken(token)
+}
+
+func (k *Keystone) saveToken(token *keystoneToken) (*keystoneToken, int, error) {
+	k.tokens[token.id] = token
+	return token, http.StatusCreated, nil
+}
+
+func (k *Keystone) findAuthUser(auth authUser) *keystoneUser {
+	if auth.ID != "" {
+		return k.users[auth.ID]
+	}
+	domain := k.findDomain(auth.Domain)
+	if domain == nil {
+		return nil
+	}
+	for _, user := range k.users {
+		if user.Name == auth.Name && user.DomainID == domain.ID {
+			return user
+		}
+	}
+	return nil
+}
+
+func (k *Keystone) findAuthApplicationCredential(id, name string, auth authUser) *ApplicationCredential {
+	if id != "" {
+		return k.appCreds[id]
+	}
+	user := k.findAuthUser(auth)
+	if user == nil {
+		return nil
+	}
+	for _, credential := range k.appCreds {
+		if credential.userID == user.ID && credential.Name == name {
+			return credential
+		}
+	}
+	return nil
+}
+
+func (k *Keystone) findDomain(auth authDomain) *Domain {
+	if auth.ID != "" {
+		return k.domains[auth.ID]
+	}
+	for _, domain := range k.domains {
+		if domain.Name == auth.Name {
+			return domain
+		}
+	}
+	return nil
+}
+
+func (k *Keystone) findProject(id, name string, domain authDomain) *Project {
+	if id != "" {
+		return k.projects[id]
+	}
+	d := k.findDomain(domain)
+	if d == nil {
+		return nil
+	}
+	for _, project := range k.projects {
+		if project.Name == name && project.DomainID == d.ID {
+			return project
+		}
+	}
+	return nil
+}
+
+func (k *Keystone) findRole(name string) *Role {
+	for _, role := range k.roles {
+		if role.Name == name {
+			return role
+		}
+	}
+	return nil
+}
+
+// tokenRoles returns roles of the token user in the token scope, directly assigned or inherited from groups
+func (k *Keystone) tokenRoles(token *keystoneToken) []*Role {
+	if token.projectID == "" && token.domainID == "" && !token.system {
+		return nil
+	}
+	if token.trustID != "" {
+		return k.trustRoles(k.trusts[token.trustID])
+	}
+	user, ok := k.users[token.userID]
+	if !ok {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var roles []*Role
+	for _, assignment := range k.assignments {
+		if assignment.UserID != user.ID && !user.groups[assignment.GroupID] {
+			continue
+		}
+		if !k.assignmentApplies(assignment, token) {
+			continue
+		}
+		if role, ok := k.roles[assignment.RoleID]; ok && !seen[role.ID] {
+			seen[role.ID] = true
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// assignmentApplies checks the assignment grants its role in the token scope. Inherited domain
+// assignments apply to the projects of the domain, there are no subprojects to inherit project assignments.
+func (k *Keystone) assignmentApplies(assignment RoleAssignment, token *keystoneToken) bool {
+	if assignment.Inherited {
+		project, ok := k.projects[token.projectID]
+		return ok && assignment.DomainID != "" && assignment.DomainID == project.DomainID
+	}
+	return assignment.ProjectID == token.projectID && assignment.DomainID == token.domainID && assignment.System == token.system
+}
+
+// trustRoles returns delegated roles of the trust the trustor still has in the project
+func (k *Keystone) trustRoles(trust *Trust) []*Role {
+	if trust == nil {
+		return nil
+	}
+	trustorRoles := k.tokenRoles(&keystoneToken{userID: trust.TrustorUserID, projectID: trust.ProjectID})
+	var roles []*Role
+	for _, id := range trust.RoleIDs {
+		for _, role := range trustorRoles {
+			if role.ID == id {
+				roles = append(roles, role)
+				break
+			}
+		}
+	}
+	return roles
+}
+
+func (k *Keystone) validToken(id string) *keystoneToken {
+	token, ok := k.tokens[id]
+	if !ok || time.Now().After(token.expiresAt) {
+		return nil
+	}
+	return token
+}
+
+// authorize checks the request token, identity management requires the admin role
+func (k *Keystone) authorize(w http.ResponseWriter, r *http.Request, admin bool) (*keystoneToken, bool) {
+	token := k.validToken(r.Header.Get("X-Auth-Token"))
+	if token == nil {
+		writeKeystoneError(w, http.StatusUnauthorized, "the request you have made requires authentication")
+		return nil, false
+	}
+	if !admin {
+		return token, true
+	}
+	for _, role := range k.tokenRoles(token) {
+		if role.Name == KeystoneAdminRole {
+			return token, true
+		}
+	}
+	writeKeystoneError(w, http.StatusForbidden, "you are not authorized to perform the requested action")
+	return nil, false
+}
+
+// revokeUserTokens revokes tokens of the user issued for the password
+func (k *Keystone) revokeUserTokens(userID string) {
+	for id, token := range k.tokens {
+		if token.userID == userID && token.appCredID == "" {
+			delete(k.tokens, id)
+		}
+	}
+}
+
+func (k *Keystone) renderToken(token *keystoneToken) map[string]interface{} {
+	user := k.users[token.userID]
+	rendered := map[string]interface{}{
+		"methods":    token.methods,
+		"audit_ids":  []string{token.auditID},
+		"issued_at":  token.issuedAt.Format(time.RFC3339),
+		"expires_at": token.expiresAt.Format(time.RFC3339),
+		"user": map[string]interface{}{
+			"id":     user.ID,
+			"name":   user.Name,
+			"domain": k.renderDomainRef(user.DomainID),
+		},
+		"catalog": k.renderCatalog(),
+	}
+	if token.projectID != "" {
+		project := k.projects[token.projectID]
+		rendered["project"] = map[string]interface{}{
+			"id":     project.ID,
+			"name":   project.Name,
+			"domain": k.renderDomainRef(project.DomainID),
+		}
+	}
+	if token.domainID != "" {
+		rendered["domain"] = k.renderDomainRef(token.domainID)
+	}
+	if token.system {
+		rendered["system"] = map[string]interface{}{"all": true}
+	}
+	if token.appCredID != "" {
+		rendered["application_credential"] = map[string]interface{}{
+			"id":         token.appCredID,
+			"name":       k.appCreds[token.appCredID].Name,
+			"restricted": !k.appCreds[token.appCredID].Unrestricted,
+		}
+	}
+	if token.trustID != "" {
+		trust := k.trusts[token.trustID]
+		rendered["OS-TRUST:trust"] = map[string]interface{}{
+			"id":            trust.ID,
+			"impersonation": trust.Impersonation,
+			"trustor_user":  map[string]interface{}{"id": trust.TrustorUserID},
+			"trustee_user":  map[string]interface{}{"id": trust.TrusteeUserID},
+		}
+	}
+	roles := make([]interface{}, 0)
+	for _, role := range k.tokenRoles(token) {
+		roles = append(roles, role)
+	}
+	rendered["roles"] = roles
+	return rendered
+}
+
+func (k *Keystone) renderCatalog() []interface{} {
+	endpoints := map[string]string{"identity": k.AuthURL()}
+	for serviceType, url := range k.endpoints {
+		endpoints[serviceType] = url
+	}
+	catalog := make([]interface{}, 0, len(endpoints))
+	for serviceType, url := range endpoints {
+		catalog = append(catalog, map[string]interface{}{
+			"id":   serviceType,
+			"type": serviceType,
+			"name": serviceType,
+			"endpoints": []interface{}{
+				map[string]interface{}{
+					"id":        serviceType + "-public",
+					"interface": "public",
+					"region":    "RegionOne",
+					"region_id": "RegionOne",
+					"url":       url,
+				},
+			},
+		})
+	}
+	return catalog
+}
+
+func (k *Keystone) renderDomainRef(id string) map[string]interface{} {
+	ref := map[string]interface{}{"id": id}
+	if domain, ok := k.domains[id]; ok {
+		ref["name"] = domain.Name
+	}
+	return ref
+}
+
+func (k *Keystone) handleAuthDomains(w http.ResponseWriter, r *http.Request) {
+	token, ok := k.authorize(w, r, false)
+	if !ok {
+		return
+	}
+	user := k.users[token.userID]
+
+	result := make([]interface{}, 0)
+	seen := make(map[string]bool)
+	for _, assignment := range k.assignments {
+		if assignment.DomainID == "" || assignment.Inherited || seen[assignment.DomainID] {
+			continue
+		}
+		if assignment.UserID == user.ID || user.groups[assignment.GroupID] {
+			seen[assignment.DomainID] = true
+			result = append(result, k.domains[assignment.DomainID])
+		}
+	}
+	writeKeystoneJSON(w, http.StatusOK, map[string]interface{}{"domains": result, "links": map[string]interface{}{}})
+}
+
+func (k *Keystone) handleUsers(w http.ResponseWriter, r *http.Request) {
+	if _, ok := k.authorize(w, r, true); !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		result := make([]interface{}, 0)
+		for _, user := range k.users {
+			if matchesQuery(query, "domain_id", user.DomainID) && matchesQuery(query, "name", user.Name) {
+				result = append(result, user.User)
+			}
+		}
+		writeKeystoneJSON(w, http.StatusOK, map[string]interface{}{"users": result, "links": map[string]interface{}{}})
+	case http.MethodPost:
+		var req struct {
+			User struct {
+				User
+				Password string `json:"password"`
+				Enabled  *bool  `json:"enabled"`
+			} `json:"user"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeKeystoneError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, ok := k.domains[req.User.DomainID]; !ok {
+			writeKeystoneError(w, http.StatusBadRequest, fmt.Sprintf("domain `%s` doesn't exist", req.User.DomainID))
+			return
+		}
+		for _, user := range k.users {
+			if user.Name == req.User.Name && user.DomainID == req.User.DomainID {
+				writeKeystoneError(w, http.StatusConflict, fmt.Sprintf("duplicate user `%s`", user.Name))
+				return
+			}
+		}
+		user := &keystoneUser{
+			User:     req.User.User,
+			password: req.User.Password,
+			groups:   make(map[string]bool),
+		}
+		user.ID = newKeystoneID()
+		user.Enabled = req.User.Enabled == nil || *req.User.Enabled
+		k.users[user.ID] = user
+		writeKeystoneJSON(w, http.StatusCreated, map[string]interface{}{"user": user.User})
+	default:
+		writeKeystoneError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (k *Keystone) handleUser(w http.ResponseWriter, r *http.Request, id string) {
+	if _, ok := k.authorize(w, r, true); !ok {
+		return
+	}
+	user, ok := k.users[id]
+	if !ok {
+		writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("user `%s` doesn't exist", id))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeKeystoneJSON(w, http.StatusOK, map[string]interface{}{"user": user.User})
+	case http.MethodPatch:
+		var req struct {
+			User struct {
+				Name        *string `json:"name"`
+				Description *string `json:"description"`
+				Enabled     *bool   `json:"enabled"`
+				Password    *string `json:"password"`
+			} `json:"user"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeKeystoneError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.User.Name != nil {
+			user.Name = *req.User.Name
+		}
+		if req.User.Description != nil {
+			user.Description = *req.User.Description
+		}
+		if req.User.Enabled != nil {
+			user.Enabled = *req.User.Enabled
+		}
+		if req.User.Password != nil {
+			user.password = *req.User.Password
+			k.revokeUserTokens(user.ID)
+		}
+		writeKeystoneJSON(w, http.StatusOK, map[string]interface{}{"user": user.User})
+	case http.MethodDelete:
+		delete(k.users, id)
+		for tokenID, token := range k.tokens {
+			if token.userID == id {
+				delete(k.tokens, tokenID)
+			}
+		}
+		for credID, credential := range k.appCreds {
+			if credential.userID == id {
+				delete(k.appCreds, credID)
+			}
+		}
+		for access, credential := range k.ec2Creds {
+			if credential.UserID == id {
+				delete(k.ec2Creds, access)
+			}
+		}
+		for trustID, trust := range k.trusts {
+			if trust.TrustorUserID == id || trust.TrusteeUserID == id {
+				k.deleteTrust(trustID)
+			}
+		}
+		assignments := k.assignments[:0]
+		for _, assignment := range k.assignments {
+			if assignment.UserID != id {
+				assignments = append(assignments, assignment)
+			}
+		}
+		k.assignments = assignments
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeKeystoneError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (k *Keystone) handleChangePassword(w http.ResponseWriter, r *http.Request, id string) {
+	var req struct {
+		User struct {
+			Password         string `json:"password"`
+			OriginalPassword string `json:"original_password"`
+		} `json:"user"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeKeystoneError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	user, ok := k.users[id]
+	if !ok {
+		writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("user `%s` doesn't exist", id))
+		return
+	}
+	if user.password != req.User.OriginalPassword {
+		writeKeystoneError(w, http.StatusUnauthorized, "invalid original password")
+		return
+	}
+	user.password = req.User.Password
+	k.revokeUserTokens(user.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (k *Keystone) handleApplicationCredentials(w http.ResponseWriter, r *http.Request, userID string) {
+	token, ok := k.authorize(w, r, false)
+	if !ok {
+		return
+	}
+	if token.userID != userID {
+		writeKeystoneError(w, http.StatusForbidden, "application credentials of other users can't be managed")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		name := r.URL.Query().Get("name")
+		result := make([]interface{}, 0)
+		for _, credential := range k.appCreds {
+			if credential.userID == userID && (name == "" || credential.Name == name) {
+				result = append(result, credential)
+			}
+		}
+		writeKeystoneJSON(w, http.StatusOK, map[string]interface{}{"application_credentials": result, "links": map[string]interface{}{}})
+	case http.MethodPost:
+		if token.appCredID != "" && !k.appCreds[token.appCredID].Unrestricted {
+			writeKeystoneError(w, http.StatusForbidden, "restricted application credentials can't create application credentials")
+			return
+		}
+		var req struct {
+			ApplicationCredential struct {
+				Name         string       `json:"name"`
+				Description  string       `json:"description"`
+				Secret       string       `json:"secret"`
+				Unrestricted bool         `json:"unrestricted"`
+				AccessRules  []AccessRule `json:"access_rules"`
+				ExpiresAt    string       `json:"expires_at"`
+			} `json:"application_credential"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeKeystoneError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var expiresAt time.Time
+		if req.ApplicationCredential.ExpiresAt != "" {
+			parsed, err := time.Parse(keystoneTimeFormat, req.ApplicationCredential.ExpiresAt)
+			if err != nil {
+				writeKeystoneError(w, http.StatusBadRequest, fmt.Sprintf("invalid expires_at: %s", err))
+				return
+			}
+			expiresAt = parsed
+		}
+		for _, credential := range k.appCreds {
+			if credential.userID == userID && credential.Name == req.ApplicationCredential.Name {
+				writeKeystoneError(w, http.StatusConflict, fmt.Sprintf("duplicate application credential `%s`", credential.Name))
+				return
+			}
+		}
+		credential := &ApplicationCredential{
+			ID:           newKeystoneID(),
+			Name:         req.ApplicationCredential.Name,
+			Description:  req.ApplicationCredential.Description,
+			Unrestricted: req.ApplicationCredential.Unrestricted,
+			AccessRules:  req.ApplicationCredential.AccessRules,
+			ExpiresAt:    expiresAt,
+			ProjectID:    token.projectID,
+			userID:       userID,
+			domainID:     token.domainID,
+			secret:       req.ApplicationCredential.Secret,
+		}
+		if credential.secret == "" {
+			credential.secret = newKeystoneID()
+		}
+		k.appCreds[credential.ID] = credential
+		created := map[string]interface{}{
+			"id":           credential.ID,
+			"name":         credential.Name,
+			"description":  credential.Description,
+			"project_id":   credential.ProjectID,
+			"unrestricted": credential.Unrestricted,
+			"access_rules": credential.AccessRules,
+			"expires_at":   nil,
+			"secret":       credential.secret,
+		}
+		if !credential.ExpiresAt.IsZero() {
+			created["expires_at"] = credential.ExpiresAt.Format(keystoneTimeFormat)
+		}
+		writeKeystoneJSON(w, http.StatusCreated, map[string]interface{}{"application_credential": created})
+	default:
+		writeKeystoneError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (k *Keystone) handleDeleteApplicationCredential(w http.ResponseWriter, r *http.Request, userID, id string) {
+	token, ok := k.authorize(w, r, false)
+	if !ok {
+		return
+	}
+	credential, ok := k.appCreds[id]
+	if !ok || credential.userID != userID || token.userID != userID {
+		writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("application credential `%s` doesn't exist", id))
+		return
+	}
+	delete(k.appCreds, id)
+	for tokenID, token := range k.tokens {
+		if token.appCredID == id {
+			delete(k.tokens, tokenID)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeOwner checks the token belongs either to the user or to the admin
+func (k *Keystone) authorizeOwner(w http.ResponseWriter, r *http.Request, userID string) bool {
+	token, ok := k.authorize(w, r, false)
+	if !ok {
+		return false
+	}
+	if token.userID == userID {
+		return true
+	}
+	for _, role := range k.tokenRoles(token) {
+		if role.Name == KeystoneAdminRole {
+			return true
+		}
+	}
+	writeKeystoneError(w, http.StatusForbidden, "you are not authorized to perform the requested action")
+	return false
+}
+
+func (k *Keystone) handleEC2Credentials(w http.ResponseWriter, r *http.Request, userID string) {
+	if !k.authorizeOwner(w, r, userID) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		result := make([]interface{}, 0)
+		for _, credential := range k.ec2Creds {
+			if credential.UserID == userID {
+				result = append(result, credential)
+			}
+		}
+		writeKeystoneJSON(w, http.StatusOK, map[string]interface{}{"credentials": result, "links": map[string]interface{}{}})
+	case http.MethodPost:
+		var req struct {
+			TenantID string `json:"tenant_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeKeystoneError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, ok := k.users[userID]; !ok {
+			writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("user `%s` doesn't exist", userID))
+			return
+		}
+		if _, ok := k.projects[req.TenantID]; !ok {
+			writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("project `%s` doesn't exist", req.TenantID))
+			return
+		}
+		if len(k.tokenRoles(&keystoneToken{userID: userID, projectID: req.TenantID})) == 0 {
+			writeKeystoneError(w, http.StatusUnauthorized, "user has no access to the project")
+			return
+		}
+		credential := &EC2Credential{
+			UserID:    userID,
+			ProjectID: req.TenantID,
+			Access:    newKeystoneID(),
+			Secret:    newKeystoneID(),
+		}
+		k.ec2Creds[credential.Access] = credential
+		writeKeystoneJSON(w, http.StatusCreated, map[string]interface{}{"credential": credential})
+	default:
+		writeKeystoneError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (k *Keystone) handleDeleteEC2Credential(w http.ResponseWriter, r *http.Request, userID, access string) {
+	if !k.authorizeOwner(w, r, userID) {
+		return
+	}
+	credential, ok := k.ec2Creds[access]
+	if !ok || credential.UserID != userID {
+		writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("EC2 credential `%s` doesn't exist", access))
+		return
+	}
+	delete(k.ec2Creds, access)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCreateTrust creates the trust on behalf of the trustor, delegated roles have to be assigned
+// to the trustor in the project
+func (k *Keystone) handleCreateTrust(w http.ResponseWriter, r *http.Request) {
+	token, ok := k.authorize(w, r, false)
+	if !ok {
+		return
+	}
+	var req struct {
+		Trust struct {
+			TrustorUserID string `json:"trustor_user_id"`
+			TrusteeUserID string `json:"trustee_user_id"`
+			ProjectID     string `json:"project_id"`
+			Impersonation bool   `json:"impersonation"`
+			ExpiresAt     string `json:"expires_at"`
+			Roles         []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"roles"`
+		} `json:"trust"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeKeystoneError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if token.userID != req.Trust.TrustorUserID || token.trustID != "" {
+		writeKeystoneError(w, http.StatusForbidden, "trusts can be created only by the trustor")
+		return
+	}
+	if _, ok := k.users[req.Trust.TrusteeUserID]; !ok {
+		writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("user `%s` doesn't exist", req.Trust.TrusteeUserID))
+		return
+	}
+	if _, ok := k.projects[req.Trust.ProjectID]; !ok {
+		writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("project `%s` doesn't exist", req.Trust.ProjectID))
+		return
+	}
+	if len(req.Trust.Roles) == 0 {
+		writeKeystoneError(w, http.StatusBadRequest, "at least one role has to be delegated")
+		return
+	}
+	trust := &Trust{
+		ID:            newKeystoneID(),
+		TrustorUserID: req.Trust.TrustorUserID,
+		TrusteeUserID: req.Trust.TrusteeUserID,
+		ProjectID:     req.Trust.ProjectID,
+		Impersonation: req.Trust.Impersonation,
+	}
+	if req.Trust.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, req.Trust.ExpiresAt)
+		if err != nil {
+			writeKeystoneError(w, http.StatusBadRequest, fmt.Sprintf("invalid expires_at: %s", err))
+			return
+		}
+		trust.ExpiresAt = expiresAt
+	}
+	trustorRoles := k.tokenRoles(&keystoneToken{userID: trust.TrustorUserID, projectID: trust.ProjectID})
+	roles := make([]interface{}, 0, len(req.Trust.Roles))
+	for _, requested := range req.Trust.Roles {
+		var delegated *Role
+		for _, role := range trustorRoles {
+			if role.ID == requested.ID || (requested.ID == "" && role.Name == requested.Name) {
+				delegated = role
+				break
+			}
+		}
+		if delegated == nil {
+			writeKeystoneError(w, http.StatusForbidden, fmt.Sprintf("role `%s%s` is not assigned to the trustor", requested.ID, requested.Name))
+			return
+		}
+		trust.RoleIDs = append(trust.RoleIDs, delegated.ID)
+		roles = append(roles, delegated)
+	}
+	k.trusts[trust.ID] = trust
+
+	created := map[string]interface{}{
+		"id":              trust.ID,
+		"trustor_user_id": trust.TrustorUserID,
+		"trustee_user_id": trust.TrusteeUserID,
+		"project_id":      trust.ProjectID,
+		"impersonation":   trust.Impersonation,
+		"roles":           roles,
+		"expires_at":      nil,
+	}
+	if !trust.ExpiresAt.IsZero() {
+		created["expires_at"] = trust.ExpiresAt.UTC().Format(keystoneTrustTimeFormat)
+	}
+	writeKeystoneJSON(w, http.StatusCreated, map[string]interface{}{"trust": created})
+}
+
+func (k *Keystone) handleDeleteTrust(w http.ResponseWriter, r *http.Request, id string) {
+	trust, ok := k.trusts[id]
+	if !ok {
+		if _, ok := k.authorize(w, r, false); ok {
+			writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("trust `%s` doesn't exist", id))
+		}
+		return
+	}
+	if !k.authorizeOwner(w, r, trust.TrustorUserID) {
+		return
+	}
+	k.deleteTrust(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteTrust removes the trust together with tokens issued for it
+func (k *Keystone) deleteTrust(id string) {
+	delete(k.trusts, id)
+	for tokenID, token := range k.tokens {
+		if token.trustID == id {
+			delete(k.tokens, tokenID)
+		}
+	}
+}
+
+func (k *Keystone) handleAddToGroup(w http.ResponseWriter, r *http.Request, groupID, userID string) {
+	if _, ok := k.authorize(w, r, true); !ok {
+		return
+	}
+	if _, ok := k.groups[groupID]; !ok {
+		writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("group `%s` doesn't exist", groupID))
+		return
+	}
+	user, ok := k.users[userID]
+	if !ok {
+		writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("user `%s` doesn't exist", userID))
+		return
+	}
+	user.groups[groupID] = true
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (k *Keystone) handleListGroups(w http.ResponseWriter, r *http.Request) {
+	if _, ok := k.authorize(w, r, true); !ok {
+		return
+	}
+	query := r.URL.Query()
+	result := make([]interface{}, 0)
+	for _, group := range k.groups {
+		if matchesQuery(query, "domain_id", group.DomainID) && matchesQuery(query, "name", group.Name) {
+			result = append(result, group)
+		}
+	}
+	writeKeystoneJSON(w, http.StatusOK, map[string]interface{}{"groups": result, "links": map[string]interface{}{}})
+}
+
+func (k *Keystone) handleListRoles(w http.ResponseWriter, r *http.Request) {
+	if _, ok := k.authorize(w, r, true); !ok {
+		return
+	}
+	query := r.URL.Query()
+	result := make([]interface{}, 0)
+	for _, role := range k.roles {
+		if matchesQuery(query, "name", role.Name) {
+			result = append(result, role)
+		}
+	}
+	writeKeystoneJSON(w, http.StatusOK, map[string]interface{}{"roles": result, "links": map[string]interface{}{}})
+}
+
+func (k *Keystone) handleListProjects(w http.ResponseWriter, r *http.Request) {
+	if _, ok := k.authorize(w, r, true); !ok {
+		return
+	}
+	query := r.URL.Query()
+	result := make([]interface{}, 0)
+	for _, project := range k.projects {
+		if matchesQuery(query, "domain_id", project.DomainID) && matchesQuery(query, "name", project.Name) {
+			result = append(result, project)
+		}
+	}
+	writeKeystoneJSON(w, http.StatusOK, map[string]interface{}{"projects": result, "links": map[string]interface{}{}})
+}
+
+func (k *Keystone) handleListRoleAssignments(w http.ResponseWriter, r *http.Request) {
+	if _, ok := k.authorize(w, r, true); !ok {
+		return
+	}
+	query := r.URL.Query()
+	result := make([]interface{}, 0)
+	for _, assignment := range k.assignments {
+		var system string
+		if assignment.System {
+			system = "all"
+		}
+		if !matchesQuery(query, "user.id", assignment.UserID) ||
+			!matchesQuery(query, "group.id", assignment.GroupID) ||
+			!matchesQuery(query, "role.id", assignment.RoleID) ||
+			!matchesQuery(query, "scope.project.id", assignment.ProjectID) ||
+			!matchesQuery(query, "scope.domain.id", assignment.DomainID) ||
+			!matchesQuery(query, "scope.system", system) {
+			continue
+		}
+		rendered := map[string]interface{}{
+			"role": map[string]interface{}{"id": assignment.RoleID},
+		}
+		if role, ok := k.roles[assignment.RoleID]; ok {
+			rendered["role"] = role
+		}
+		if assignment.UserID != "" {
+			rendered["user"] = map[string]interface{}{"id": assignment.UserID}
+		}
+		if assignment.GroupID != "" {
+			rendered["group"] = map[string]interface{}{"id": assignment.GroupID}
+		}
+		switch {
+		case assignment.System:
+			rendered["scope"] = map[string]interface{}{"system": map[string]interface{}{"all": true}}
+		case assignment.ProjectID != "":
+			rendered["scope"] = map[string]interface{}{"project": map[string]interface{}{"id": assignment.ProjectID}}
+		default:
+			rendered["scope"] = map[string]interface{}{"domain": map[string]interface{}{"id": assignment.DomainID}}
+		}
+		if assignment.Inherited {
+			rendered["scope"].(map[string]interface{})["OS-INHERIT:inherited_to"] = "projects"
+		}
+		result = append(result, rendered)
+	}
+	writeKeystoneJSON(w, http.StatusOK, map[string]interface{}{"role_assignments": result, "links": map[string]interface{}{}})
+}
+
+// handleAssignRole handles `/{projects|domains}/{id}/{users|groups}/{id}/roles/{id}` requests,
+// inherited assignments are made with the same path prefixed with `OS-INHERIT`
+func (k *Keystone) handleAssignRole(w http.ResponseWriter, r *http.Request, parts []string, inherited bool) {
+	if _, ok := k.authorize(w, r, true); !ok {
+		return
+	}
+	assignment := RoleAssignment{RoleID: parts[5], Inherited: inherited}
+	if _, ok := k.roles[assignment.RoleID]; !ok {
+		writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("role `%s` doesn't exist", assignment.RoleID))
+		return
+	}
+	switch parts[0] {
+	case "projects":
+		if _, ok := k.projects[parts[1]]; !ok {
+			writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("project `%s` doesn't exist", parts[1]))
+			return
+		}
+		assignment.ProjectID = parts[1]
+	default:
+		if _, ok := k.domains[parts[1]]; !ok {
+			writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("domain `%s` doesn't exist", parts[1]))
+			return
+		}
+		assignment.DomainID = parts[1]
+	}
+	switch parts[2] {
+	case "users":
+		if _, ok := k.users[parts[3]]; !ok {
+			writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("user `%s` doesn't exist", parts[3]))
+			return
+		}
+		assignment.UserID = parts[3]
+	case "groups":
+		if _, ok := k.groups[parts[3]]; !ok {
+			writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("group `%s` doesn't exist", parts[3]))
+			return
+		}
+		assignment.GroupID = parts[3]
+	default:
+		writeKeystoneError(w, http.StatusNotFound, "unknown actor")
+		return
+	}
+	k.assignments = append(k.assignments, assignment)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (k *Keystone) handleListSystemRoles(w http.ResponseWriter, r *http.Request, userID string) {
+	if _, ok := k.authorize(w, r, true); !ok {
+		return
+	}
+	if _, ok := k.users[userID]; !ok {
+		writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("user `%s` doesn't exist", userID))
+		return
+	}
+	result := make([]interface{}, 0)
+	for _, assignment := range k.assignments {
+		if assignment.System && assignment.UserID == userID {
+			if role, ok := k.roles[assignment.RoleID]; ok {
+				result = append(result, role)
+			}
+		}
+	}
+	writeKeystoneJSON(w, http.StatusOK, map[string]interface{}{"roles": result, "links": map[string]interface{}{}})
+}
+
+func (k *Keystone) handleAssignSystemRole(w http.ResponseWriter, r *http.Request, userID, roleID string) {
+	if _, ok := k.authorize(w, r, true); !ok {
+		return
+	}
+	if _, ok := k.roles[roleID]; !ok {
+		writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("role `%s` doesn't exist", roleID))
+		return
+	}
+	if _, ok := k.users[userID]; !ok {
+		writeKeystoneError(w, http.StatusNotFound, fmt.Sprintf("user `%s` doesn't exist", userID))
+		return
+	}
+	k.assignments = append(k.assignments, RoleAssignment{RoleID: roleID, UserID: userID, System: true})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func matchesQuery(query map[string][]string, key, value string) bool {
+	expected, ok := query[key]
+	if !ok || len(expected) == 0 {
+		return true
+	}
+	return expected[0] == value
+}
+
+func writeKeystoneJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeKeystoneError(w http.ResponseWriter, code int, message string) {
+	writeKeystoneJSON(w, code, map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"title":   http.StatusText(code),
+		},
+	})
+}
+
+// newKeystoneID returns random ID in the format used by Keystone
+func newKeystoneID() string {
+	id, _ := uuid.GenerateUUID()
+	return strings.ReplaceAll(id, "-", "")
+}
+
+func newKeystoneRequestID() string {
+	id, _ := uuid.GenerateUUID()
+	return id
+}
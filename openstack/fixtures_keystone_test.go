@@ -0,0 +1,744 @@
+package openstack
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/acceptance/tools"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/projects"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/fixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeystoneBackend returns backend with `testCloudName` cloud managed by the admin of the emulator default domain
+func testKeystoneBackend(t *testing.T) (*backend, logical.Storage, *fixtures.Keystone) {
+	t.Helper()
+
+	keystone := fixtures.SetupKeystone(t)
+	keystone.AddAdmin(fixtures.KeystoneDefaultDomainID, testUsername, testPassword1)
+
+	b, s := testBackend(t)
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      storageCloudKey(testCloudName),
+		Data: map[string]interface{}{
+			"auth_url":         keystone.AuthURL(),
+			"username":         testUsername,
+			"password":         testPassword1,
+			"user_domain_name": "Default",
+		},
+		Storage: s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	return b, s, keystone
+}
+
+// keystoneAdmin returns the root user of `testCloudName` cloud
+func keystoneAdmin(t *testing.T, keystone *fixtures.Keystone) fixtures.User {
+	t.Helper()
+
+	for _, user := range keystone.Users() {
+		if user.Name == testUsername {
+			return user
+		}
+	}
+	require.FailNow(t, "admin user doesn't exist")
+	return fixtures.User{}
+}
+
+func TestKeystone_scope(t *testing.T) {
+	keystone := fixtures.SetupKeystone(t)
+	project := keystone.AddProject(fixtures.KeystoneDefaultDomainID, "project")
+	user := keystone.AddUser(fixtures.KeystoneDefaultDomainID, testUsername, testPassword1)
+
+	cloud := &OsCloud{
+		AuthURL:        keystone.AuthURL(),
+		Username:       testUsername,
+		Password:       testPassword1,
+		UserDomainName: "Default",
+		ProjectID:      project.ID,
+	}
+	_, _, err := AuthenticateKeystone(context.Background(), cloud)
+	assert.Error(t, err, "user without roles in the project can't get project-scoped token")
+
+	role := keystone.AddRole("member")
+	keystone.Assign(fixtures.RoleAssignment{RoleID: role.ID, UserID: user.ID, ProjectID: project.ID})
+
+	client, _, err := AuthenticateKeystone(context.Background(), cloud)
+	require.NoError(t, err)
+	token, err := client.GetAuthToken()
+	require.NoError(t, err)
+	require.NotNil(t, token.Project)
+	assert.Equal(t, project.ID, token.Project.ID)
+
+	_, err = client.ListRoles()
+	assert.IsType(t, gophercloud.ErrDefault403{}, err, "identity management requires admin role")
+}
+
+func TestKeystone_userCredentials(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+	project := keystone.AddProject(fixtures.KeystoneDefaultDomainID, tools.RandomString("p", 5))
+	group := keystone.AddGroup(fixtures.KeystoneDefaultDomainID, "developers")
+	keystone.AddRole("member")
+
+	roleName := randomRoleName()
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      rolePath(roleName),
+		Data: map[string]interface{}{
+			"cloud":       testCloudName,
+			"project_id":  project.ID,
+			"secret_type": "password",
+			"user_groups": []string{group.Name},
+			"user_roles":  []string{"member"},
+		},
+		Storage: s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	res, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath(roleName),
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	userID := res.Secret.InternalData["user_id"].(string)
+	assert.Equal(t, []string{group.ID}, keystone.UserGroups(userID))
+	assignments := keystone.RoleAssignments(userID)
+	require.Len(t, assignments, 1)
+	assert.Equal(t, project.ID, assignments[0].ProjectID)
+
+	auth := res.Data["auth"].(map[string]interface{})
+	_, _, err = AuthenticateKeystone(context.Background(), &OsCloud{
+		AuthURL:        keystone.AuthURL(),
+		Username:       auth["username"].(string),
+		Password:       auth["password"].(string),
+		UserDomainName: "Default",
+		ProjectID:      project.ID,
+	})
+	require.NoError(t, err)
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Secret:    res.Secret,
+		Data:      res.Data,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	_, ok := keystone.User(userID)
+	assert.False(t, ok, "temporary user must be removed")
+}
+
+func TestKeystone_forceRemovedCloud(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+	project := keystone.AddProject(fixtures.KeystoneDefaultDomainID, tools.RandomString("p", 5))
+
+	roleName := randomRoleName()
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      rolePath(roleName),
+		Data: map[string]interface{}{
+			"cloud":       testCloudName,
+			"project_id":  project.ID,
+			"secret_type": "password",
+		},
+		Storage: s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	creds, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath(roleName),
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, creds.IsError(), creds.Error())
+	userID := creds.Secret.InternalData["user_id"].(string)
+
+	res, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      pathCloudKey(testCloudName),
+		Data:      map[string]interface{}{"force": true},
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Secret:    creds.Secret,
+		Data:      creds.Data,
+		Storage:   s,
+	})
+	require.Error(t, err, "revocation must be retried until the credentials are removed")
+	_, ok := keystone.User(userID)
+	assert.True(t, ok, "temporary user must not be removed")
+
+	leases, err := listLeases(context.Background(), s, testCloudName)
+	require.NoError(t, err)
+	assert.Len(t, leases, 1)
+}
+
+func TestKeystone_applicationCredentials(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+	project := keystone.AddProject(fixtures.KeystoneDefaultDomainID, tools.RandomString("p", 5))
+	keystone.AddRole("member")
+
+	roleName := randomRoleName()
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      rolePath(roleName),
+		Data: map[string]interface{}{
+			"cloud":        testCloudName,
+			"project_id":   project.ID,
+			"secret_type":  "application_credential",
+			"user_roles":   []string{"member"},
+			"access_rules": `[{"service": "compute", "method": "GET", "path": "/v2.1/servers"}]`,
+		},
+		Storage: s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	res, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath(roleName),
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+	assert.Equal(t, "v3applicationcredential", res.Data["auth_type"])
+
+	userID := res.Secret.InternalData["user_id"].(string)
+	credentials := keystone.ApplicationCredentials(userID)
+	require.Len(t, credentials, 1)
+	assert.Equal(t, project.ID, credentials[0].ProjectID)
+	assert.False(t, credentials[0].Unrestricted)
+	assert.Equal(t, []fixtures.AccessRule{{Service: "compute", Method: "GET", Path: "/v2.1/servers"}}, credentials[0].AccessRules)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), credentials[0].ExpiresAt, time.Minute)
+
+	auth := res.Data["auth"].(map[string]interface{})
+	assert.Equal(t, credentials[0].ID, auth["application_credential_id"])
+	assert.NotContains(t, auth, "project_id", "application credentials can't be rescoped")
+	cloud := &OsCloud{
+		AuthURL:                     auth["auth_url"].(string),
+		AuthType:                    AuthTypeApplicationCredential,
+		ApplicationCredentialID:     auth["application_credential_id"].(string),
+		ApplicationCredentialSecret: auth["application_credential_secret"].(string),
+	}
+	client, _, err := AuthenticateKeystone(context.Background(), cloud)
+	require.NoError(t, err)
+	token, err := client.GetAuthToken()
+	require.NoError(t, err)
+	require.NotNil(t, token.Project)
+	assert.Equal(t, project.ID, token.Project.ID)
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Secret:    res.Secret,
+		Data:      res.Data,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, keystone.ApplicationCredentials(userID), "application credential must be removed with the user")
+	_, _, err = AuthenticateKeystone(context.Background(), cloud)
+	assert.Error(t, err)
+}
+
+func TestKeystone_ec2Credentials(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+	project := keystone.AddProject(fixtures.KeystoneDefaultDomainID, tools.RandomString("p", 5))
+	keystone.AddRole("member")
+	keystone.AddEndpoint("s3", "https://s3.example.com")
+
+	roleName := randomRoleName()
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      rolePath(roleName),
+		Data: map[string]interface{}{
+			"cloud":       testCloudName,
+			"project_id":  project.ID,
+			"secret_type": "ec2",
+			"user_roles":  []string{"member"},
+		},
+		Storage: s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	res, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath(roleName),
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	userID := res.Secret.InternalData["user_id"].(string)
+	credentials := keystone.EC2Credentials(userID)
+	require.Len(t, credentials, 1)
+	assert.Equal(t, project.ID, credentials[0].ProjectID)
+	assert.Equal(t, credentials[0].Access, res.Data["access"])
+	assert.Equal(t, credentials[0].Secret, res.Data["secret"])
+	assert.Equal(t, "https://s3.example.com", res.Data["endpoint"])
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Secret:    res.Secret,
+		Data:      res.Data,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, keystone.EC2Credentials(userID))
+	_, ok := keystone.User(userID)
+	assert.False(t, ok, "temporary user must be removed")
+	assert.Equal(t, 1, keystone.RequestCount("DELETE", "/v3/users/"+userID+"/credentials/OS-EC2/"))
+}
+
+func createKeystoneStaticRole(t *testing.T, b *backend, s logical.Storage, username string) string {
+	t.Helper()
+
+	name := randomRoleName()
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      roleStaticStoragePath(name),
+		Data: map[string]interface{}{
+			"cloud":    testCloudName,
+			"username": username,
+		},
+		Storage: s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+	return name
+}
+
+func TestKeystone_staticRoleErrors(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+	keystone.AddUser(fixtures.KeystoneDefaultDomainID, "static", testPassword2)
+	domain := keystone.AddDomain("other")
+	keystone.AddUser(fixtures.KeystoneDefaultDomainID, "twin", testPassword2)
+	keystone.AddUser(domain.ID, "twin", testPassword2)
+
+	cases := map[string]struct {
+		username string
+		fault    *fixtures.Fault
+		code     int
+	}{
+		"missing-user": {
+			username: "missing",
+			code:     http.StatusNotFound,
+		},
+		"not-unique": {
+			username: "twin",
+			code:     http.StatusBadRequest,
+		},
+		"lookup-forbidden": {
+			username: "static",
+			fault: &fixtures.Fault{
+				Method:     http.MethodGet,
+				PathPrefix: "/v3/users",
+				StatusCode: http.StatusForbidden,
+				Times:      1,
+			},
+			code: http.StatusBadGateway,
+		},
+	}
+
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			if data.fault != nil {
+				keystone.InjectFault(*data.fault)
+				defer keystone.ClearFaults()
+			}
+
+			roleName := randomRoleName()
+			_, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      roleStaticStoragePath(roleName),
+				Data: map[string]interface{}{
+					"cloud":    testCloudName,
+					"username": data.username,
+				},
+				Storage: s,
+			})
+			var coded logical.HTTPCodedError
+			require.ErrorAs(t, err, &coded)
+			assert.Equal(t, data.code, coded.Code())
+			assert.Contains(t, err.Error(), "error during role creation")
+			if data.fault != nil {
+				assert.Regexp(t, `\(request ID: req-[\w-]+\)`, err.Error())
+			}
+
+			role, err := getStaticRoleByName(context.Background(), roleName, &logical.Request{Storage: s})
+			require.NoError(t, err)
+			assert.Nil(t, role, "failed static role is saved")
+		})
+	}
+}
+
+func TestKeystone_trust(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+	project := keystone.AddProject(fixtures.KeystoneDefaultDomainID, tools.RandomString("p", 5))
+	member := keystone.AddRole("member")
+	keystone.AddRole("reader")
+	trustee := keystone.AddUser(fixtures.KeystoneDefaultDomainID, "trustee", testPassword2)
+	trusteeRole := createKeystoneStaticRole(t, b, s, trustee.Name)
+
+	admin := keystoneAdmin(t, keystone)
+	keystone.Assign(fixtures.RoleAssignment{RoleID: member.ID, UserID: admin.ID, ProjectID: project.ID})
+
+	t.Run("root", func(t *testing.T) {
+		roleName := createKeystoneRole(t, b, s, map[string]interface{}{
+			"project_id":          project.ID,
+			"secret_type":         "trust",
+			"user_roles":          []string{"member"},
+			"trustee_static_role": trusteeRole,
+		})
+		users := len(keystone.Users())
+
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      credsPath(roleName),
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, res.IsError(), res.Error())
+		assert.Len(t, keystone.Users(), users, "no temporary users are created for trusts")
+
+		created := keystone.Trusts(admin.ID)
+		require.Len(t, created, 1)
+		assert.Equal(t, trustee.ID, created[0].TrusteeUserID)
+		assert.Equal(t, project.ID, created[0].ProjectID)
+		assert.Equal(t, []string{member.ID}, created[0].RoleIDs)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), created[0].ExpiresAt, time.Minute)
+		assert.Equal(t, created[0].ID, res.Secret.InternalData["trust_id"])
+
+		auth := res.Data["auth"].(map[string]interface{})
+		assert.NotContains(t, auth, "project_id", "trust-scoped tokens can't be rescoped")
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		client, _, err := AuthenticateKeystone(context.Background(), cloudConfig)
+		require.NoError(t, err)
+		token, err := client.WithToken(auth["token"].(string)).GetAuthToken()
+		require.NoError(t, err)
+		assert.Equal(t, created[0].ID, token.TrustID)
+		assert.Equal(t, trustee.ID, token.User.ID)
+		require.NotNil(t, token.Project)
+		assert.Equal(t, project.ID, token.Project.ID)
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    res.Secret,
+			Data:      res.Data,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		assert.Empty(t, keystone.Trusts(admin.ID))
+		assert.False(t, keystone.TokenValid(auth["token"].(string)), "tokens of the trust must be revoked")
+	})
+
+	t.Run("trustor", func(t *testing.T) {
+		owner := keystone.AddUser(fixtures.KeystoneDefaultDomainID, "owner", testPassword2)
+		keystone.Assign(fixtures.RoleAssignment{RoleID: member.ID, UserID: owner.ID, ProjectID: project.ID})
+		roleName := createKeystoneRole(t, b, s, map[string]interface{}{
+			"project_id":          project.ID,
+			"secret_type":         "trust",
+			"user_roles":          []string{"member"},
+			"trustee_static_role": trusteeRole,
+			"trustor_static_role": createKeystoneStaticRole(t, b, s, owner.Name),
+		})
+
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      credsPath(roleName),
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, res.IsError(), res.Error())
+		require.Len(t, keystone.Trusts(owner.ID), 1)
+		assert.Empty(t, keystone.Trusts(admin.ID))
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    res.Secret,
+			Data:      res.Data,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		assert.Empty(t, keystone.Trusts(owner.ID), "trusts of the trustor are removed by the root user")
+	})
+
+	t.Run("not-assigned-role", func(t *testing.T) {
+		roleName := createKeystoneRole(t, b, s, map[string]interface{}{
+			"project_id":          project.ID,
+			"secret_type":         "trust",
+			"user_roles":          []string{"reader"},
+			"trustee_static_role": trusteeRole,
+		})
+
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      credsPath(roleName),
+			Storage:   s,
+		})
+		var coded logical.HTTPCodedError
+		require.ErrorAs(t, err, &coded)
+		assert.Equal(t, http.StatusBadGateway, coded.Code())
+		assert.Empty(t, keystone.Trusts(admin.ID))
+	})
+}
+
+func TestKeystone_systemScope(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+	reader := keystone.AddRole("reader")
+	admin := keystoneAdmin(t, keystone)
+
+	data := map[string]interface{}{
+		"cloud":        testCloudName,
+		"system_scope": "all",
+		"user_roles":   []string{"reader"},
+	}
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      rolePath(randomRoleName()),
+		Data:      data,
+		Storage:   s,
+	})
+	var coded logical.HTTPCodedError
+	require.ErrorAs(t, err, &coded)
+	assert.Equal(t, http.StatusBadRequest, coded.Code())
+	assert.Contains(t, err.Error(), "root user has no role assignments on the system")
+
+	keystone.Assign(fixtures.RoleAssignment{RoleID: reader.ID, UserID: admin.ID, System: true})
+	cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+	require.NoError(t, err)
+	client, _, err := AuthenticateKeystone(context.Background(), cloudConfig)
+	require.NoError(t, err)
+
+	readCreds := func(t *testing.T, data map[string]interface{}) *logical.Response {
+		roleName := createKeystoneRole(t, b, s, data)
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      credsPath(roleName),
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.False(t, res.IsError(), res.Error())
+
+		auth := res.Data["auth"].(map[string]interface{})
+		assert.Equal(t, "all", auth["system_scope"])
+		token, err := client.WithToken(auth["token"].(string)).GetAuthToken()
+		require.NoError(t, err)
+		assert.True(t, token.System)
+		assert.Nil(t, token.Project)
+		assert.Nil(t, token.Domain)
+		return res
+	}
+
+	t.Run("root", func(t *testing.T) {
+		readCreds(t, map[string]interface{}{
+			"root":         true,
+			"system_scope": "all",
+		})
+	})
+
+	t.Run("user", func(t *testing.T) {
+		res := readCreds(t, data)
+
+		userID := res.Secret.InternalData["user_id"].(string)
+		assignments := keystone.RoleAssignments(userID)
+		require.Len(t, assignments, 1)
+		assert.Equal(t, fixtures.RoleAssignment{RoleID: reader.ID, UserID: userID, System: true}, assignments[0])
+
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    res.Secret,
+			Data:      res.Data,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		assert.Empty(t, keystone.RoleAssignments(userID))
+	})
+}
+
+func TestKeystone_roleAssignments(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+	reader := keystone.AddRole("reader")
+	member := keystone.AddRole("member")
+	domain := keystone.AddDomain(tools.RandomString("d", 5))
+	inheritedProject := keystone.AddProject(domain.ID, tools.RandomString("p", 5))
+
+	var assignments []interface{}
+	var readerProjects []fixtures.Project
+	for i := 0; i < 5; i++ {
+		project := keystone.AddProject(fixtures.KeystoneDefaultDomainID, tools.RandomString("p", 5))
+		readerProjects = append(readerProjects, project)
+		assignments = append(assignments, map[string]interface{}{"role": "reader", "project_id": project.ID})
+	}
+	memberProject := keystone.AddProject(fixtures.KeystoneDefaultDomainID, tools.RandomString("p", 5))
+	assignments = append(assignments,
+		map[string]interface{}{"role": "member", "project_name": memberProject.Name},
+		map[string]interface{}{"role": "reader", "domain_name": "Default"},
+		`{"role": "member", "domain_id": "`+domain.ID+`", "inherited": true}`,
+	)
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      rolePath(randomRoleName()),
+		Data: map[string]interface{}{
+			"cloud": testCloudName,
+			"role_assignments": []interface{}{
+				map[string]interface{}{"role": "member", "project_name": "not-existing"},
+			},
+		},
+		Storage: s,
+	})
+	var coded logical.HTTPCodedError
+	require.ErrorAs(t, err, &coded)
+	assert.Equal(t, http.StatusBadRequest, coded.Code())
+
+	roleName := createKeystoneRole(t, b, s, map[string]interface{}{
+		"secret_type":      "password",
+		"role_assignments": assignments,
+	})
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath(roleName),
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	userID := res.Secret.InternalData["user_id"].(string)
+	expected := make([]fixtures.RoleAssignment, 0, len(assignments))
+	for _, project := range readerProjects {
+		expected = append(expected, fixtures.RoleAssignment{RoleID: reader.ID, UserID: userID, ProjectID: project.ID})
+	}
+	expected = append(expected,
+		fixtures.RoleAssignment{RoleID: member.ID, UserID: userID, ProjectID: memberProject.ID},
+		fixtures.RoleAssignment{RoleID: reader.ID, UserID: userID, DomainID: fixtures.KeystoneDefaultDomainID},
+		fixtures.RoleAssignment{RoleID: member.ID, UserID: userID, DomainID: domain.ID, Inherited: true},
+	)
+	assert.Equal(t, expected, keystone.RoleAssignments(userID))
+
+	auth := res.Data["auth"].(map[string]interface{})
+	for _, projectID := range []string{readerProjects[0].ID, memberProject.ID, inheritedProject.ID} {
+		_, _, err = AuthenticateKeystone(context.Background(), &OsCloud{
+			AuthURL:        keystone.AuthURL(),
+			Username:       auth["username"].(string),
+			Password:       auth["password"].(string),
+			UserDomainName: "Default",
+			ProjectID:      projectID,
+		})
+		require.NoError(t, err)
+	}
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Secret:    res.Secret,
+		Data:      res.Data,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, keystone.RoleAssignments(userID))
+}
+
+func TestKeystone_rotateRoot(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+
+	cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+	require.NoError(t, err)
+	client, _, err := AuthenticateKeystone(context.Background(), cloudConfig)
+	require.NoError(t, err)
+
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "rotate-root/" + testCloudName,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	assert.False(t, keystone.TokenValid(client.TokenID()), "tokens issued for the old password must be revoked")
+
+	_, _, err = AuthenticateKeystone(context.Background(), cloudConfig)
+	assert.Error(t, err)
+
+	cloudConfig, err = b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+	require.NoError(t, err)
+	_, _, err = AuthenticateKeystone(context.Background(), cloudConfig)
+	assert.NoError(t, err)
+}
+
+func TestKeystone_faults(t *testing.T) {
+	keystone := fixtures.SetupKeystone(t)
+	keystone.AddAdmin(fixtures.KeystoneDefaultDomainID, testUsername, testPassword1)
+	cloud := &OsCloud{
+		AuthURL:          keystone.AuthURL(),
+		Username:         testUsername,
+		Password:         testPassword1,
+		UserDomainName:   "Default",
+		RetryMaxAttempts: 1,
+	}
+
+	client, _, err := AuthenticateKeystone(context.Background(), cloud)
+	require.NoError(t, err)
+
+	t.Run("status", func(t *testing.T) {
+		keystone.InjectFault(fixtures.Fault{
+			Method:     http.MethodGet,
+			PathPrefix: "/v3/roles",
+			StatusCode: http.StatusServiceUnavailable,
+			Times:      1,
+		})
+		before := keystone.RequestCount(http.MethodGet, "/v3/roles")
+
+		_, err := client.ListRoles()
+		var unexpected gophercloud.ErrDefault503
+		assert.ErrorAs(t, err, &unexpected)
+
+		_, err = client.ListRoles()
+		assert.NoError(t, err, "fault must be removed after the given number of requests")
+		assert.Equal(t, before+2, keystone.RequestCount(http.MethodGet, "/v3/roles"))
+	})
+
+	t.Run("rate-limit", func(t *testing.T) {
+		keystone.InjectFault(fixtures.Fault{
+			PathPrefix: "/v3/auth/tokens",
+			StatusCode: http.StatusTooManyRequests,
+			RetryAfter: time.Second,
+		})
+		defer keystone.ClearFaults()
+
+		_, err := client.CreateToken(&tokens.AuthOptions{Username: testUsername, Password: testPassword1, DomainID: fixtures.KeystoneDefaultDomainID})
+		var tooMany gophercloud.ErrDefault429
+		require.ErrorAs(t, err, &tooMany)
+		assert.Equal(t, "1", tooMany.ResponseHeader.Get("Retry-After"))
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		keystone.InjectFault(fixtures.Fault{
+			PathPrefix: "/v3/projects",
+			Latency:    50 * time.Millisecond,
+			Times:      1,
+		})
+
+		start := time.Now()
+		_, err := client.ListProjects(projects.ListOpts{})
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+}
@@ -0,0 +1,396 @@
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/applicationcredentials"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/domains"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/extensions/ec2credentials"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/extensions/trusts"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/groups"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/projects"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/roles"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/users"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
+)
+
+// IdentityClient performs the Keystone v3 operations used by the plugin on behalf
+// of an authenticated root identity.
+//
+// Implementations report failed requests using gophercloud errors, e.g. gophercloud.ErrDefault404
+// for missing resources, so the plugin handles them the same way as Keystone responses.
+type IdentityClient interface {
+	// WithContext returns the client sending requests within the given context
+	WithContext(ctx context.Context) IdentityClient
+	// WithToken returns the client sending requests on behalf of the owner of the given token
+	WithToken(token string) IdentityClient
+	// TokenID returns the token the client is authenticated with
+	TokenID() string
+	// GetAuthToken returns details of the token the client is authenticated with
+	GetAuthToken() (*IdentityToken, error)
+	CreateToken(opts *tokens.AuthOptions) (*IdentityToken, error)
+	// CreateTrustToken issues a token of the trustee authenticated with the given options scoped to the trust
+	CreateTrustToken(opts *tokens.AuthOptions, trustID string) (*IdentityToken, error)
+	RevokeToken(token string) error
+
+	CreateUser(opts users.CreateOpts) (*users.User, error)
+	GetUser(id string) (*users.User, error)
+	ListUsers(opts users.ListOpts) ([]users.User, error)
+	UpdateUser(id string, opts users.UpdateOpts) (*users.User, error)
+	DeleteUser(id string) error
+	ChangePassword(userID string, opts users.ChangePasswordOpts) error
+	AddUserToGroup(groupID, userID string) error
+
+	ListGroups(opts groups.ListOpts) ([]groups.Group, error)
+	ListRoles() ([]roles.Role, error)
+	AssignRole(roleID string, opts roles.AssignOpts) error
+	// AssignInheritedRole assigns the role inherited to the projects owned by the domain or to the subprojects of the project
+	AssignInheritedRole(roleID string, opts roles.AssignOpts) error
+	// AssignSystemRole assigns the role to the user on the system
+	AssignSystemRole(roleID, userID string) error
+	// ListSystemRoles returns roles assigned to the user on the system
+	ListSystemRoles(userID string) ([]roles.Role, error)
+	ListProjects(opts projects.ListOpts) ([]projects.Project, error)
+	ListAvailableDomains() ([]domains.Domain, error)
+
+	CreateApplicationCredential(userID string, opts applicationcredentials.CreateOpts) (*applicationcredentials.ApplicationCredential, error)
+	ListApplicationCredentials(userID string, opts applicationcredentials.ListOpts) ([]applicationcredentials.ApplicationCredential, error)
+	DeleteApplicationCredential(userID, id string) error
+
+	// CreateEC2Credential creates EC2 credential of the user for the project
+	CreateEC2Credential(userID, projectID string) (*ec2credentials.Credential, error)
+	// DeleteEC2Credential removes EC2 credential of the user with the given access key
+	DeleteEC2Credential(userID, access string) error
+
+	// CreateTrust creates a trust on behalf of the trustor the client is authenticated as
+	CreateTrust(opts trusts.CreateOpts) (*trusts.Trust, error)
+	DeleteTrust(id string) error
+}
+
+// IdentityClientFactory authenticates the root identity of the cloud within the given context and returns
+// the client together with the expiration time of its token
+type IdentityClientFactory func(ctx context.Context, cloud *OsCloud) (IdentityClient, time.Time, error)
+
+// IdentityToken describes the issued Keystone token
+type IdentityToken struct {
+	ID        string
+	ExpiresAt time.Time
+	AuditIDs  []string
+	User      tokens.User
+	// Domain is set for domain-scoped tokens
+	Domain *tokens.Domain
+	// Project is set for project-scoped tokens
+	Project *tokens.Project
+	// Catalog lists services available for the token
+	Catalog []tokens.CatalogEntry
+	// TrustID is set for trust-scoped tokens
+	TrustID string
+	// System is set for system-scoped tokens
+	System bool
+}
+
+// AuditID returns the audit ID identifying the token without exposing it
+func (t *IdentityToken) AuditID() string {
+	if len(t.AuditIDs) == 0 {
+		return ""
+	}
+	return t.AuditIDs[0]
+}
+
+// PublicEndpoint returns URL of the public endpoint of the service of the given type from the catalog of the token
+func (t *IdentityToken) PublicEndpoint(serviceType string) string {
+	for _, entry := range t.Catalog {
+		if entry.Type != serviceType {
+			continue
+		}
+		for _, endpoint := range entry.Endpoints {
+			if endpoint.Interface == "public" {
+				return endpoint.URL
+			}
+		}
+	}
+	return ""
+}
+
+// AuthenticateKeystone authenticates the root identity of the cloud in Keystone
+// and is the default IdentityClientFactory
+func AuthenticateKeystone(ctx context.Context, cloud *OsCloud) (IdentityClient, time.Time, error) {
+	httpClient, err := cloud.httpClient()
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error creating HTTP client: %w", err)
+	}
+
+	pClient, err := openstack.NewClient(cloud.AuthURL)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error creating provider client: %w", err)
+	}
+	pClient.HTTPClient = *httpClient
+	pClient.Context = ctx
+
+	if err := openstack.Authenticate(pClient, cloud.authOptions()); err != nil {
+		return nil, time.Time{}, common.KeystoneErrorf(err, "error creating provider client")
+	}
+
+	sClient, err := openstack.NewIdentityV3(pClient, gophercloud.EndpointOpts{})
+	if err != nil {
+		return nil, time.Time{}, common.KeystoneErrorf(err, "error creating service client")
+	}
+
+	client := NewGophercloudIdentityClient(sClient)
+	token, err := client.GetAuthToken()
+	if err != nil {
+		return nil, time.Time{}, common.KeystoneErrorf(err, "error extracting token")
+	}
+
+	return client, token.ExpiresAt, nil
+}
+
+// NewGophercloudIdentityClient returns IdentityClient using the given Keystone v3 service client
+func NewGophercloudIdentityClient(client *gophercloud.ServiceClient) IdentityClient {
+	return &gophercloudIdentityClient{client: client}
+}
+
+type gophercloudIdentityClient struct {
+	client *gophercloud.ServiceClient
+}
+
+// tokenResult is implemented by results of token requests
+type tokenResult interface {
+	ExtractToken() (*tokens.Token, error)
+	ExtractUser() (*tokens.User, error)
+	ExtractDomain() (*tokens.Domain, error)
+	ExtractProject() (*tokens.Project, error)
+	ExtractServiceCatalog() (*tokens.ServiceCatalog, error)
+	ExtractInto(v interface{}) error
+}
+
+func extractIdentityToken(result tokenResult) (*IdentityToken, error) {
+	token, err := result.ExtractToken()
+	if err != nil {
+		return nil, err
+	}
+	user, err := result.ExtractUser()
+	if err != nil {
+		return nil, err
+	}
+	domain, err := result.ExtractDomain()
+	if err != nil {
+		return nil, err
+	}
+	project, err := result.ExtractProject()
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := result.ExtractServiceCatalog()
+	if err != nil {
+		return nil, err
+	}
+	var extra struct {
+		AuditIDs []string `json:"audit_ids"`
+		Trust    *struct {
+			ID string `json:"id"`
+		} `json:"OS-TRUST:trust"`
+		System map[string]bool `json:"system"`
+	}
+	if err := result.ExtractInto(&extra); err != nil {
+		return nil, err
+	}
+
+	identityToken := &IdentityToken{
+		ID:        token.ID,
+		ExpiresAt: token.ExpiresAt,
+		AuditIDs:  extra.AuditIDs,
+		Domain:    domain,
+		Project:   project,
+		Catalog:   catalog.Entries,
+		System:    extra.System["all"],
+	}
+	if user != nil {
+		identityToken.User = *user
+	}
+	if extra.Trust != nil {
+		identityToken.TrustID = extra.Trust.ID
+	}
+	return identityToken, nil
+}
+
+func (c *gophercloudIdentityClient) WithContext(ctx context.Context) IdentityClient {
+	// the provider client only holds the token, as root clients are not reauthenticated by gophercloud
+	provider := *c.client.ProviderClient
+	provider.Context = ctx
+	service := *c.client
+	service.ProviderClient = &provider
+	return &gophercloudIdentityClient{client: &service}
+}
+
+func (c *gophercloudIdentityClient) WithToken(token string) IdentityClient {
+	provider := *c.client.ProviderClient
+	provider.TokenID = token
+	service := *c.client
+	service.ProviderClient = &provider
+	return &gophercloudIdentityClient{client: &service}
+}
+
+func (c *gophercloudIdentityClient) TokenID() string {
+	return c.client.Token()
+}
+
+func (c *gophercloudIdentityClient) GetAuthToken() (*IdentityToken, error) {
+	return extractIdentityToken(tokens.Get(c.client, c.client.Token()))
+}
+
+func (c *gophercloudIdentityClient) CreateToken(opts *tokens.AuthOptions) (*IdentityToken, error) {
+	return extractIdentityToken(tokens.Create(c.client, opts))
+}
+
+func (c *gophercloudIdentityClient) CreateTrustToken(opts *tokens.AuthOptions, trustID string) (*IdentityToken, error) {
+	return extractIdentityToken(tokens.Create(c.client, trusts.AuthOptsExt{AuthOptionsBuilder: opts, TrustID: trustID}))
+}
+
+func (c *gophercloudIdentityClient) RevokeToken(token string) error {
+	return tokens.Revoke(c.client, token).Err
+}
+
+func (c *gophercloudIdentityClient) CreateUser(opts users.CreateOpts) (*users.User, error) {
+	return users.Create(c.client, opts).Extract()
+}
+
+func (c *gophercloudIdentityClient) GetUser(id string) (*users.User, error) {
+	return users.Get(c.client, id).Extract()
+}
+
+func (c *gophercloudIdentityClient) ListUsers(opts users.ListOpts) ([]users.User, error) {
+	pages, err := users.List(c.client, opts).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	return users.ExtractUsers(pages)
+}
+
+func (c *gophercloudIdentityClient) UpdateUser(id string, opts users.UpdateOpts) (*users.User, error) {
+	return users.Update(c.client, id, opts).Extract()
+}
+
+func (c *gophercloudIdentityClient) DeleteUser(id string) error {
+	return users.Delete(c.client, id).ExtractErr()
+}
+
+func (c *gophercloudIdentityClient) ChangePassword(userID string, opts users.ChangePasswordOpts) error {
+	return users.ChangePassword(c.client, userID, opts).ExtractErr()
+}
+
+func (c *gophercloudIdentityClient) AddUserToGroup(groupID, userID string) error {
+	return users.AddToGroup(c.client, groupID, userID).ExtractErr()
+}
+
+func (c *gophercloudIdentityClient) ListGroups(opts groups.ListOpts) ([]groups.Group, error) {
+	pages, err := groups.List(c.client, opts).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	return groups.ExtractGroups(pages)
+}
+
+func (c *gophercloudIdentityClient) ListRoles() ([]roles.Role, error) {
+	pages, err := roles.List(c.client, nil).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	return roles.ExtractRoles(pages)
+}
+
+func (c *gophercloudIdentityClient) AssignRole(roleID string, opts roles.AssignOpts) error {
+	return roles.Assign(c.client, roleID, opts).ExtractErr()
+}
+
+func (c *gophercloudIdentityClient) AssignInheritedRole(roleID string, opts roles.AssignOpts) error {
+	// gophercloud doesn't support OS-INHERIT role assignments
+	targetType, targetID := "projects", opts.ProjectID
+	if opts.DomainID != "" {
+		targetType, targetID = "domains", opts.DomainID
+	}
+	actorType, actorID := "users", opts.UserID
+	if opts.GroupID != "" {
+		actorType, actorID = "groups", opts.GroupID
+	}
+	if targetID == "" || actorID == "" {
+		return errors.New("inherited role assignment requires a project or a domain and a user or a group")
+	}
+	url := c.client.ServiceURL("OS-INHERIT", targetType, targetID, actorType, actorID, "roles", roleID, "inherited_to_projects")
+	_, err := c.client.Put(url, nil, nil, &gophercloud.RequestOpts{
+		OkCodes: []int{204},
+	})
+	return err
+}
+
+func (c *gophercloudIdentityClient) AssignSystemRole(roleID, userID string) error {
+	// gophercloud doesn't support system role assignments
+	_, err := c.client.Put(c.client.ServiceURL("system", "users", userID, "roles", roleID), nil, nil, &gophercloud.RequestOpts{
+		OkCodes: []int{204},
+	})
+	return err
+}
+
+func (c *gophercloudIdentityClient) ListSystemRoles(userID string) ([]roles.Role, error) {
+	var result struct {
+		Roles []roles.Role `json:"roles"`
+	}
+	if _, err := c.client.Get(c.client.ServiceURL("system", "users", userID, "roles"), &result, nil); err != nil {
+		return nil, err
+	}
+	return result.Roles, nil
+}
+
+func (c *gophercloudIdentityClient) ListProjects(opts projects.ListOpts) ([]projects.Project, error) {
+	pages, err := projects.List(c.client, opts).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	return projects.ExtractProjects(pages)
+}
+
+func (c *gophercloudIdentityClient) ListAvailableDomains() ([]domains.Domain, error) {
+	pages, err := domains.ListAvailable(c.client).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	return domains.ExtractDomains(pages)
+}
+
+func (c *gophercloudIdentityClient) CreateApplicationCredential(userID string, opts applicationcredentials.CreateOpts) (*applicationcredentials.ApplicationCredential, error) {
+	return applicationcredentials.Create(c.client, userID, opts).Extract()
+}
+
+func (c *gophercloudIdentityClient) ListApplicationCredentials(userID string, opts applicationcredentials.ListOpts) ([]applicationcredentials.ApplicationCredential, error) {
+	pages, err := applicationcredentials.List(c.client, userID, opts).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	return applicationcredentials.ExtractApplicationCredentials(pages)
+}
+
+func (c *gophercloudIdentityClient) DeleteApplicationCredential(userID, id string) error {
+	return applicationcredentials.Delete(c.client, userID, id).ExtractErr()
+}
+
+func (c *gophercloudIdentityClient) CreateEC2Credential(userID, projectID string) (*ec2credentials.Credential, error) {
+	return ec2credentials.Create(c.client, userID, ec2credentials.CreateOpts{TenantID: projectID}).Extract()
+}
+
+func (c *gophercloudIdentityClient) DeleteEC2Credential(userID, access string) error {
+	return ec2credentials.Delete(c.client, userID, access).ExtractErr()
+}
+
+func (c *gophercloudIdentityClient) CreateTrust(opts trusts.CreateOpts) (*trusts.Trust, error) {
+	return trusts.Create(c.client, opts).Extract()
+}
+
+func (c *gophercloudIdentityClient) DeleteTrust(id string) error {
+	return trusts.Delete(c.client, id).ExtractErr()
+}
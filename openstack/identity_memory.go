@@ -0,0 +1,1038 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/applicationcredentials"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/domains"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/extensions/ec2credentials"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/extensions/trusts"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/groups"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/projects"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/roles"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/users"
+	"github.com/hashicorp/go-uuid"
+)
+
+const (
+	// MemoryDefaultDomainID is the ID of the domain created with every MemoryIdentity
+	MemoryDefaultDomainID = "default"
+
+	defaultMemoryTokenTTL = time.Hour
+)
+
+// MemoryIdentity is an in-memory identity service supporting the operations used by the plugin.
+// Its Authenticate method is an IdentityClientFactory, so the backend can run without Keystone,
+// e.g. in tests.
+type MemoryIdentity struct {
+	// TokenTTL is the lifetime of issued tokens, one hour by default
+	TokenTTL time.Duration
+
+	lock        sync.Mutex
+	domains     map[string]domains.Domain
+	projects    map[string]projects.Project
+	users       map[string]*memoryUser
+	groups      map[string]groups.Group
+	roles       map[string]roles.Role
+	assignments []roles.RoleAssignment
+	tokens      map[string]*memoryToken
+	appCreds    map[string]*memoryApplicationCredential
+	ec2Creds    map[string]ec2credentials.Credential
+	trusts      map[string]trusts.Trust
+	// inherited are assignments inherited to the projects of the domain or to the subprojects of the project
+	inherited []roles.RoleAssignment
+}
+
+type memoryUser struct {
+	user     users.User
+	password string
+	groups   map[string]bool
+	// systemRoles are IDs of roles assigned on the system
+	systemRoles map[string]bool
+}
+
+type memoryToken struct {
+	token IdentityToken
+	// appCredID is the application credential the token is issued for
+	appCredID string
+}
+
+type memoryApplicationCredential struct {
+	credential applicationcredentials.ApplicationCredential
+	userID     string
+}
+
+// NewMemoryIdentity returns empty identity service containing only the `Default` domain
+func NewMemoryIdentity() *MemoryIdentity {
+	m := &MemoryIdentity{
+		TokenTTL: defaultMemoryTokenTTL,
+		domains:  make(map[string]domains.Domain),
+		projects: make(map[string]projects.Project),
+		users:    make(map[string]*memoryUser),
+		groups:   make(map[string]groups.Group),
+		roles:    make(map[string]roles.Role),
+		tokens:   make(map[string]*memoryToken),
+		appCreds: make(map[string]*memoryApplicationCredential),
+		ec2Creds: make(map[string]ec2credentials.Credential),
+		trusts:   make(map[string]trusts.Trust),
+	}
+	m.domains[MemoryDefaultDomainID] = domains.Domain{
+		ID:      MemoryDefaultDomainID,
+		Name:    "Default",
+		Enabled: true,
+	}
+	return m
+}
+
+// AddDomain creates a new domain
+func (m *MemoryIdentity) AddDomain(name string) domains.Domain {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	domain := domains.Domain{ID: memoryID(), Name: name, Enabled: true}
+	m.domains[domain.ID] = domain
+	return domain
+}
+
+// AddProject creates a new project in the domain
+func (m *MemoryIdentity) AddProject(domainID, name string) projects.Project {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	project := projects.Project{ID: memoryID(), Name: name, DomainID: domainID, Enabled: true}
+	m.projects[project.ID] = project
+	return project
+}
+
+// AddUser creates a new user in the domain
+func (m *MemoryIdentity) AddUser(domainID, name, password string) users.User {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	user := users.User{ID: memoryID(), Name: name, DomainID: domainID, Enabled: true}
+	m.users[user.ID] = &memoryUser{user: user, password: password, groups: make(map[string]bool)}
+	return user
+}
+
+// AddGroup creates a new group in the domain
+func (m *MemoryIdentity) AddGroup(domainID, name string) groups.Group {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	group := groups.Group{ID: memoryID(), Name: name, DomainID: domainID}
+	m.groups[group.ID] = group
+	return group
+}
+
+// AddRole creates a new global role
+func (m *MemoryIdentity) AddRole(name string) roles.Role {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	role := roles.Role{ID: memoryID(), Name: name}
+	m.roles[role.ID] = role
+	return role
+}
+
+// UserGroups returns IDs of the groups the user is member of
+func (m *MemoryIdentity) UserGroups(userID string) []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return nil
+	}
+	var groupIDs []string
+	for id := range user.groups {
+		groupIDs = append(groupIDs, id)
+	}
+	return groupIDs
+}
+
+// RoleAssignments returns roles assigned to the user
+func (m *MemoryIdentity) RoleAssignments(userID string) []roles.RoleAssignment {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return userAssignments(m.assignments, userID)
+}
+
+// InheritedRoleAssignments returns roles assigned to the user with inheritance
+func (m *MemoryIdentity) InheritedRoleAssignments(userID string) []roles.RoleAssignment {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return userAssignments(m.inherited, userID)
+}
+
+func userAssignments(all []roles.RoleAssignment, userID string) []roles.RoleAssignment {
+	var assignments []roles.RoleAssignment
+	for _, assignment := range all {
+		if assignment.User.ID == userID {
+			assignments = append(assignments, assignment)
+		}
+	}
+	return assignments
+}
+
+// Authenticate issues a token for the root identity of the cloud
+func (m *MemoryIdentity) Authenticate(ctx context.Context, cloud *OsCloud) (IdentityClient, time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	authOpts := cloud.authOptions()
+	opts := &tokens.AuthOptions{
+		Username:                    authOpts.Username,
+		UserID:                      authOpts.UserID,
+		Password:                    authOpts.Password,
+		DomainID:                    authOpts.DomainID,
+		DomainName:                  authOpts.DomainName,
+		ApplicationCredentialID:     authOpts.ApplicationCredentialID,
+		ApplicationCredentialName:   authOpts.ApplicationCredentialName,
+		ApplicationCredentialSecret: authOpts.ApplicationCredentialSecret,
+	}
+	if scope := authOpts.Scope; scope != nil {
+		opts.Scope = tokens.Scope{
+			ProjectID:   scope.ProjectID,
+			ProjectName: scope.ProjectName,
+			DomainID:    scope.DomainID,
+			DomainName:  scope.DomainName,
+			System:      scope.System,
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	token, err := m.issueToken(opts)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return &memoryIdentityClient{ctx: ctx, identity: m, tokenID: token.ID}, token.ExpiresAt, nil
+}
+
+func (m *MemoryIdentity) issueToken(opts *tokens.AuthOptions) (*IdentityToken, error) {
+	var (
+		user      *memoryUser
+		appCredID string
+		scope     = opts.Scope
+	)
+	if opts.ApplicationCredentialID != "" || opts.ApplicationCredentialName != "" {
+		credential, err := m.findApplicationCredential(opts)
+		if err != nil {
+			return nil, err
+		}
+		if credential.credential.Secret != opts.ApplicationCredentialSecret {
+			return nil, memoryError(http.StatusUnauthorized, "invalid application credential secret")
+		}
+		if expiresAt := credential.credential.ExpiresAt; !expiresAt.IsZero() && time.Now().After(expiresAt) {
+			return nil, memoryError(http.StatusUnauthorized, "application credential is expired")
+		}
+		if scope != (tokens.Scope{}) {
+			return nil, memoryError(http.StatusUnauthorized, "application credential can't be rescoped")
+		}
+		user = m.users[credential.userID]
+		appCredID = credential.credential.ID
+		scope = tokens.Scope{ProjectID: credential.credential.ProjectID}
+		if scope.ProjectID == "" {
+			scope = tokens.Scope{DomainID: user.user.DomainID}
+		}
+	} else {
+		var err error
+		user, err = m.findUser(opts.UserID, opts.Username, opts.DomainID, opts.DomainName)
+		if err != nil {
+			return nil, err
+		}
+		if user.password != opts.Password {
+			return nil, memoryError(http.StatusUnauthorized, "invalid password")
+		}
+	}
+	if !user.user.Enabled {
+		return nil, memoryError(http.StatusUnauthorized, "user `%s` is disabled", user.user.Name)
+	}
+
+	token := IdentityToken{
+		ID:        memoryID(),
+		ExpiresAt: time.Now().Add(m.TokenTTL).UTC(),
+		AuditIDs:  []string{memoryID()},
+		User: tokens.User{
+			ID:     user.user.ID,
+			Name:   user.user.Name,
+			Domain: tokens.Domain{ID: user.user.DomainID, Name: m.domains[user.user.DomainID].Name},
+		},
+	}
+	switch {
+	case scope.System:
+		token.System = true
+	case scope.ProjectID != "" || scope.ProjectName != "":
+		project, err := m.findProject(scope)
+		if err != nil {
+			return nil, err
+		}
+		token.Project = &tokens.Project{
+			ID:     project.ID,
+			Name:   project.Name,
+			Domain: tokens.Domain{ID: project.DomainID, Name: m.domains[project.DomainID].Name},
+		}
+	case scope.DomainID != "" || scope.DomainName != "":
+		domain, err := m.findDomain(scope.DomainID, scope.DomainName)
+		if err != nil {
+			return nil, memoryError(http.StatusUnauthorized, "invalid scope: %s", err)
+		}
+		token.Domain = &tokens.Domain{ID: domain.ID, Name: domain.Name}
+	}
+
+	m.tokens[token.ID] = &memoryToken{token: token, appCredID: appCredID}
+	result := token
+	return &result, nil
+}
+
+func (m *MemoryIdentity) findUser(id, name, domainID, domainName string) (*memoryUser, error) {
+	if id != "" {
+		if user, ok := m.users[id]; ok {
+			return user, nil
+		}
+		return nil, memoryError(http.StatusUnauthorized, "user `%s` doesn't exist", id)
+	}
+	domain, err := m.findDomain(domainID, domainName)
+	if err != nil {
+		return nil, memoryError(http.StatusUnauthorized, err.Error())
+	}
+	for _, user := range m.users {
+		if user.user.Name == name && user.user.DomainID == domain.ID {
+			return user, nil
+		}
+	}
+	return nil, memoryError(http.StatusUnauthorized, "user `%s` doesn't exist", name)
+}
+
+func (m *MemoryIdentity) findDomain(id, name string) (*domains.Domain, error) {
+	for _, domain := range m.domains {
+		if (id != "" && domain.ID == id) || (id == "" && domain.Name == name) {
+			return &domain, nil
+		}
+	}
+	return nil, fmt.Errorf("domain `%s%s` doesn't exist", id, name)
+}
+
+func (m *MemoryIdentity) findProject(scope tokens.Scope) (*projects.Project, error) {
+	if scope.ProjectID != "" {
+		if project, ok := m.projects[scope.ProjectID]; ok {
+			return &project, nil
+		}
+		return nil, memoryError(http.StatusUnauthorized, "project `%s` doesn't exist", scope.ProjectID)
+	}
+	domain, err := m.findDomain(scope.DomainID, scope.DomainName)
+	if err != nil {
+		return nil, memoryError(http.StatusUnauthorized, "invalid scope: %s", err)
+	}
+	for _, project := range m.projects {
+		if project.Name == scope.ProjectName && project.DomainID == domain.ID {
+			return &project, nil
+		}
+	}
+	return nil, memoryError(http.StatusUnauthorized, "project `%s` doesn't exist", scope.ProjectName)
+}
+
+func (m *MemoryIdentity) findApplicationCredential(opts *tokens.AuthOptions) (*memoryApplicationCredential, error) {
+	if opts.ApplicationCredentialID != "" {
+		if credential, ok := m.appCreds[opts.ApplicationCredentialID]; ok {
+			return credential, nil
+		}
+		return nil, memoryError(http.StatusUnauthorized, "application credential `%s` doesn't exist", opts.ApplicationCredentialID)
+	}
+	user, err := m.findUser(opts.UserID, opts.Username, opts.DomainID, opts.DomainName)
+	if err != nil {
+		return nil, err
+	}
+	for _, credential := range m.appCreds {
+		if credential.userID == user.user.ID && credential.credential.Name == opts.ApplicationCredentialName {
+			return credential, nil
+		}
+	}
+	return nil, memoryError(http.StatusUnauthorized, "application credential `%s` doesn't exist", opts.ApplicationCredentialName)
+}
+
+// revokeTokens removes tokens matching the filter
+func (m *MemoryIdentity) revokeTokens(filter func(*memoryToken) bool) {
+	for id, token := range m.tokens {
+		if filter(token) {
+			delete(m.tokens, id)
+		}
+	}
+}
+
+// memoryIdentityClient is IdentityClient of MemoryIdentity authenticated with the token
+type memoryIdentityClient struct {
+	ctx      context.Context
+	identity *MemoryIdentity
+	tokenID  string
+}
+
+// authorize checks the request context isn't done and the token of the client is still valid
+func (c *memoryIdentityClient) authorize() (*memoryToken, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.identity.authorize(c.tokenID)
+}
+
+// authorize checks the token is still valid
+func (m *MemoryIdentity) authorize(tokenID string) (*memoryToken, error) {
+	token, ok := m.tokens[tokenID]
+	if !ok || time.Now().After(token.token.ExpiresAt) {
+		return nil, memoryError(http.StatusUnauthorized, "the request you have made requires authentication")
+	}
+	return token, nil
+}
+
+func (c *memoryIdentityClient) WithContext(ctx context.Context) IdentityClient {
+	return &memoryIdentityClient{ctx: ctx, identity: c.identity, tokenID: c.tokenID}
+}
+
+func (c *memoryIdentityClient) WithToken(token string) IdentityClient {
+	return &memoryIdentityClient{ctx: c.ctx, identity: c.identity, tokenID: token}
+}
+
+func (c *memoryIdentityClient) TokenID() string {
+	return c.tokenID
+}
+
+func (c *memoryIdentityClient) GetAuthToken() (*IdentityToken, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	token, err := c.authorize()
+	if err != nil {
+		return nil, err
+	}
+
+	result := token.token
+	return &result, nil
+}
+
+func (c *memoryIdentityClient) CreateToken(opts *tokens.AuthOptions) (*IdentityToken, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	return c.identity.issueToken(opts)
+}
+
+func (c *memoryIdentityClient) CreateTrustToken(opts *tokens.AuthOptions, trustID string) (*IdentityToken, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	trust, ok := c.identity.trusts[trustID]
+	if !ok || (!trust.ExpiresAt.IsZero() && time.Now().After(trust.ExpiresAt)) {
+		return nil, memoryError(http.StatusUnauthorized, "trust `%s` doesn't exist", trustID)
+	}
+	user, err := c.identity.findUser(opts.UserID, opts.Username, opts.DomainID, opts.DomainName)
+	if err != nil {
+		return nil, err
+	}
+	if user.user.ID != trust.TrusteeUserID {
+		return nil, memoryError(http.StatusForbidden, "user `%s` isn't the trustee of the trust", user.user.Name)
+	}
+
+	token, err := c.identity.issueToken(&tokens.AuthOptions{
+		UserID:   user.user.ID,
+		Password: opts.Password,
+		Scope:    tokens.Scope{ProjectID: trust.ProjectID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	token.TrustID = trust.ID
+	if !trust.ExpiresAt.IsZero() && trust.ExpiresAt.Before(token.ExpiresAt) {
+		token.ExpiresAt = trust.ExpiresAt
+	}
+	c.identity.tokens[token.ID].token = *token
+	return token, nil
+}
+
+func (c *memoryIdentityClient) RevokeToken(token string) error {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return err
+	}
+
+	if _, ok := c.identity.tokens[token]; !ok {
+		return memoryError(http.StatusNotFound, "token doesn't exist")
+	}
+	delete(c.identity.tokens, token)
+	return nil
+}
+
+func (c *memoryIdentityClient) CreateUser(opts users.CreateOpts) (*users.User, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := c.identity.domains[opts.DomainID]; !ok {
+		return nil, memoryError(http.StatusBadRequest, "domain `%s` doesn't exist", opts.DomainID)
+	}
+	for _, user := range c.identity.users {
+		if user.user.Name == opts.Name && user.user.DomainID == opts.DomainID {
+			return nil, memoryError(http.StatusConflict, "duplicate user `%s`", opts.Name)
+		}
+	}
+
+	user := users.User{
+		ID:               memoryID(),
+		Name:             opts.Name,
+		DomainID:         opts.DomainID,
+		DefaultProjectID: opts.DefaultProjectID,
+		Description:      opts.Description,
+		Enabled:          opts.Enabled == nil || *opts.Enabled,
+	}
+	c.identity.users[user.ID] = &memoryUser{user: user, password: opts.Password, groups: make(map[string]bool)}
+	return &user, nil
+}
+
+func (c *memoryIdentityClient) GetUser(id string) (*users.User, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	user, ok := c.identity.users[id]
+	if !ok {
+		return nil, memoryError(http.StatusNotFound, "user `%s` doesn't exist", id)
+	}
+	result := user.user
+	return &result, nil
+}
+
+func (c *memoryIdentityClient) ListUsers(opts users.ListOpts) ([]users.User, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	var result []users.User
+	for _, user := range c.identity.users {
+		if (opts.DomainID == "" || user.user.DomainID == opts.DomainID) && (opts.Name == "" || user.user.Name == opts.Name) {
+			result = append(result, user.user)
+		}
+	}
+	return result, nil
+}
+
+func (c *memoryIdentityClient) UpdateUser(id string, opts users.UpdateOpts) (*users.User, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	user, ok := c.identity.users[id]
+	if !ok {
+		return nil, memoryError(http.StatusNotFound, "user `%s` doesn't exist", id)
+	}
+	if opts.Name != "" {
+		user.user.Name = opts.Name
+	}
+	if opts.Description != nil {
+		user.user.Description = *opts.Description
+	}
+	if opts.DefaultProjectID != "" {
+		user.user.DefaultProjectID = opts.DefaultProjectID
+	}
+	if opts.Enabled != nil {
+		user.user.Enabled = *opts.Enabled
+	}
+	if opts.Password != "" {
+		user.password = opts.Password
+		c.identity.revokeTokens(func(t *memoryToken) bool { return t.token.User.ID == id })
+	}
+	result := user.user
+	return &result, nil
+}
+
+func (c *memoryIdentityClient) DeleteUser(id string) error {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return err
+	}
+
+	if _, ok := c.identity.users[id]; !ok {
+		return memoryError(http.StatusNotFound, "user `%s` doesn't exist", id)
+	}
+	delete(c.identity.users, id)
+	c.identity.revokeTokens(func(t *memoryToken) bool { return t.token.User.ID == id })
+	for credID, credential := range c.identity.appCreds {
+		if credential.userID == id {
+			delete(c.identity.appCreds, credID)
+		}
+	}
+	for access, credential := range c.identity.ec2Creds {
+		if credential.UserID == id {
+			delete(c.identity.ec2Creds, access)
+		}
+	}
+	for trustID, trust := range c.identity.trusts {
+		if trust.TrustorUserID == id || trust.TrusteeUserID == id {
+			c.identity.deleteTrust(trustID)
+		}
+	}
+	c.identity.assignments = removeUserAssignments(c.identity.assignments, id)
+	c.identity.inherited = removeUserAssignments(c.identity.inherited, id)
+	return nil
+}
+
+func removeUserAssignments(all []roles.RoleAssignment, userID string) []roles.RoleAssignment {
+	assignments := all[:0]
+	for _, assignment := range all {
+		if assignment.User.ID != userID {
+			assignments = append(assignments, assignment)
+		}
+	}
+	return assignments
+}
+
+func (c *memoryIdentityClient) ChangePassword(userID string, opts users.ChangePasswordOpts) error {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return err
+	}
+
+	user, ok := c.identity.users[userID]
+	if !ok {
+		return memoryError(http.StatusNotFound, "user `%s` doesn't exist", userID)
+	}
+	if user.password != opts.OriginalPassword {
+		return memoryError(http.StatusUnauthorized, "invalid original password")
+	}
+	user.password = opts.Password
+	// Keystone revokes all tokens of the user when the password is changed
+	c.identity.revokeTokens(func(t *memoryToken) bool { return t.token.User.ID == userID && t.appCredID == "" })
+	return nil
+}
+
+func (c *memoryIdentityClient) AddUserToGroup(groupID, userID string) error {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return err
+	}
+
+	if _, ok := c.identity.groups[groupID]; !ok {
+		return memoryError(http.StatusNotFound, "group `%s` doesn't exist", groupID)
+	}
+	user, ok := c.identity.users[userID]
+	if !ok {
+		return memoryError(http.StatusNotFound, "user `%s` doesn't exist", userID)
+	}
+	user.groups[groupID] = true
+	return nil
+}
+
+func (c *memoryIdentityClient) ListGroups(opts groups.ListOpts) ([]groups.Group, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	var result []groups.Group
+	for _, group := range c.identity.groups {
+		if (opts.DomainID == "" || group.DomainID == opts.DomainID) && (opts.Name == "" || group.Name == opts.Name) {
+			result = append(result, group)
+		}
+	}
+	return result, nil
+}
+
+func (c *memoryIdentityClient) ListRoles() ([]roles.Role, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	var result []roles.Role
+	for _, role := range c.identity.roles {
+		result = append(result, role)
+	}
+	return result, nil
+}
+
+func (c *memoryIdentityClient) AssignRole(roleID string, opts roles.AssignOpts) error {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	assignment, err := c.newAssignment(roleID, opts)
+	if err != nil {
+		return err
+	}
+	c.identity.assignments = append(c.identity.assignments, *assignment)
+	return nil
+}
+
+func (c *memoryIdentityClient) AssignInheritedRole(roleID string, opts roles.AssignOpts) error {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	assignment, err := c.newAssignment(roleID, opts)
+	if err != nil {
+		return err
+	}
+	c.identity.inherited = append(c.identity.inherited, *assignment)
+	return nil
+}
+
+func (c *memoryIdentityClient) newAssignment(roleID string, opts roles.AssignOpts) (*roles.RoleAssignment, error) {
+	if _, err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	role, ok := c.identity.roles[roleID]
+	if !ok {
+		return nil, memoryError(http.StatusNotFound, "role `%s` doesn't exist", roleID)
+	}
+	assignment := roles.RoleAssignment{
+		Role: roles.AssignedRole{ID: role.ID, Name: role.Name},
+	}
+	switch {
+	case opts.UserID != "":
+		if _, ok := c.identity.users[opts.UserID]; !ok {
+			return nil, memoryError(http.StatusNotFound, "user `%s` doesn't exist", opts.UserID)
+		}
+		assignment.User = roles.User{ID: opts.UserID}
+	case opts.GroupID != "":
+		if _, ok := c.identity.groups[opts.GroupID]; !ok {
+			return nil, memoryError(http.StatusNotFound, "group `%s` doesn't exist", opts.GroupID)
+		}
+		assignment.Group = roles.Group{ID: opts.GroupID}
+	default:
+		return nil, memoryError(http.StatusBadRequest, "either user or group has to be specified")
+	}
+	switch {
+	case opts.ProjectID != "":
+		project, ok := c.identity.projects[opts.ProjectID]
+		if !ok {
+			return nil, memoryError(http.StatusNotFound, "project `%s` doesn't exist", opts.ProjectID)
+		}
+		assignment.Scope.Project = roles.Project{ID: project.ID, Name: project.Name}
+	case opts.DomainID != "":
+		domain, ok := c.identity.domains[opts.DomainID]
+		if !ok {
+			return nil, memoryError(http.StatusNotFound, "domain `%s` doesn't exist", opts.DomainID)
+		}
+		assignment.Scope.Domain = roles.Domain{ID: domain.ID, Name: domain.Name}
+	default:
+		return nil, memoryError(http.StatusBadRequest, "either project or domain has to be specified")
+	}
+	return &assignment, nil
+}
+
+func (c *memoryIdentityClient) AssignSystemRole(roleID, userID string) error {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return err
+	}
+
+	if _, ok := c.identity.roles[roleID]; !ok {
+		return memoryError(http.StatusNotFound, "role `%s` doesn't exist", roleID)
+	}
+	user, ok := c.identity.users[userID]
+	if !ok {
+		return memoryError(http.StatusNotFound, "user `%s` doesn't exist", userID)
+	}
+	if user.systemRoles == nil {
+		user.systemRoles = make(map[string]bool)
+	}
+	user.systemRoles[roleID] = true
+	return nil
+}
+
+func (c *memoryIdentityClient) ListSystemRoles(userID string) ([]roles.Role, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	user, ok := c.identity.users[userID]
+	if !ok {
+		return nil, memoryError(http.StatusNotFound, "user `%s` doesn't exist", userID)
+	}
+	var result []roles.Role
+	for id := range user.systemRoles {
+		result = append(result, c.identity.roles[id])
+	}
+	return result, nil
+}
+
+func (c *memoryIdentityClient) ListProjects(opts projects.ListOpts) ([]projects.Project, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	var result []projects.Project
+	for _, project := range c.identity.projects {
+		if (opts.DomainID == "" || project.DomainID == opts.DomainID) && (opts.Name == "" || project.Name == opts.Name) {
+			result = append(result, project)
+		}
+	}
+	return result, nil
+}
+
+func (c *memoryIdentityClient) ListAvailableDomains() ([]domains.Domain, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	var result []domains.Domain
+	for _, domain := range c.identity.domains {
+		result = append(result, domain)
+	}
+	return result, nil
+}
+
+func (c *memoryIdentityClient) CreateApplicationCredential(userID string, opts applicationcredentials.CreateOpts) (*applicationcredentials.ApplicationCredential, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	token, err := c.authorize()
+	if err != nil {
+		return nil, err
+	}
+
+	if token.token.User.ID != userID {
+		return nil, memoryError(http.StatusForbidden, "application credentials can be created only for the authenticated user")
+	}
+	if token.appCredID != "" && !c.identity.appCreds[token.appCredID].credential.Unrestricted {
+		return nil, memoryError(http.StatusForbidden, "restricted application credential can't create application credentials")
+	}
+	for _, credential := range c.identity.appCreds {
+		if credential.userID == userID && credential.credential.Name == opts.Name {
+			return nil, memoryError(http.StatusConflict, "duplicate application credential `%s`", opts.Name)
+		}
+	}
+
+	secret := opts.Secret
+	if secret == "" {
+		secret = memoryID()
+	}
+	credential := applicationcredentials.ApplicationCredential{
+		ID:           memoryID(),
+		Name:         opts.Name,
+		Description:  opts.Description,
+		Unrestricted: opts.Unrestricted,
+		Secret:       secret,
+		AccessRules:  opts.AccessRules,
+	}
+	if opts.ExpiresAt != nil {
+		credential.ExpiresAt = *opts.ExpiresAt
+	}
+	if token.token.Project != nil {
+		credential.ProjectID = token.token.Project.ID
+	}
+	c.identity.appCreds[credential.ID] = &memoryApplicationCredential{credential: credential, userID: userID}
+	return &credential, nil
+}
+
+func (c *memoryIdentityClient) ListApplicationCredentials(userID string, opts applicationcredentials.ListOpts) ([]applicationcredentials.ApplicationCredential, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	var result []applicationcredentials.ApplicationCredential
+	for _, credential := range c.identity.appCreds {
+		if credential.userID == userID && (opts.Name == "" || credential.credential.Name == opts.Name) {
+			listed := credential.credential
+			// secrets are returned only on creation
+			listed.Secret = ""
+			result = append(result, listed)
+		}
+	}
+	return result, nil
+}
+
+func (c *memoryIdentityClient) DeleteApplicationCredential(userID, id string) error {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return err
+	}
+
+	credential, ok := c.identity.appCreds[id]
+	if !ok || credential.userID != userID {
+		return memoryError(http.StatusNotFound, "application credential `%s` doesn't exist", id)
+	}
+	delete(c.identity.appCreds, id)
+	c.identity.revokeTokens(func(t *memoryToken) bool { return t.appCredID == id })
+	return nil
+}
+
+func (c *memoryIdentityClient) CreateEC2Credential(userID, projectID string) (*ec2credentials.Credential, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := c.identity.users[userID]; !ok {
+		return nil, memoryError(http.StatusNotFound, "user `%s` doesn't exist", userID)
+	}
+	if _, ok := c.identity.projects[projectID]; !ok {
+		return nil, memoryError(http.StatusNotFound, "project `%s` doesn't exist", projectID)
+	}
+	credential := ec2credentials.Credential{
+		UserID:   userID,
+		TenantID: projectID,
+		Access:   memoryID(),
+		Secret:   memoryID(),
+	}
+	c.identity.ec2Creds[credential.Access] = credential
+	return &credential, nil
+}
+
+func (c *memoryIdentityClient) DeleteEC2Credential(userID, access string) error {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return err
+	}
+
+	credential, ok := c.identity.ec2Creds[access]
+	if !ok || credential.UserID != userID {
+		return memoryError(http.StatusNotFound, "EC2 credential `%s` doesn't exist", access)
+	}
+	delete(c.identity.ec2Creds, access)
+	return nil
+}
+
+func (c *memoryIdentityClient) CreateTrust(opts trusts.CreateOpts) (*trusts.Trust, error) {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	token, err := c.authorize()
+	if err != nil {
+		return nil, err
+	}
+
+	if token.token.User.ID != opts.TrustorUserID {
+		return nil, memoryError(http.StatusForbidden, "trusts can be created only by the trustor")
+	}
+	if _, ok := c.identity.users[opts.TrusteeUserID]; !ok {
+		return nil, memoryError(http.StatusNotFound, "user `%s` doesn't exist", opts.TrusteeUserID)
+	}
+	if _, ok := c.identity.projects[opts.ProjectID]; !ok {
+		return nil, memoryError(http.StatusNotFound, "project `%s` doesn't exist", opts.ProjectID)
+	}
+	trust := trusts.Trust{
+		ID:            memoryID(),
+		Impersonation: opts.Impersonation,
+		TrusteeUserID: opts.TrusteeUserID,
+		TrustorUserID: opts.TrustorUserID,
+		ProjectID:     opts.ProjectID,
+		Roles:         opts.Roles,
+	}
+	if opts.ExpiresAt != nil {
+		trust.ExpiresAt = *opts.ExpiresAt
+	}
+	c.identity.trusts[trust.ID] = trust
+	return &trust, nil
+}
+
+func (c *memoryIdentityClient) DeleteTrust(id string) error {
+	c.identity.lock.Lock()
+	defer c.identity.lock.Unlock()
+
+	if _, err := c.authorize(); err != nil {
+		return err
+	}
+
+	if _, ok := c.identity.trusts[id]; !ok {
+		return memoryError(http.StatusNotFound, "trust `%s` doesn't exist", id)
+	}
+	c.identity.deleteTrust(id)
+	return nil
+}
+
+// deleteTrust removes the trust together with tokens issued for it
+func (m *MemoryIdentity) deleteTrust(id string) {
+	delete(m.trusts, id)
+	m.revokeTokens(func(t *memoryToken) bool { return t.token.TrustID == id })
+}
+
+// memoryID returns random ID in the format used by Keystone
+func memoryID() string {
+	id, _ := uuid.GenerateUUID()
+	return strings.ReplaceAll(id, "-", "")
+}
+
+// memoryError returns gophercloud error matching the status code, like the ones returned for Keystone responses
+func memoryError(code int, format string, args ...interface{}) error {
+	base := gophercloud.ErrUnexpectedResponseCode{
+		Method:   "memory",
+		Expected: []int{http.StatusOK},
+		Actual:   code,
+		Body:     []byte(fmt.Sprintf(format, args...)),
+	}
+	switch code {
+	case http.StatusBadRequest:
+		return gophercloud.ErrDefault400{ErrUnexpectedResponseCode: base}
+	case http.StatusUnauthorized:
+		return gophercloud.ErrDefault401{ErrUnexpectedResponseCode: base}
+	case http.StatusForbidden:
+		return gophercloud.ErrDefault403{ErrUnexpectedResponseCode: base}
+	case http.StatusNotFound:
+		return gophercloud.ErrDefault404{ErrUnexpectedResponseCode: base}
+	case http.StatusConflict:
+		return gophercloud.ErrDefault409{ErrUnexpectedResponseCode: base}
+	default:
+		return base
+	}
+}
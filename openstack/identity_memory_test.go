@@ -0,0 +1,294 @@
+package openstack
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/acceptance/tools"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/applicationcredentials"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/users"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMemoryBackend returns backend using in-memory identity service with the root user of `testCloudName` cloud
+func testMemoryBackend(t *testing.T) (*backend, logical.Storage, *MemoryIdentity) {
+	t.Helper()
+
+	identity := NewMemoryIdentity()
+	identity.AddUser(MemoryDefaultDomainID, testUsername, testPassword1)
+
+	config := logical.TestBackendConfig()
+	config.StorageView = new(logical.InmemStorage)
+	config.System = logical.TestSystemView()
+	config.Logger = hclog.NewNullLogger()
+
+	b, err := FactoryWithIdentityClient(identity.Authenticate)(context.Background(), config)
+	require.NoError(t, err)
+
+	entry, err := logical.StorageEntryJSON(storageCloudKey(testCloudName), &OsCloud{
+		Name:             testCloudName,
+		AuthURL:          "https://memory/v3",
+		UserDomainName:   "Default",
+		Username:         testUsername,
+		Password:         testPassword1,
+		UsernameTemplate: DefaultUsernameTemplate,
+	})
+	require.NoError(t, err)
+	require.NoError(t, config.StorageView.Put(context.Background(), entry))
+
+	return b.(*backend), config.StorageView, identity
+}
+
+func TestMemoryIdentity_authenticate(t *testing.T) {
+	identity := NewMemoryIdentity()
+	domain := identity.AddDomain("domain")
+	project := identity.AddProject(domain.ID, "project")
+	identity.AddUser(domain.ID, testUsername, testPassword1)
+
+	cases := map[string]*OsCloud{
+		"domain-scoped": {
+			Username:       testUsername,
+			Password:       testPassword1,
+			UserDomainName: domain.Name,
+		},
+		"project-scoped": {
+			Username:       testUsername,
+			Password:       testPassword1,
+			UserDomainName: domain.Name,
+			ProjectName:    project.Name,
+		},
+	}
+	for name, cloud := range cases {
+		cloud := cloud
+		t.Run(name, func(t *testing.T) {
+			client, _, err := identity.Authenticate(context.Background(), cloud)
+			require.NoError(t, err)
+
+			domainID, err := getTokenDomainID(client)
+			require.NoError(t, err)
+			assert.Equal(t, domain.ID, domainID)
+		})
+	}
+
+	t.Run("invalid-password", func(t *testing.T) {
+		_, _, err := identity.Authenticate(context.Background(), &OsCloud{
+			Username:       testUsername,
+			Password:       testPassword2,
+			UserDomainName: domain.Name,
+		})
+		assert.IsType(t, gophercloud.ErrDefault401{}, err)
+	})
+
+	t.Run("revoked-token", func(t *testing.T) {
+		client, _, err := identity.Authenticate(context.Background(), &OsCloud{
+			Username:       testUsername,
+			Password:       testPassword1,
+			UserDomainName: domain.Name,
+		})
+		require.NoError(t, err)
+		require.NoError(t, client.RevokeToken(client.TokenID()))
+
+		_, err = client.ListRoles()
+		assert.IsType(t, gophercloud.ErrDefault401{}, err)
+	})
+}
+
+func TestMemoryIdentity_applicationCredential(t *testing.T) {
+	identity := NewMemoryIdentity()
+	user := identity.AddUser(MemoryDefaultDomainID, testUsername, testPassword1)
+
+	client, _, err := identity.Authenticate(context.Background(), &OsCloud{
+		Username:       testUsername,
+		Password:       testPassword1,
+		UserDomainName: "Default",
+	})
+	require.NoError(t, err)
+
+	credential, err := client.CreateApplicationCredential(user.ID, applicationcredentials.CreateOpts{
+		Name:         "root",
+		Unrestricted: true,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, credential.Secret)
+
+	appCredClient, _, err := identity.Authenticate(context.Background(), &OsCloud{
+		AuthType:                    AuthTypeApplicationCredential,
+		ApplicationCredentialID:     credential.ID,
+		ApplicationCredentialSecret: credential.Secret,
+	})
+	require.NoError(t, err)
+
+	listed, err := appCredClient.ListApplicationCredentials(user.ID, applicationcredentials.ListOpts{Name: "root"})
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Empty(t, listed[0].Secret)
+
+	require.NoError(t, client.DeleteApplicationCredential(user.ID, credential.ID))
+	_, err = appCredClient.GetAuthToken()
+	assert.IsType(t, gophercloud.ErrDefault401{}, err)
+}
+
+func TestMemoryIdentity_changePassword(t *testing.T) {
+	identity := NewMemoryIdentity()
+	user := identity.AddUser(MemoryDefaultDomainID, testUsername, testPassword1)
+	cloud := &OsCloud{
+		Username:       testUsername,
+		Password:       testPassword1,
+		UserDomainName: "Default",
+	}
+
+	client, _, err := identity.Authenticate(context.Background(), cloud)
+	require.NoError(t, err)
+
+	err = client.ChangePassword(user.ID, users.ChangePasswordOpts{OriginalPassword: testPassword2, Password: testPassword2})
+	assert.IsType(t, gophercloud.ErrDefault401{}, err)
+
+	require.NoError(t, client.ChangePassword(user.ID, users.ChangePasswordOpts{OriginalPassword: testPassword1, Password: testPassword2}))
+
+	_, err = client.CreateToken(&tokens.AuthOptions{Username: testUsername, Password: testPassword2, DomainID: MemoryDefaultDomainID})
+	assert.IsType(t, gophercloud.ErrDefault401{}, err, "tokens of the user must be revoked")
+
+	_, _, err = identity.Authenticate(context.Background(), cloud)
+	assert.Error(t, err)
+
+	cloud.Password = testPassword2
+	_, _, err = identity.Authenticate(context.Background(), cloud)
+	assert.NoError(t, err)
+}
+
+func TestMemoryIdentity_userCredentials(t *testing.T) {
+	b, s, identity := testMemoryBackend(t)
+	project := identity.AddProject(MemoryDefaultDomainID, tools.RandomString("p", 5))
+	group := identity.AddGroup(MemoryDefaultDomainID, "developers")
+	role := identity.AddRole("member")
+
+	roleName := randomRoleName()
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      rolePath(roleName),
+		Data: map[string]interface{}{
+			"cloud":        testCloudName,
+			"project_name": project.Name,
+			"secret_type":  "password",
+			"user_groups":  []string{group.Name},
+			"user_roles":   []string{role.Name},
+		},
+		Storage: s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	res, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath(roleName),
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	userID := res.Secret.InternalData["user_id"].(string)
+	assert.Equal(t, []string{group.ID}, identity.UserGroups(userID))
+	assignments := identity.RoleAssignments(userID)
+	require.Len(t, assignments, 1)
+	assert.Equal(t, role.ID, assignments[0].Role.ID)
+	assert.Equal(t, project.ID, assignments[0].Scope.Project.ID)
+
+	auth := res.Data["auth"].(map[string]interface{})
+	_, _, err = identity.Authenticate(context.Background(), &OsCloud{
+		Username:       auth["username"].(string),
+		Password:       auth["password"].(string),
+		UserDomainName: "Default",
+		ProjectID:      project.ID,
+	})
+	require.NoError(t, err)
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Secret:    res.Secret,
+		Data:      res.Data,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	assert.Nil(t, identity.UserGroups(userID), "temporary user must be removed")
+}
+
+func TestMemoryIdentity_roleAssignments(t *testing.T) {
+	b, s, identity := testMemoryBackend(t)
+	project := identity.AddProject(MemoryDefaultDomainID, tools.RandomString("p", 5))
+	domain := identity.AddDomain(tools.RandomString("d", 5))
+	reader := identity.AddRole("reader")
+	member := identity.AddRole("member")
+
+	roleName := randomRoleName()
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      rolePath(roleName),
+		Data: map[string]interface{}{
+			"cloud":       testCloudName,
+			"secret_type": "password",
+			"role_assignments": []interface{}{
+				map[string]interface{}{"role": reader.Name, "project_name": project.Name},
+				map[string]interface{}{"role": member.Name, "domain_name": domain.Name, "inherited": true},
+			},
+		},
+		Storage: s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	res, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath(roleName),
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	userID := res.Secret.InternalData["user_id"].(string)
+	assignments := identity.RoleAssignments(userID)
+	require.Len(t, assignments, 1)
+	assert.Equal(t, reader.ID, assignments[0].Role.ID)
+	assert.Equal(t, project.ID, assignments[0].Scope.Project.ID)
+	inherited := identity.InheritedRoleAssignments(userID)
+	require.Len(t, inherited, 1)
+	assert.Equal(t, member.ID, inherited[0].Role.ID)
+	assert.Equal(t, domain.ID, inherited[0].Scope.Domain.ID)
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Secret:    res.Secret,
+		Data:      res.Data,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, identity.RoleAssignments(userID))
+	assert.Empty(t, identity.InheritedRoleAssignments(userID))
+}
+
+func TestMemoryIdentity_rotateRoot(t *testing.T) {
+	b, s, identity := testMemoryBackend(t)
+
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "rotate-root/" + testCloudName,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+	require.NoError(t, err)
+	require.NotEqual(t, testPassword1, cloudConfig.Password)
+
+	_, _, err = identity.Authenticate(context.Background(), cloudConfig)
+	assert.NoError(t, err)
+
+	cloudConfig.Password = testPassword1
+	_, _, err = identity.Authenticate(context.Background(), cloudConfig)
+	assert.IsType(t, gophercloud.ErrDefault401{}, err)
+}
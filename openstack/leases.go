@@ -0,0 +1,216 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
+)
+
+const leasesStoragePath = "leases"
+
+// leaseEntry is a record of OpenStack credentials issued for a lease.
+// The records allow to clean up the credentials when the cloud is removed.
+// The records are seal-wrapped, as tokens can only be revoked by their IDs.
+type leaseEntry struct {
+	ID         string `json:"id"`
+	Cloud      string `json:"cloud"`
+	Role       string `json:"role"`
+	SecretType string `json:"secret_type"`
+	Root       bool   `json:"root,omitempty"`
+	// Member is the root pool member which issued the root token
+	Member string `json:"member,omitempty"`
+	UserID string `json:"user_id,omitempty"`
+	Token  string `json:"token,omitempty"`
+	// EC2Access is the access key of the EC2 credential of the user
+	EC2Access string    `json:"ec2_access,omitempty"`
+	TrustID   string    `json:"trust_id,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+func leaseStoragePath(cloud, id string) string {
+	return fmt.Sprintf("%s/%s/%s", leasesStoragePath, cloud, id)
+}
+
+func saveLease(ctx context.Context, s logical.Storage, e *leaseEntry) error {
+	entry, err := logical.StorageEntryJSON(leaseStoragePath(e.Cloud, e.ID), e)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// getLease returns the lease record, nil is returned if the record doesn't exist
+func getLease(ctx context.Context, s logical.Storage, cloud, id string) (*leaseEntry, error) {
+	if id == "" {
+		return nil, nil
+	}
+	entry, err := s.Get(ctx, leaseStoragePath(cloud, id))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	lease := new(leaseEntry)
+	if err := entry.DecodeJSON(lease); err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+func deleteLease(ctx context.Context, s logical.Storage, cloud, id string) error {
+	if id == "" {
+		return nil
+	}
+	return s.Delete(ctx, leaseStoragePath(cloud, id))
+}
+
+func listLeases(ctx context.Context, s logical.Storage, cloud string) ([]*leaseEntry, error) {
+	keys, err := s.List(ctx, fmt.Sprintf("%s/%s/", leasesStoragePath, cloud))
+	if err != nil {
+		return nil, err
+	}
+
+	leases := make([]*leaseEntry, 0, len(keys))
+	for _, key := range keys {
+		lease, err := getLease(ctx, s, cloud, key)
+		if err != nil {
+			return nil, err
+		}
+		if lease == nil {
+			continue
+		}
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
+
+// leaseFromResponse returns lease record for the credentials response of the role
+func leaseFromResponse(role *roleEntry, resp *logical.Response) *leaseEntry {
+	if resp == nil || resp.Secret == nil {
+		return nil
+	}
+
+	internal := resp.Secret.InternalData
+	lease := &leaseEntry{
+		Cloud:      role.Cloud,
+		Role:       role.Name,
+		Root:       role.Root,
+		SecretType: fmt.Sprint(internal["secret_type"]),
+		ExpiresAt:  resp.Secret.IssueTime.Add(resp.Secret.TTL),
+	}
+
+	switch lease.SecretType {
+	case backendSecretTypeUser:
+		lease.UserID, _ = internal["user_id"].(string)
+		lease.EC2Access, _ = internal["ec2_access"].(string)
+		lease.ID = lease.UserID
+	case backendSecretTypeTrust:
+		lease.TrustID, _ = internal["trust_id"].(string)
+		lease.ID = lease.TrustID
+	case backendSecretTypeToken:
+		lease.ID, _ = internal["audit_id"].(string)
+		lease.Member, _ = internal["member"].(string)
+		if auth, ok := resp.Data["auth"].(map[string]interface{}); ok {
+			lease.Token, _ = auth["token"].(string)
+		}
+	}
+
+	if lease.ID == "" {
+		return nil
+	}
+	return lease
+}
+
+// revokeLease removes OpenStack credentials of the lease
+func revokeLease(client IdentityClient, lease *leaseEntry) error {
+	var err error
+	switch lease.SecretType {
+	case backendSecretTypeUser:
+		err = deleteUser(client, lease.UserID, lease.EC2Access)
+	case backendSecretTypeTrust:
+		err = client.DeleteTrust(lease.TrustID)
+	case backendSecretTypeToken:
+		err = client.RevokeToken(lease.Token)
+	default:
+		return fmt.Errorf("invalid secret type: %s", lease.SecretType)
+	}
+
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		// already removed or expired
+		return nil
+	}
+	if err != nil {
+		return common.KeystoneErrorf(err, "")
+	}
+	return nil
+}
+
+// revokeCloudLeases removes OpenStack credentials of all outstanding leases of the cloud
+func (b *backend) revokeCloudLeases(ctx context.Context, s logical.Storage, sCloud *sharedCloud) (int, error) {
+	leases, err := listLeases(ctx, s, sCloud.name)
+	if err != nil {
+		return 0, fmt.Errorf("error listing leases: %w", err)
+	}
+	if len(leases) == 0 {
+		return 0, nil
+	}
+
+	client, err := sCloud.getClient(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, lease := range leases {
+		if err := revokeLease(client, lease); err != nil {
+			return i, fmt.Errorf("error revoking credentials of role `%s`: %w", lease.Role, err)
+		}
+		if err := deleteLease(ctx, s, lease.Cloud, lease.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(leases), nil
+}
+
+// revokeRootTokens revokes the given tokens issued for the previous root credentials together with
+// root tokens of outstanding leases issued for the same root identity.
+func (b *backend) revokeRootTokens(ctx context.Context, s logical.Storage, sCloud *sharedCloud, cloudConfig *OsCloud, previousTokens ...string) (int, error) {
+	leases, err := listLeases(ctx, s, sCloud.name)
+	if err != nil {
+		return 0, fmt.Errorf("error listing leases: %w", err)
+	}
+
+	client, err := sCloud.getClient(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, token := range previousTokens {
+		if token == "" {
+			continue
+		}
+		if err := revokeLease(client, &leaseEntry{SecretType: backendSecretTypeToken, Token: token}); err != nil {
+			return revoked, fmt.Errorf("error revoking previous root token: %w", err)
+		}
+		revoked++
+	}
+
+	for _, lease := range leases {
+		if !lease.Root || lease.SecretType != backendSecretTypeToken || lease.Member != cloudConfig.member {
+			continue
+		}
+		if err := revokeLease(client, lease); err != nil {
+			return revoked, fmt.Errorf("error revoking root token `%s`: %w", lease.ID, err)
+		}
+		if err := deleteLease(ctx, s, lease.Cloud, lease.ID); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
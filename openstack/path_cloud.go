@@ -3,8 +3,16 @@ package openstack
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/groups"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/projects"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/users"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
 	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/vars"
 	"time"
 )
@@ -24,6 +32,13 @@ Configure the root credentials for an OpenStack cloud using the above parameters
 	defaultRootPasswordTTL  = 1440 * time.Hour
 )
 
+type authType string
+
+const (
+	AuthTypePassword              authType = "password"
+	AuthTypeApplicationCredential authType = "application_credential"
+)
+
 func storageCloudKey(name string) string {
 	return fmt.Sprintf("%s/%s", pathCloud, name)
 }
@@ -33,15 +48,38 @@ func pathCloudKey(name string) string {
 }
 
 type OsCloud struct {
-	Name                       string        `json:"name"`
-	AuthURL                    string        `json:"auth_url"`
-	UserDomainName             string        `json:"user_domain_name"`
-	Username                   string        `json:"username"`
-	Password                   string        `json:"password"`
-	UsernameTemplate           string        `json:"username_template"`
-	PasswordPolicy             string        `json:"password_policy"`
-	RootPasswordTTL            time.Duration `json:"root_password_ttl"`
-	RootPasswordExpirationDate time.Time     `json:"root_password_expiration_date"`
+	Name                        string        `json:"name"`
+	AuthURL                     string        `json:"auth_url"`
+	AuthType                    authType      `json:"auth_type"`
+	UserDomainName              string        `json:"user_domain_name"`
+	Username                    string        `json:"username"`
+	Password                    string        `json:"password"`
+	ApplicationCredentialID     string        `json:"application_credential_id"`
+	ApplicationCredentialName   string        `json:"application_credential_name"`
+	ApplicationCredentialSecret string        `json:"application_credential_secret"`
+	ProjectID                   string        `json:"project_id"`
+	ProjectName                 string        `json:"project_name"`
+	ProjectDomainName           string        `json:"project_domain_name"`
+	CACert                      string        `json:"ca_cert"`
+	ClientCert                  string        `json:"client_cert"`
+	ClientKey                   string        `json:"client_key"`
+	Insecure                    bool          `json:"insecure"`
+	UsernameTemplate            string        `json:"username_template"`
+	PasswordPolicy              string        `json:"password_policy"`
+	RootPasswordTTL             time.Duration `json:"root_password_ttl"`
+	RootPasswordExpirationDate  time.Time     `json:"root_password_expiration_date"`
+	RotationSchedule            string        `json:"rotation_schedule"`
+	RotationWindow              time.Duration `json:"rotation_window"`
+	RevokeRootTokens            bool          `json:"revoke_root_tokens"`
+	RetryMaxAttempts            int           `json:"retry_max_attempts"`
+	RetryBackoff                time.Duration `json:"retry_backoff"`
+	RetryMaxBackoff             time.Duration `json:"retry_max_backoff"`
+	RetryJitter                 bool          `json:"retry_jitter"`
+
+	// member is the name of the root pool member the configuration is built for
+	member string
+	// breaker is the circuit breaker of the cloud requests are reported to
+	breaker *circuitBreaker
 }
 
 func (c *sharedCloud) getCloudConfig(ctx context.Context, s logical.Storage) (*OsCloud, error) {
@@ -56,10 +94,69 @@ func (c *sharedCloud) getCloudConfig(ctx context.Context, s logical.Storage) (*O
 	if err := entry.DecodeJSON(cloud); err != nil {
 		return nil, err
 	}
+	// clouds stored before `auth_type` was introduced use password auth
+	if cloud.AuthType == "" {
+		cloud.AuthType = AuthTypePassword
+	}
+	c.breaker.target(cloud.AuthURL)
 	return cloud, nil
 }
 
+func (cloud *OsCloud) validate() error {
+	switch cloud.AuthType {
+	case AuthTypePassword:
+	case AuthTypeApplicationCredential:
+		if cloud.ApplicationCredentialSecret == "" {
+			return fmt.Errorf("application_credential_secret is required for `%s` auth type", cloud.AuthType)
+		}
+		if cloud.ApplicationCredentialID == "" {
+			if cloud.ApplicationCredentialName == "" {
+				return fmt.Errorf("either application_credential_id or application_credential_name is required for `%s` auth type", cloud.AuthType)
+			}
+			if cloud.Username == "" || cloud.UserDomainName == "" {
+				return fmt.Errorf("username and user_domain_name are required to use application_credential_name")
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported auth type: %s", cloud.AuthType)
+	}
+	if cloud.ProjectID != "" || cloud.ProjectName != "" {
+		if cloud.AuthType == AuthTypeApplicationCredential {
+			return fmt.Errorf("project scope can't be set for `%s` auth type", cloud.AuthType)
+		}
+		if cloud.ProjectID == "" && cloud.ProjectDomainName == "" && cloud.UserDomainName == "" {
+			return fmt.Errorf("project_domain_name or user_domain_name is required to use project_name")
+		}
+	}
+	if _, err := cloud.tlsConfig(); err != nil {
+		return err
+	}
+	if _, err := cloud.schedule(); err != nil {
+		return err
+	}
+	if err := cloud.validateRetryPolicy(); err != nil {
+		return err
+	}
+	if cloud.RotationWindow < 0 {
+		return fmt.Errorf("rotation_window can't be negative")
+	}
+	if cloud.RotationWindow != 0 && cloud.RotationSchedule == "" {
+		return fmt.Errorf("rotation_window requires rotation_schedule to be set")
+	}
+	return nil
+}
+
 func (cloud *OsCloud) save(ctx context.Context, s logical.Storage) error {
+	if cloud.member != "" {
+		member := &rootMember{
+			Name:                       cloud.member,
+			Username:                   cloud.Username,
+			Password:                   cloud.Password,
+			RootPasswordExpirationDate: cloud.RootPasswordExpirationDate,
+		}
+		return member.save(ctx, s, cloud.Name)
+	}
+
 	entry, err := logical.StorageEntryJSON(storageCloudKey(cloud.Name), cloud)
 	if err != nil {
 		return err
@@ -92,6 +189,58 @@ func (b *backend) pathCloud() *framework.Path {
 				Required:    true,
 				Description: "OpenStack username of the root user.",
 			},
+			"auth_type": {
+				Type:          framework.TypeLowerCaseString,
+				Description:   "Authentication method used by the root user.",
+				AllowedValues: []interface{}{string(AuthTypePassword), string(AuthTypeApplicationCredential)},
+				Default:       string(AuthTypePassword),
+			},
+			"application_credential_id": {
+				Type:        framework.TypeString,
+				Description: "ID of the application credential of the root user.",
+			},
+			"application_credential_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the application credential of the root user.",
+			},
+			"application_credential_secret": {
+				Type:        framework.TypeString,
+				Description: "Secret of the application credential of the root user.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"project_id": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "ID of the project used to scope the root token.",
+			},
+			"project_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the project used to scope the root token.",
+			},
+			"project_domain_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the domain of the project used to scope the root token. Defaults to `user_domain_name`.",
+			},
+			"ca_cert": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded CA bundle used to verify the identity service certificate.",
+			},
+			"client_cert": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded client certificate used for TLS authentication.",
+			},
+			"client_key": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded private key of the client certificate.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"insecure": {
+				Type:        framework.TypeBool,
+				Description: "Disable verification of the identity service certificate.",
+			},
 			"username_template": {
 				Type:        framework.TypeString,
 				Default:     DefaultUsernameTemplate,
@@ -109,12 +258,61 @@ func (b *backend) pathCloud() *framework.Path {
 				Type:        framework.TypeString,
 				Description: "Name of the password policy to use to generate passwords for dynamic credentials.",
 			},
+			"verify_connection": {
+				Type:        framework.TypeBool,
+				Default:     true,
+				Description: "Verify that the root user can authenticate and has required permissions before saving the cloud.",
+			},
+			"revoke_root_tokens": {
+				Type:        framework.TypeBool,
+				Description: "Revoke root tokens issued by Vault for the previous root credentials after each root rotation.",
+			},
+			"rotate_on_create": {
+				Type:        framework.TypeBool,
+				Description: "Rotate the root credentials right after the cloud is saved. Enabled by default for new clouds.",
+			},
+			"force": {
+				Type:        framework.TypeBool,
+				Description: "Delete the cloud even if it is used by roles or static roles.",
+			},
+			"cascade": {
+				Type:        framework.TypeBool,
+				Description: "Delete the cloud together with its roles and static roles and revoke OpenStack credentials of their outstanding leases.",
+			},
 			"root_password_ttl": {
 				Type:        framework.TypeDurationSecond,
 				Default:     defaultRootPasswordTTL,
 				Description: "The TTL of the root password for openstack user. This can be either a number of seconds or a time formatted duration (ex: 24h, 48ds)",
 				Required:    false,
 			},
+			"rotation_schedule": {
+				Type:        framework.TypeString,
+				Description: "Cron schedule of the root password rotation (ex: `CRON_TZ=Europe/Berlin 0 2 * * SUN`). Overrides `root_password_ttl`.",
+			},
+			"rotation_window": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Duration after each scheduled time during which the rotation can be started. Defaults to 1 hour.",
+			},
+			"retry_max_attempts": {
+				Type:        framework.TypeInt,
+				Default:     defaultRetryMaxAttempts,
+				Description: "Maximum number of attempts of Keystone requests failed with 429, 502, 503 or 504 status, POST and PATCH requests are only retried after 429 and 503. Set to 1 to disable retries.",
+			},
+			"retry_backoff": {
+				Type:        framework.TypeDurationSecond,
+				Default:     int(defaultRetryBackoff.Seconds()),
+				Description: "Delay before the first retry, doubled for each next retry.",
+			},
+			"retry_max_backoff": {
+				Type:        framework.TypeDurationSecond,
+				Default:     int(defaultRetryMaxBackoff.Seconds()),
+				Description: "Maximum delay between retries, also limiting delays requested using Retry-After header.",
+			},
+			"retry_jitter": {
+				Type:        framework.TypeBool,
+				Default:     true,
+				Description: "Randomize delays between retries.",
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.CreateOperation: &framework.PathOperation{
@@ -171,15 +369,35 @@ func (b *backend) pathCloudCreateUpdate(ctx context.Context, r *logical.Request,
 		return nil, fmt.Errorf(vars.ErrCloudConf)
 	}
 
+	rotate := cloudConfig == nil
+	if rotateOnCreate, ok := d.GetOk("rotate_on_create"); ok {
+		rotate = rotateOnCreate.(bool)
+	}
+
 	if cloudConfig == nil {
 		cloudConfig = &OsCloud{
-			Name: name,
+			Name:        name,
+			RetryJitter: true,
 		}
 	}
 
 	if authURL, ok := d.GetOk("auth_url"); ok {
 		cloudConfig.AuthURL = authURL.(string)
 	}
+	if aType, ok := d.GetOk("auth_type"); ok {
+		cloudConfig.AuthType = authType(aType.(string))
+	} else if cloudConfig.AuthType == "" {
+		cloudConfig.AuthType = AuthTypePassword
+	}
+	if id, ok := d.GetOk("application_credential_id"); ok {
+		cloudConfig.ApplicationCredentialID = id.(string)
+	}
+	if name, ok := d.GetOk("application_credential_name"); ok {
+		cloudConfig.ApplicationCredentialName = name.(string)
+	}
+	if secret, ok := d.GetOk("application_credential_secret"); ok {
+		cloudConfig.ApplicationCredentialSecret = secret.(string)
+	}
 	if userDomainName, ok := d.GetOk("user_domain_name"); ok {
 		cloudConfig.UserDomainName = userDomainName.(string)
 	}
@@ -189,6 +407,27 @@ func (b *backend) pathCloudCreateUpdate(ctx context.Context, r *logical.Request,
 	if password, ok := d.GetOk("password"); ok {
 		cloudConfig.Password = password.(string)
 	}
+	if id, ok := d.GetOk("project_id"); ok {
+		cloudConfig.ProjectID = id.(string)
+	}
+	if name, ok := d.GetOk("project_name"); ok {
+		cloudConfig.ProjectName = name.(string)
+	}
+	if name, ok := d.GetOk("project_domain_name"); ok {
+		cloudConfig.ProjectDomainName = name.(string)
+	}
+	if caCert, ok := d.GetOk("ca_cert"); ok {
+		cloudConfig.CACert = caCert.(string)
+	}
+	if clientCert, ok := d.GetOk("client_cert"); ok {
+		cloudConfig.ClientCert = clientCert.(string)
+	}
+	if clientKey, ok := d.GetOk("client_key"); ok {
+		cloudConfig.ClientKey = clientKey.(string)
+	}
+	if insecure, ok := d.GetOk("insecure"); ok {
+		cloudConfig.Insecure = insecure.(bool)
+	}
 	if uTemplate, ok := d.GetOk("username_template"); ok {
 		cloudConfig.UsernameTemplate = uTemplate.(string)
 		// validate template first
@@ -208,19 +447,76 @@ func (b *backend) pathCloudCreateUpdate(ctx context.Context, r *logical.Request,
 	} else if r.Operation == logical.CreateOperation && cloudConfig.RootPasswordTTL == 0 {
 		cloudConfig.RootPasswordTTL = defaultRootPasswordTTL
 	}
+	if schedule, ok := d.GetOk("rotation_schedule"); ok {
+		cloudConfig.RotationSchedule = schedule.(string)
+	}
+	if window, ok := d.GetOk("rotation_window"); ok {
+		cloudConfig.RotationWindow = time.Second * time.Duration(window.(int))
+	}
+	if revoke, ok := d.GetOk("revoke_root_tokens"); ok {
+		cloudConfig.RevokeRootTokens = revoke.(bool)
+	}
+	if attempts, ok := d.GetOk("retry_max_attempts"); ok {
+		cloudConfig.RetryMaxAttempts = attempts.(int)
+	} else if r.Operation == logical.CreateOperation && cloudConfig.RetryMaxAttempts == 0 {
+		cloudConfig.RetryMaxAttempts = defaultRetryMaxAttempts
+	}
+	if backoff, ok := d.GetOk("retry_backoff"); ok {
+		cloudConfig.RetryBackoff = time.Second * time.Duration(backoff.(int))
+	} else if r.Operation == logical.CreateOperation && cloudConfig.RetryBackoff == 0 {
+		cloudConfig.RetryBackoff = defaultRetryBackoff
+	}
+	if maxBackoff, ok := d.GetOk("retry_max_backoff"); ok {
+		cloudConfig.RetryMaxBackoff = time.Second * time.Duration(maxBackoff.(int))
+	} else if r.Operation == logical.CreateOperation && cloudConfig.RetryMaxBackoff == 0 {
+		cloudConfig.RetryMaxBackoff = defaultRetryMaxBackoff
+	}
+	if jitter, ok := d.GetOk("retry_jitter"); ok {
+		cloudConfig.RetryJitter = jitter.(bool)
+	}
 
-	cloudConfig.RootPasswordExpirationDate = time.Now().Add(cloudConfig.RootPasswordTTL)
+	if err := cloudConfig.validate(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 
-	sCloud.passwords = &Passwords{
-		PolicyGenerator: b.System(),
-		PolicyName:      cloudConfig.PasswordPolicy,
+	var resp *logical.Response
+	if d.Get("verify_connection").(bool) {
+		verification, err := sCloud.requireCloudPermissions(ctx, cloudConfig)
+		if err != nil {
+			if verification == nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+			// the report of missing permissions is returned together with the error
+			errResp := logical.ErrorResponse(err.Error())
+			errResp.Data["verification"] = verification.toMap()
+			return logical.RespondWithStatusCode(errResp, r, http.StatusBadRequest)
+		}
+		resp = &logical.Response{
+			Data: map[string]interface{}{
+				"verification": verification.toMap(),
+			},
+		}
 	}
 
+	cloudConfig.RootPasswordExpirationDate = cloudConfig.nextRotation(time.Now())
+
 	if err := cloudConfig.save(ctx, r.Storage); err != nil {
 		return logical.ErrorResponse(err.Error()), nil
 	}
+	// the cached client may be authenticated using previous configuration
+	b.evictCloud(name)
+	sCloud = b.getSharedCloud(name)
+	sCloud.breaker.target(cloudConfig.AuthURL)
+
+	if rotate {
+		if err := b.rotateRoot(ctx, r.Storage, sCloud, cloudConfig, rotationTriggerCreate, ""); err != nil {
+			return logical.ErrorResponse(
+				"cloud `%s` is saved with the supplied root credentials, but their rotation failed: %s", name, err,
+			), nil
+		}
+	}
 
-	return nil, nil
+	return resp, nil
 }
 
 func (b *backend) pathCloudRead(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
@@ -235,25 +531,131 @@ func (b *backend) pathCloudRead(ctx context.Context, r *logical.Request, d *fram
 
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"auth_url":          cloudConfig.AuthURL,
-			"user_domain_name":  cloudConfig.UserDomainName,
-			"username":          cloudConfig.Username,
-			"username_template": cloudConfig.UsernameTemplate,
-			"password_policy":   cloudConfig.PasswordPolicy,
-			"root_password_ttl": int(cloudConfig.RootPasswordTTL.Seconds()),
-			"next_rotation":     cloudConfig.RootPasswordExpirationDate.Format(time.RFC822),
+			"auth_url":                    cloudConfig.AuthURL,
+			"auth_type":                   string(cloudConfig.AuthType),
+			"user_domain_name":            cloudConfig.UserDomainName,
+			"username":                    cloudConfig.Username,
+			"application_credential_id":   cloudConfig.ApplicationCredentialID,
+			"application_credential_name": cloudConfig.ApplicationCredentialName,
+			"project_id":                  cloudConfig.ProjectID,
+			"project_name":                cloudConfig.ProjectName,
+			"project_domain_name":         cloudConfig.ProjectDomainName,
+			"ca_cert":                     cloudConfig.CACert,
+			"client_cert":                 cloudConfig.ClientCert,
+			"insecure":                    cloudConfig.Insecure,
+			"username_template":           cloudConfig.UsernameTemplate,
+			"password_policy":             cloudConfig.PasswordPolicy,
+			"root_password_ttl":           int(cloudConfig.RootPasswordTTL.Seconds()),
+			"next_rotation":               cloudConfig.RootPasswordExpirationDate.Format(time.RFC822),
+			"rotation_schedule":           cloudConfig.RotationSchedule,
+			"rotation_window":             int(cloudConfig.RotationWindow.Seconds()),
+			"revoke_root_tokens":          cloudConfig.RevokeRootTokens,
+			"retry_max_attempts":          cloudConfig.RetryMaxAttempts,
+			"retry_backoff":               int(cloudConfig.RetryBackoff.Seconds()),
+			"retry_max_backoff":           int(cloudConfig.RetryMaxBackoff.Seconds()),
+			"retry_jitter":                cloudConfig.RetryJitter,
 		},
 	}, nil
 }
 
 func (b *backend) pathCloudDelete(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	name := d.Get("name").(string)
+	force := d.Get("force").(bool)
+	cascade := d.Get("cascade").(bool)
+
+	roleNames, staticRoleNames, err := cloudDependents(ctx, r, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !force && !cascade && (len(roleNames) > 0 || len(staticRoleNames) > 0) {
+		return logical.ErrorResponse(
+			"cloud `%s` is used by roles %v and static roles %v, use `cascade` or `force` to delete it",
+			name, roleNames, staticRoleNames,
+		), nil
+	}
+
+	var resp *logical.Response
+	if cascade {
+		for _, roleName := range roleNames {
+			if err := r.Storage.Delete(ctx, roleStoragePath(roleName)); err != nil {
+				return nil, fmt.Errorf("error deleting role: %w", err)
+			}
+		}
+		for _, roleName := range staticRoleNames {
+			if err := r.Storage.Delete(ctx, roleStaticStoragePath(roleName)); err != nil {
+				return nil, fmt.Errorf("error deleting static role: %w", err)
+			}
+		}
+
+		revoked, err := b.revokeCloudLeases(ctx, r.Storage, b.getSharedCloud(name))
+		if err != nil {
+			return nil, common.KeystoneErrorf(err, "error revoking leases of the cloud")
+		}
+
+		resp = &logical.Response{
+			Data: map[string]interface{}{
+				"deleted_roles":        roleNames,
+				"deleted_static_roles": staticRoleNames,
+				"revoked_leases":       revoked,
+			},
+		}
+	}
 
 	if err := r.Storage.Delete(ctx, storageCloudKey(name)); err != nil {
 		return nil, fmt.Errorf("error deleting cloud: %w", err)
 	}
+	if err := r.Storage.Delete(ctx, rotationStatusStorageKey(name)); err != nil {
+		return nil, fmt.Errorf("error deleting rotation status: %w", err)
+	}
+
+	members, err := listRootMembers(ctx, r.Storage, name)
+	if err != nil {
+		return nil, fmt.Errorf("error listing root pool: %w", err)
+	}
+	for _, member := range members {
+		if err := b.deleteRootMember(ctx, r.Storage, name, member); err != nil {
+			return nil, err
+		}
+	}
+	b.removeCloud(name)
 
-	return nil, nil
+	return resp, nil
+}
+
+// cloudDependents returns names of roles and static roles using the cloud
+func cloudDependents(ctx context.Context, r *logical.Request, cloud string) ([]string, []string, error) {
+	roleNames, err := r.Storage.List(ctx, rolesStoragePath+"/")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing roles: %w", err)
+	}
+	dependentRoles := make([]string, 0)
+	for _, name := range roleNames {
+		role, err := getRoleByName(ctx, name, r.Storage)
+		if err != nil {
+			return nil, nil, fmt.Errorf(vars.ErrRoleGetName)
+		}
+		if role != nil && role.Cloud == cloud {
+			dependentRoles = append(dependentRoles, name)
+		}
+	}
+
+	staticRoleNames, err := r.Storage.List(ctx, staticRolesStoragePath+"/")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing static roles: %w", err)
+	}
+	dependentStaticRoles := make([]string, 0)
+	for _, name := range staticRoleNames {
+		role, err := getStaticRoleByName(ctx, name, r)
+		if err != nil {
+			return nil, nil, err
+		}
+		if role != nil && role.Cloud == cloud {
+			dependentStaticRoles = append(dependentStaticRoles, name)
+		}
+	}
+
+	return dependentRoles, dependentStaticRoles, nil
 }
 
 func (b *backend) pathCloudList(ctx context.Context, r *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
@@ -264,3 +666,91 @@ func (b *backend) pathCloudList(ctx context.Context, r *logical.Request, _ *fram
 
 	return logical.ListResponse(clouds), nil
 }
+
+// cloudVerification describes which of the Keystone operations required by the plugin
+// are allowed for the root user
+type cloudVerification struct {
+	DomainID   string
+	Operations map[string]bool
+	Missing    []string
+}
+
+func (v *cloudVerification) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"domain_id":  v.DomainID,
+		"operations": v.Operations,
+		"missing":    v.Missing,
+	}
+}
+
+// requireCloudPermissions verifies the cloud and fails if the root user lacks any of the required permissions.
+// The verification is returned together with the error if the root user is authenticated.
+func (c *sharedCloud) requireCloudPermissions(ctx context.Context, cloud *OsCloud) (*cloudVerification, error) {
+	verification, err := c.verifyCloud(ctx, cloud)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying cloud connection: %w", err)
+	}
+	if len(verification.Missing) > 0 {
+		return verification, fmt.Errorf(
+			"root user is not allowed to perform required Keystone operations: %s",
+			strings.Join(verification.Missing, ", "),
+		)
+	}
+	return verification, nil
+}
+
+// verifyCloud authenticates the root user of the cloud and checks the permissions
+// required to manage users, groups, roles and projects in the root user domain
+func (c *sharedCloud) verifyCloud(ctx context.Context, cloud *OsCloud) (*cloudVerification, error) {
+	client, _, err := c.authenticate(ctx, cloud)
+	if err != nil {
+		return nil, err
+	}
+
+	domainID, err := getTokenDomainID(client)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := []struct {
+		operation string
+		check     func() error
+	}{
+		{"identity:list_users", func() error {
+			_, err := client.ListUsers(users.ListOpts{DomainID: domainID})
+			return err
+		}},
+		{"identity:list_groups", func() error {
+			_, err := client.ListGroups(groups.ListOpts{DomainID: domainID})
+			return err
+		}},
+		{"identity:list_roles", func() error {
+			_, err := client.ListRoles()
+			return err
+		}},
+		{"identity:list_projects", func() error {
+			_, err := client.ListProjects(projects.ListOpts{DomainID: domainID})
+			return err
+		}},
+	}
+
+	verification := &cloudVerification{
+		DomainID:   domainID,
+		Operations: make(map[string]bool, len(checks)),
+		Missing:    []string{},
+	}
+	for _, check := range checks {
+		err := check.check()
+		switch err.(type) {
+		case nil:
+			verification.Operations[check.operation] = true
+		case gophercloud.ErrDefault401, gophercloud.ErrDefault403:
+			verification.Operations[check.operation] = false
+			verification.Missing = append(verification.Missing, check.operation)
+		default:
+			return nil, common.KeystoneErrorf(err, "error checking `%s` operation", check.operation)
+		}
+	}
+
+	return verification, nil
+}
@@ -0,0 +1,62 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/vars"
+)
+
+const (
+	pathCloudHealthHelpSyn  = "Read the state of the identity service circuit breaker of an OpenStack cloud."
+	pathCloudHealthHelpDesc = `
+Requests to the identity service of the cloud are stopped after repeated connection errors or 5xx responses.
+While the breaker is open, requests fail fast with 503 and the service is probed periodically.
+The endpoint responds with 503 while the breaker is not closed.
+`
+)
+
+var pathCloudHealth = fmt.Sprintf("%s/%s/health", pathCloud, framework.GenericNameWithAtRegex("name"))
+
+func (b *backend) pathCloudHealth() *framework.Path {
+	return &framework.Path{
+		Pattern: pathCloudHealth,
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: "Name of the cloud.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathCloudHealthRead,
+			},
+		},
+		HelpSynopsis:    pathCloudHealthHelpSyn,
+		HelpDescription: pathCloudHealthHelpDesc,
+	}
+}
+
+func (b *backend) pathCloudHealthRead(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	sCloud := b.getSharedCloud(name)
+	cloudConfig, err := sCloud.getCloudConfig(ctx, r.Storage)
+	if err != nil {
+		return nil, fmt.Errorf(vars.ErrCloudConf)
+	}
+	if cloudConfig == nil {
+		return logical.ErrorResponse("cloud `%s` doesn't exist", name), nil
+	}
+
+	status := sCloud.breaker.status()
+	resp := &logical.Response{Data: status}
+	if status["state"] != string(breakerClosed) {
+		return logical.RespondWithStatusCode(resp, r, http.StatusServiceUnavailable)
+	}
+	return resp, nil
+}
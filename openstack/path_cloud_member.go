@@ -0,0 +1,223 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/vars"
+)
+
+const (
+	pathCloudMemberHelpSyn  = "Manage additional root identities of an OpenStack cloud."
+	pathCloudMemberHelpDesc = `
+Root identities of the cloud form a pool used to issue credentials. The identities are used in turn,
+skipping ones failed to authenticate recently or being rotated. Each identity is rotated independently.
+`
+	pathCloudMembersHelpSyn = "List additional root identities of an OpenStack cloud."
+)
+
+var (
+	pathCloudMember  = fmt.Sprintf("%s/%s/members/%s", pathCloud, framework.GenericNameWithAtRegex("name"), framework.GenericNameRegex("member"))
+	pathCloudMembers = fmt.Sprintf("%s/%s/members/?$", pathCloud, framework.GenericNameWithAtRegex("name"))
+)
+
+func (b *backend) pathCloudMember() *framework.Path {
+	return &framework.Path{
+		Pattern: pathCloudMember,
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeLowerCaseString,
+				Required:    true,
+				Description: "Name of the cloud.",
+			},
+			"member": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: "Name of the root identity.",
+			},
+			"username": {
+				Type:        framework.TypeString,
+				Description: "OpenStack username of the root identity. The user must be in the cloud's user domain.",
+			},
+			"password": {
+				Type:        framework.TypeString,
+				Description: "OpenStack password of the root identity.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"verify_connection": {
+				Type:        framework.TypeBool,
+				Default:     true,
+				Description: "Verify that the root identity can authenticate and has required permissions before saving it.",
+			},
+			"rotate_on_create": {
+				Type:        framework.TypeBool,
+				Description: "Rotate the password right after the identity is saved. Enabled by default for new identities.",
+			},
+		},
+		ExistenceCheck: b.cloudMemberExistenceCheck,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.CreateOperation: &framework.PathOperation{
+				Callback: b.pathCloudMemberCreateUpdate,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathCloudMemberCreateUpdate,
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathCloudMemberRead,
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathCloudMemberDelete,
+			},
+		},
+		HelpSynopsis:    pathCloudMemberHelpSyn,
+		HelpDescription: pathCloudMemberHelpDesc,
+	}
+}
+
+func (b *backend) pathCloudMembers() *framework.Path {
+	return &framework.Path{
+		Pattern: pathCloudMembers,
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeLowerCaseString,
+				Required:    true,
+				Description: "Name of the cloud.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathCloudMembersList,
+			},
+		},
+		HelpSynopsis:    pathCloudMembersHelpSyn,
+		HelpDescription: pathCloudMemberHelpDesc,
+	}
+}
+
+func (b *backend) cloudMemberExistenceCheck(ctx context.Context, r *logical.Request, d *framework.FieldData) (bool, error) {
+	member, err := getRootMember(ctx, r.Storage, d.Get("name").(string), d.Get("member").(string))
+	if err != nil {
+		return false, err
+	}
+	return member != nil, nil
+}
+
+func (b *backend) pathCloudMemberCreateUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	memberName := d.Get("member").(string)
+
+	sCloud := b.getSharedCloud(name)
+	cloudConfig, err := sCloud.getCloudConfig(ctx, r.Storage)
+	if err != nil {
+		return nil, fmt.Errorf(vars.ErrCloudConf)
+	}
+	if cloudConfig == nil {
+		return logical.ErrorResponse("cloud `%s` doesn't exist", name), nil
+	}
+
+	member, err := getRootMember(ctx, r.Storage, name, memberName)
+	if err != nil {
+		return nil, err
+	}
+	rotate := member == nil
+	if member == nil {
+		member = &rootMember{Name: memberName}
+	}
+	if rotateOnCreate, ok := d.GetOk("rotate_on_create"); ok {
+		rotate = rotateOnCreate.(bool)
+	}
+
+	if username, ok := d.GetOk("username"); ok {
+		member.Username = username.(string)
+	}
+	if password, ok := d.GetOk("password"); ok {
+		member.Password = password.(string)
+	}
+	if member.Username == "" || member.Password == "" {
+		return logical.ErrorResponse("username and password of the root identity are required"), nil
+	}
+
+	var resp *logical.Response
+	if d.Get("verify_connection").(bool) {
+		verification, err := sCloud.requireCloudPermissions(ctx, cloudConfig.forMember(member))
+		if err != nil {
+			if verification == nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+			// the report of missing permissions is returned together with the error
+			errResp := logical.ErrorResponse(err.Error())
+			errResp.Data["verification"] = verification.toMap()
+			return logical.RespondWithStatusCode(errResp, r, http.StatusBadRequest)
+		}
+		resp = &logical.Response{
+			Data: map[string]interface{}{
+				"verification": verification.toMap(),
+			},
+		}
+	}
+
+	member.RootPasswordExpirationDate = cloudConfig.nextRotation(time.Now())
+	if err := member.save(ctx, r.Storage, name); err != nil {
+		return nil, err
+	}
+	// the cached client may be authenticated using previous credentials
+	sCloud.evictMember(memberName)
+
+	if rotate {
+		memberConfig := cloudConfig.forMember(member)
+		if err := b.rotateRoot(ctx, r.Storage, sCloud, memberConfig, rotationTriggerCreate, ""); err != nil {
+			return logical.ErrorResponse(
+				"root identity `%s` is saved with the supplied password, but its rotation failed: %s", memberName, err,
+			), nil
+		}
+	}
+
+	return resp, nil
+}
+
+func (b *backend) pathCloudMemberRead(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	member, err := getRootMember(ctx, r.Storage, d.Get("name").(string), d.Get("member").(string))
+	if err != nil {
+		return nil, err
+	}
+	if member == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"username":      member.Username,
+			"next_rotation": member.RootPasswordExpirationDate.Format(time.RFC822),
+		},
+	}, nil
+}
+
+func (b *backend) pathCloudMemberDelete(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	return nil, b.deleteRootMember(ctx, r.Storage, d.Get("name").(string), d.Get("member").(string))
+}
+
+func (b *backend) pathCloudMembersList(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	members, err := listRootMembers(ctx, r.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(members), nil
+}
+
+// deleteRootMember removes the root identity from the pool of the cloud
+func (b *backend) deleteRootMember(ctx context.Context, s logical.Storage, cloud, member string) error {
+	if err := s.Delete(ctx, rootMemberStorageKey(cloud, member)); err != nil {
+		return fmt.Errorf("error deleting root identity: %w", err)
+	}
+	if err := s.Delete(ctx, rotationStatusStorageKey(fmt.Sprintf("%s/%s", cloud, member))); err != nil {
+		return fmt.Errorf("error deleting rotation status: %w", err)
+	}
+	b.getSharedCloud(cloud).evictMember(member)
+	return nil
+}
@@ -0,0 +1,337 @@
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	thClient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/fixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMemberName = "second"
+
+func memberPath(cloud, member string) string {
+	return fmt.Sprintf("%s/%s/members/%s", pathCloud, cloud, member)
+}
+
+func saveTestCloud(t *testing.T, s logical.Storage) {
+	t.Helper()
+
+	cloud := &OsCloud{
+		Name:           testCloudName,
+		AuthURL:        thClient.ServiceClient().Endpoint + "v3",
+		UserDomainName: testUserDomainName,
+		Username:       testUsername,
+		Password:       testPassword1,
+	}
+	require.NoError(t, cloud.save(context.Background(), s))
+}
+
+func TestCloudMember_lifecycle(t *testing.T) {
+	userID, _ := uuid.GenerateUUID()
+	fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{TokenPost: true, TokenGet: true, PasswordChange: true})
+
+	b, s := testBackend(t)
+	saveTestCloud(t, s)
+
+	t.Run("create-missing-cloud", func(t *testing.T) {
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.CreateOperation,
+			Path:      memberPath("missing", testMemberName),
+			Data: map[string]interface{}{
+				"username": "second-admin",
+				"password": testPassword2,
+			},
+		})
+		require.NoError(t, err)
+		assert.True(t, res.IsError())
+	})
+
+	t.Run("create", func(t *testing.T) {
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.CreateOperation,
+			Path:      memberPath(testCloudName, testMemberName),
+			Data: map[string]interface{}{
+				"username":          "second-admin",
+				"password":          testPassword2,
+				"verify_connection": false,
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, res.IsError(), "create failed: %s", res.Error())
+
+		member, err := getRootMember(context.Background(), s, testCloudName, testMemberName)
+		require.NoError(t, err)
+		require.NotNil(t, member)
+		assert.Equal(t, "second-admin", member.Username)
+		assert.NotEqual(t, testPassword2, member.Password, "password is not rotated")
+
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.Equal(t, testPassword1, cloudConfig.Password)
+
+		status, err := getRotationStatus(context.Background(), s, testCloudName+"/"+testMemberName)
+		require.NoError(t, err)
+		assert.Len(t, status.History, 1)
+	})
+
+	t.Run("read", func(t *testing.T) {
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.ReadOperation,
+			Path:      memberPath(testCloudName, testMemberName),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "second-admin", res.Data["username"])
+		assert.NotContains(t, res.Data, "password")
+	})
+
+	t.Run("list", func(t *testing.T) {
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.ListOperation,
+			Path:      fmt.Sprintf("%s/%s/members/", pathCloud, testCloudName),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{testMemberName}, res.Data["keys"])
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		before, err := getRootMember(context.Background(), s, testCloudName, testMemberName)
+		require.NoError(t, err)
+
+		_, err = b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.UpdateOperation,
+			Path:      "rotate-root/" + testCloudName,
+			Data:      map[string]interface{}{"member": testMemberName},
+		})
+		require.NoError(t, err)
+
+		after, err := getRootMember(context.Background(), s, testCloudName, testMemberName)
+		require.NoError(t, err)
+		assert.NotEqual(t, before.Password, after.Password)
+
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.Equal(t, testPassword1, cloudConfig.Password)
+	})
+
+	t.Run("delete-cloud", func(t *testing.T) {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.DeleteOperation,
+			Path:      pathCloudKey(testCloudName),
+		})
+		require.NoError(t, err)
+
+		members, err := listRootMembers(context.Background(), s, testCloudName)
+		require.NoError(t, err)
+		assert.Empty(t, members)
+	})
+}
+
+func TestCloudMember_verifyConnection(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+
+	create := func(t *testing.T, cloud, username, password string) *logical.Response {
+		t.Helper()
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.CreateOperation,
+			Path:      memberPath(cloud, testMemberName),
+			Data: map[string]interface{}{
+				"username":         username,
+				"password":         password,
+				"rotate_on_create": false,
+			},
+		})
+		require.NoError(t, err)
+		return res
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		keystone.AddAdmin(fixtures.KeystoneDefaultDomainID, "second-admin", testPassword2)
+		defer func() {
+			require.NoError(t, b.deleteRootMember(context.Background(), s, testCloudName, testMemberName))
+		}()
+
+		res := create(t, strings.ToUpper(testCloudName), "second-admin", testPassword2)
+		require.False(t, res.IsError(), "create failed: %s", res.Error())
+		verification := res.Data["verification"].(map[string]interface{})
+		assert.Empty(t, verification["missing"])
+
+		member, err := getRootMember(context.Background(), s, testCloudName, testMemberName)
+		require.NoError(t, err)
+		require.NotNil(t, member)
+	})
+
+	t.Run("wrong-password", func(t *testing.T) {
+		keystone.AddAdmin(fixtures.KeystoneDefaultDomainID, "third-admin", testPassword2)
+
+		res := create(t, testCloudName, "third-admin", "wrong-password")
+		require.True(t, res.IsError())
+
+		member, err := getRootMember(context.Background(), s, testCloudName, testMemberName)
+		require.NoError(t, err)
+		assert.Nil(t, member)
+	})
+
+	t.Run("missing-permissions", func(t *testing.T) {
+		user := keystone.AddUser(fixtures.KeystoneDefaultDomainID, "not-admin", testPassword2)
+		reader := keystone.AddRole("reader")
+		keystone.Assign(fixtures.RoleAssignment{RoleID: reader.ID, UserID: user.ID, DomainID: fixtures.KeystoneDefaultDomainID})
+
+		res := create(t, testCloudName, "not-admin", testPassword2)
+		assert.Equal(t, http.StatusBadRequest, res.Data[logical.HTTPStatusCode])
+
+		var body struct {
+			Data struct {
+				Verification struct {
+					Missing []string `json:"missing"`
+				} `json:"verification"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(res.Data[logical.HTTPRawBody].(string)), &body))
+		assert.NotEmpty(t, body.Data.Verification.Missing)
+
+		member, err := getRootMember(context.Background(), s, testCloudName, testMemberName)
+		require.NoError(t, err)
+		assert.Nil(t, member)
+	})
+}
+
+func TestGetPoolClient(t *testing.T) {
+	userID, _ := uuid.GenerateUUID()
+	fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{TokenPost: true, TokenGet: true})
+
+	b, s := testBackend(t)
+	saveTestCloud(t, s)
+	member := &rootMember{Name: testMemberName, Username: "second-admin", Password: testPassword2}
+	require.NoError(t, member.save(context.Background(), s, testCloudName))
+
+	sCloud := b.getSharedCloud(testCloudName)
+
+	t.Run("spread", func(t *testing.T) {
+		first, err := sCloud.getPoolClient(context.Background(), s)
+		require.NoError(t, err)
+		second, err := sCloud.getPoolClient(context.Background(), s)
+		require.NoError(t, err)
+		assert.NotSame(t, rootTransport(first), rootTransport(second))
+	})
+
+	t.Run("skip-rotating", func(t *testing.T) {
+		atomic.StoreInt32(&sCloud.rotating, 1)
+		defer atomic.StoreInt32(&sCloud.rotating, 0)
+
+		for i := 0; i < 3; i++ {
+			client, err := sCloud.getPoolClient(context.Background(), s)
+			require.NoError(t, err)
+			assert.Same(t, rootTransport(sCloud.rootClientFor(testMemberName).client), rootTransport(client))
+		}
+	})
+
+	t.Run("member-config", func(t *testing.T) {
+		atomic.StoreInt32(&sCloud.rotating, 1)
+		defer atomic.StoreInt32(&sCloud.rotating, 0)
+
+		client, cloudConfig, err := sCloud.getPoolMember(context.Background(), s)
+		require.NoError(t, err)
+		assert.Same(t, rootTransport(sCloud.rootClientFor(testMemberName).client), rootTransport(client))
+		assert.Equal(t, testMemberName, cloudConfig.member)
+		assert.Equal(t, "second-admin", cloudConfig.Username)
+		assert.Equal(t, testPassword2, cloudConfig.Password)
+	})
+
+	t.Run("skip-unhealthy", func(t *testing.T) {
+		sCloud.rootClientFor(testMemberName).markUnhealthy()
+		defer atomic.StoreInt64(&sCloud.rootClientFor(testMemberName).unhealthyUntil, 0)
+
+		for i := 0; i < 3; i++ {
+			client, err := sCloud.getPoolClient(context.Background(), s)
+			require.NoError(t, err)
+			assert.Same(t, rootTransport(sCloud.client), rootTransport(client))
+		}
+	})
+
+	t.Run("none-available", func(t *testing.T) {
+		atomic.StoreInt32(&sCloud.rotating, 1)
+		defer atomic.StoreInt32(&sCloud.rotating, 0)
+		sCloud.rootClientFor(testMemberName).markUnhealthy()
+		defer atomic.StoreInt64(&sCloud.rootClientFor(testMemberName).unhealthyUntil, 0)
+
+		_, err := sCloud.getPoolClient(context.Background(), s)
+		require.Error(t, err)
+	})
+}
+
+func TestGetPoolMember_unhealthy(t *testing.T) {
+	cases := map[string]struct {
+		err       error
+		unhealthy bool
+	}{
+		"unauthorized": {
+			err:       &common.KeystoneError{Kind: common.KindUnauthorized, StatusCode: http.StatusUnauthorized},
+			unhealthy: true,
+		},
+		"unavailable": {
+			err:       common.KeystoneErrorf(&net.OpError{Op: "dial", Err: errors.New("connection refused")}, "error creating provider client"),
+			unhealthy: true,
+		},
+		"breaker-open": {
+			err: (&circuitBreaker{state: breakerOpen, openedAt: time.Now(), cooldown: time.Minute}).check(),
+		},
+		"internal": {
+			err: errors.New("error creating HTTP client"),
+		},
+		"canceled": {
+			err: context.Canceled,
+		},
+	}
+
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			b, s := testBackend(t)
+			saveTestCloud(t, s)
+			member := &rootMember{Name: testMemberName, Username: "second-admin", Password: testPassword2}
+			require.NoError(t, member.save(context.Background(), s, testCloudName))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			sCloud := b.getSharedCloud(testCloudName)
+			sCloud.newClient = func(_ context.Context, cloud *OsCloud) (IdentityClient, time.Time, error) {
+				if data.err == context.Canceled {
+					cancel()
+				}
+				return nil, time.Time{}, data.err
+			}
+			atomic.StoreInt32(&sCloud.rotating, 1)
+
+			_, _, err := sCloud.getPoolMember(ctx, s)
+			require.Error(t, err)
+			assert.Equal(t, !data.unhealthy, sCloud.rootClientFor(testMemberName).available())
+		})
+	}
+}
+
+// rootTransport returns HTTP transport of the authenticated root identity, which is shared
+// by all clients of the identity bound to request contexts
+func rootTransport(client IdentityClient) http.RoundTripper {
+	return client.(*gophercloudIdentityClient).client.HTTPClient.Transport
+}
@@ -2,8 +2,15 @@ package openstack
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+
 	"github.com/gophercloud/gophercloud/acceptance/tools"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	thClient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/fixtures"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"strings"
@@ -31,6 +38,10 @@ func TestLifecyle(t *testing.T) {
 			Storage:   storage,
 			Operation: logical.CreateOperation,
 			Path:      pathCloudKey(testCloudName),
+			Data: map[string]interface{}{
+				"verify_connection": false,
+				"rotate_on_create":  false,
+			},
 		})
 		require.NoError(t, err)
 		assert.Empty(t, res)
@@ -51,6 +62,8 @@ func TestLifecyle(t *testing.T) {
 				"password":          testPassword1,
 				"username_template": testTemplate1,
 				"password_policy":   testPolicy1,
+				"verify_connection": false,
+				"rotate_on_create":  false,
 			},
 		})
 		require.NoError(t, err)
@@ -97,6 +110,8 @@ func TestLifecyle(t *testing.T) {
 				"password":          testPassword2,
 				"username_template": testTemplate2,
 				"password_policy":   testPolicy2,
+				"verify_connection": false,
+				"rotate_on_create":  false,
 			},
 		})
 		require.NoError(t, err)
@@ -198,6 +213,101 @@ func TestLifecyle(t *testing.T) {
 	})
 }
 
+func TestCloudDelete_dependents(t *testing.T) {
+	setup := func(t *testing.T) (*backend, logical.Storage, string, string) {
+		userID, _ := uuid.GenerateUUID()
+		fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{
+			TokenPost:  true,
+			TokenGet:   true,
+			UserDelete: true,
+		})
+
+		b, s := testBackend(t)
+
+		cloud := &OsCloud{
+			Name:           testCloudName,
+			AuthURL:        thClient.ServiceClient().Endpoint + "v3",
+			UserDomainName: testUserDomainName,
+			Username:       testUsername,
+			Password:       testPassword1,
+		}
+		require.NoError(t, cloud.save(context.Background(), s))
+
+		roleName := createSaveRandomRole(t, s, false, "", "password")
+		staticRoleName := createSaveRandomStaticRole(t, s, "", "password", testPassword1, userID)
+
+		require.NoError(t, saveLease(context.Background(), s, &leaseEntry{
+			ID:         userID,
+			Cloud:      testCloudName,
+			Role:       roleName,
+			SecretType: backendSecretTypeUser,
+			UserID:     userID,
+		}))
+		return b, s, roleName, staticRoleName
+	}
+
+	deleteCloud := func(t *testing.T, b *backend, s logical.Storage, data map[string]interface{}) *logical.Response {
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.DeleteOperation,
+			Path:      pathCloudKey(testCloudName),
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return res
+	}
+
+	t.Run("refused", func(t *testing.T) {
+		b, s, roleName, staticRoleName := setup(t)
+
+		res := deleteCloud(t, b, s, nil)
+		require.True(t, res.IsError())
+		assert.Contains(t, res.Error().Error(), roleName)
+		assert.Contains(t, res.Error().Error(), staticRoleName)
+
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.NotNil(t, cloudConfig)
+	})
+
+	t.Run("force", func(t *testing.T) {
+		b, s, roleName, _ := setup(t)
+
+		res := deleteCloud(t, b, s, map[string]interface{}{"force": true})
+		require.False(t, res.IsError())
+
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.Nil(t, cloudConfig)
+
+		role, err := getRoleByName(context.Background(), roleName, s)
+		require.NoError(t, err)
+		assert.NotNil(t, role)
+	})
+
+	t.Run("cascade", func(t *testing.T) {
+		b, s, roleName, staticRoleName := setup(t)
+
+		res := deleteCloud(t, b, s, map[string]interface{}{"cascade": true})
+		require.False(t, res.IsError(), "delete failed: %s", res.Error())
+		assert.Equal(t, []string{roleName}, res.Data["deleted_roles"])
+		assert.Equal(t, []string{staticRoleName}, res.Data["deleted_static_roles"])
+		assert.Equal(t, 1, res.Data["revoked_leases"])
+
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.Nil(t, cloudConfig)
+
+		role, err := getRoleByName(context.Background(), roleName, s)
+		require.NoError(t, err)
+		assert.Nil(t, role)
+
+		leases, err := listLeases(context.Background(), s, testCloudName)
+		require.NoError(t, err)
+		assert.Empty(t, leases)
+	})
+}
+
 func TestConfig(t *testing.T) {
 	b, s := testBackend(t)
 
@@ -217,12 +327,28 @@ func TestConfig(t *testing.T) {
 				"username_template": "user-{{ .RoleName }}-{{ random 4 }}",
 			},
 			expected: map[string]interface{}{
-				"auth_url":          "https://test-001.com/v3",
-				"username":          "test-username-1",
-				"user_domain_name":  "testUserDomainName",
-				"username_template": "user-{{ .RoleName }}-{{ random 4 }}",
-				"root_password_ttl": 5184000,
-				"password_policy":   "",
+				"auth_url":                    "https://test-001.com/v3",
+				"auth_type":                   "password",
+				"username":                    "test-username-1",
+				"user_domain_name":            "testUserDomainName",
+				"application_credential_id":   "",
+				"application_credential_name": "",
+				"project_id":                  "",
+				"project_name":                "",
+				"project_domain_name":         "",
+				"ca_cert":                     "",
+				"client_cert":                 "",
+				"insecure":                    false,
+				"rotation_schedule":           "",
+				"rotation_window":             0,
+				"revoke_root_tokens":          false,
+				"retry_max_attempts":          3,
+				"retry_backoff":               1,
+				"retry_max_backoff":           30,
+				"retry_jitter":                true,
+				"username_template":           "user-{{ .RoleName }}-{{ random 4 }}",
+				"root_password_ttl":           5184000,
+				"password_policy":             "",
 			},
 		},
 		{
@@ -235,12 +361,163 @@ func TestConfig(t *testing.T) {
 				"root_password_ttl": "1m",
 			},
 			expected: map[string]interface{}{
+				"auth_url":                    "https://test-001.com/v3",
+				"auth_type":                   "password",
+				"username":                    "test-username-2",
+				"user_domain_name":            "testUserDomainName",
+				"application_credential_id":   "",
+				"application_credential_name": "",
+				"project_id":                  "",
+				"project_name":                "",
+				"project_domain_name":         "",
+				"ca_cert":                     "",
+				"client_cert":                 "",
+				"insecure":                    false,
+				"rotation_schedule":           "",
+				"rotation_window":             0,
+				"revoke_root_tokens":          false,
+				"retry_max_attempts":          3,
+				"retry_backoff":               1,
+				"retry_max_backoff":           30,
+				"retry_jitter":                true,
+				"password_policy":             "",
+				"root_password_ttl":           60,
+				"username_template":           "vault{{random 8 | lowercase}}"},
+		},
+		{
+			name: "application credential auth",
+			config: map[string]interface{}{
+				"auth_url":                      "https://test-001.com/v3",
+				"auth_type":                     "application_credential",
+				"application_credential_id":     "b1d8c8f5c4e34fd8b8a7b3e5e4fbd3f7",
+				"application_credential_secret": "testAppCredSecret",
+			},
+			expected: map[string]interface{}{
+				"auth_url":                    "https://test-001.com/v3",
+				"auth_type":                   "application_credential",
+				"username":                    "",
+				"user_domain_name":            "",
+				"application_credential_id":   "b1d8c8f5c4e34fd8b8a7b3e5e4fbd3f7",
+				"application_credential_name": "",
+				"project_id":                  "",
+				"project_name":                "",
+				"project_domain_name":         "",
+				"ca_cert":                     "",
+				"client_cert":                 "",
+				"insecure":                    false,
+				"rotation_schedule":           "",
+				"rotation_window":             0,
+				"revoke_root_tokens":          false,
+				"retry_max_attempts":          3,
+				"retry_backoff":               1,
+				"retry_max_backoff":           30,
+				"retry_jitter":                true,
+				"password_policy":             "",
+				"root_password_ttl":           5184000,
+				"username_template":           "vault{{random 8 | lowercase}}"},
+		},
+		{
+			name: "project scope",
+			config: map[string]interface{}{
+				"auth_url":            "https://test-001.com/v3",
+				"username":            "test-username-3",
+				"user_domain_name":    "testUserDomainName",
+				"password":            "testUserPassword",
+				"project_name":        "testProjectName",
+				"project_domain_name": "testProjectDomainName",
+			},
+			expected: map[string]interface{}{
+				"auth_url":                    "https://test-001.com/v3",
+				"auth_type":                   "password",
+				"username":                    "test-username-3",
+				"user_domain_name":            "testUserDomainName",
+				"application_credential_id":   "",
+				"application_credential_name": "",
+				"project_id":                  "",
+				"project_name":                "testProjectName",
+				"project_domain_name":         "testProjectDomainName",
+				"ca_cert":                     "",
+				"client_cert":                 "",
+				"insecure":                    false,
+				"rotation_schedule":           "",
+				"rotation_window":             0,
+				"revoke_root_tokens":          false,
+				"retry_max_attempts":          3,
+				"retry_backoff":               1,
+				"retry_max_backoff":           30,
+				"retry_jitter":                true,
+				"password_policy":             "",
+				"root_password_ttl":           5184000,
+				"username_template":           "vault{{random 8 | lowercase}}"},
+		},
+		{
+			name: "rotation schedule",
+			config: map[string]interface{}{
 				"auth_url":          "https://test-001.com/v3",
-				"username":          "test-username-2",
+				"username":          "test-username-4",
 				"user_domain_name":  "testUserDomainName",
-				"password_policy":   "",
-				"root_password_ttl": 60,
-				"username_template": "vault{{random 8 | lowercase}}"},
+				"password":          "testUserPassword",
+				"rotation_schedule": "CRON_TZ=Europe/Berlin 0 2 * * SUN",
+				"rotation_window":   "2h",
+			},
+			expected: map[string]interface{}{
+				"auth_url":                    "https://test-001.com/v3",
+				"auth_type":                   "password",
+				"username":                    "test-username-4",
+				"user_domain_name":            "testUserDomainName",
+				"application_credential_id":   "",
+				"application_credential_name": "",
+				"project_id":                  "",
+				"project_name":                "",
+				"project_domain_name":         "",
+				"ca_cert":                     "",
+				"client_cert":                 "",
+				"insecure":                    false,
+				"rotation_schedule":           "CRON_TZ=Europe/Berlin 0 2 * * SUN",
+				"rotation_window":             7200,
+				"revoke_root_tokens":          false,
+				"retry_max_attempts":          3,
+				"retry_backoff":               1,
+				"retry_max_backoff":           30,
+				"retry_jitter":                true,
+				"password_policy":             "",
+				"root_password_ttl":           5184000,
+				"username_template":           "vault{{random 8 | lowercase}}"},
+		}, {
+			name: "retry policy",
+			config: map[string]interface{}{
+				"auth_url":           "https://test-001.com/v3",
+				"username":           "test-username-5",
+				"user_domain_name":   "testUserDomainName",
+				"password":           "testUserPassword",
+				"retry_max_attempts": 5,
+				"retry_backoff":      "2s",
+				"retry_max_backoff":  "1m",
+				"retry_jitter":       false,
+			},
+			expected: map[string]interface{}{
+				"auth_url":                    "https://test-001.com/v3",
+				"auth_type":                   "password",
+				"username":                    "test-username-5",
+				"user_domain_name":            "testUserDomainName",
+				"application_credential_id":   "",
+				"application_credential_name": "",
+				"project_id":                  "",
+				"project_name":                "",
+				"project_domain_name":         "",
+				"ca_cert":                     "",
+				"client_cert":                 "",
+				"insecure":                    false,
+				"rotation_schedule":           "",
+				"rotation_window":             0,
+				"revoke_root_tokens":          false,
+				"retry_max_attempts":          5,
+				"retry_backoff":               2,
+				"retry_max_backoff":           60,
+				"retry_jitter":                false,
+				"password_policy":             "",
+				"root_password_ttl":           5184000,
+				"username_template":           "vault{{random 8 | lowercase}}"},
 		},
 	}
 
@@ -262,15 +539,244 @@ func TestConfig(t *testing.T) {
 	}
 }
 
+func TestConfig_invalid(t *testing.T) {
+	b, s := testBackend(t)
+
+	cases := map[string]map[string]interface{}{
+		"no-secret": {
+			"auth_url":                  "https://test-001.com/v3",
+			"auth_type":                 "application_credential",
+			"application_credential_id": "b1d8c8f5c4e34fd8b8a7b3e5e4fbd3f7",
+		},
+		"no-id-or-name": {
+			"auth_url":                      "https://test-001.com/v3",
+			"auth_type":                     "application_credential",
+			"application_credential_secret": "testAppCredSecret",
+		},
+		"invalid-ca-cert": {
+			"auth_url": "https://test-001.com/v3",
+			"ca_cert":  "not a certificate",
+		},
+		"client-cert-without-key": {
+			"auth_url":    "https://test-001.com/v3",
+			"client_cert": "not a certificate",
+		},
+		"invalid-rotation-schedule": {
+			"auth_url":          "https://test-001.com/v3",
+			"rotation_schedule": "every sunday",
+		},
+		"rotation-window-without-schedule": {
+			"auth_url":        "https://test-001.com/v3",
+			"rotation_window": "2h",
+		},
+		"project-name-without-domain": {
+			"auth_url":     "https://test-001.com/v3",
+			"project_name": "testProjectName",
+		},
+		"app-cred-with-project": {
+			"auth_url":                      "https://test-001.com/v3",
+			"auth_type":                     "application_credential",
+			"application_credential_id":     "b1d8c8f5c4e34fd8b8a7b3e5e4fbd3f7",
+			"application_credential_secret": "testAppCredSecret",
+			"project_id":                    "testProjectID",
+		},
+		"name-without-user": {
+			"auth_url":                      "https://test-001.com/v3",
+			"auth_type":                     "application_credential",
+			"application_credential_name":   "vault",
+			"application_credential_secret": "testAppCredSecret",
+		},
+	}
+
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			res, err := b.HandleRequest(context.Background(), &logical.Request{
+				Storage:   s,
+				Operation: logical.CreateOperation,
+				Path:      pathCloudKey(strings.ToLower(tools.RandomString("cloud", 3))),
+				Data:      data,
+			})
+			require.NoError(t, err)
+			require.True(t, res.IsError())
+		})
+	}
+}
+
+func TestConfig_rotateOnCreate(t *testing.T) {
+	create := func(t *testing.T, b *backend, s logical.Storage, data map[string]interface{}) *logical.Response {
+		data["auth_url"] = thClient.ServiceClient().Endpoint + "v3"
+		data["username"] = testUsername
+		data["user_domain_name"] = testUserDomainName
+		data["password"] = testPassword1
+		data["verify_connection"] = false
+
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.CreateOperation,
+			Path:      pathCloudKey(testCloudName),
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return res
+	}
+
+	t.Run("rotated", func(t *testing.T) {
+		userID, _ := uuid.GenerateUUID()
+		fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{TokenPost: true, TokenGet: true, PasswordChange: true})
+
+		b, s := testBackend(t)
+		res := create(t, b, s, map[string]interface{}{})
+		require.False(t, res.IsError(), "create failed: %s", res.Error())
+
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.NotEqual(t, testPassword1, cloudConfig.Password)
+
+		status, err := getRotationStatus(context.Background(), s, testCloudName)
+		require.NoError(t, err)
+		require.Len(t, status.History, 1)
+		assert.Equal(t, rotationTriggerCreate, status.History[0].Trigger)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		b, s := testBackend(t)
+		res := create(t, b, s, map[string]interface{}{"rotate_on_create": false})
+		require.False(t, res.IsError(), "create failed: %s", res.Error())
+
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.Equal(t, testPassword1, cloudConfig.Password)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		userID, _ := uuid.GenerateUUID()
+		fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{TokenPost: true, TokenGet: true})
+
+		b, s := testBackend(t)
+		res := create(t, b, s, map[string]interface{}{})
+		require.True(t, res.IsError())
+		assert.Contains(t, res.Error().Error(), "rotation failed")
+
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.Equal(t, testPassword1, cloudConfig.Password)
+	})
+}
+
+func TestConfig_verifyConnection(t *testing.T) {
+	mocks := fixtures.EnabledMocks{
+		TokenPost:   true,
+		TokenGet:    true,
+		UserList:    true,
+		GroupList:   true,
+		ProjectList: true,
+	}
+
+	config := func() map[string]interface{} {
+		return map[string]interface{}{
+			"auth_url":         thClient.ServiceClient().Endpoint + "v3",
+			"username":         testUsername,
+			"user_domain_name": testUserDomainName,
+			"password":         testPassword1,
+		}
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		enabled := mocks
+		enabled.RoleList = true
+		enabled.PasswordChange = true
+		userID, _ := uuid.GenerateUUID()
+		fixtures.SetupKeystoneMock(t, userID, "", enabled)
+
+		b, s := testBackend(t)
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.CreateOperation,
+			Path:      pathCloudKey(testCloudName),
+			Data:      config(),
+		})
+		require.NoError(t, err)
+		require.False(t, res.IsError(), "create failed: %s", res.Error())
+
+		verification := res.Data["verification"].(map[string]interface{})
+		assert.Equal(t, "52af04aec5f84182b06959d2775d2000", verification["domain_id"])
+		assert.Empty(t, verification["missing"])
+
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		require.NotNil(t, cloudConfig)
+	})
+
+	t.Run("missing-permissions", func(t *testing.T) {
+		userID, _ := uuid.GenerateUUID()
+		fixtures.SetupKeystoneMock(t, userID, "", mocks)
+		th.Mux.HandleFunc("/v3/roles", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+
+		b, s := testBackend(t)
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.CreateOperation,
+			Path:      pathCloudKey(testCloudName),
+			Data:      config(),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.Data[logical.HTTPStatusCode])
+
+		var body struct {
+			Data struct {
+				Error        string `json:"error"`
+				Verification struct {
+					Missing    []string        `json:"missing"`
+					Operations map[string]bool `json:"operations"`
+				} `json:"verification"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(res.Data[logical.HTTPRawBody].(string)), &body))
+		assert.Contains(t, body.Data.Error, "identity:list_roles")
+		assert.Equal(t, []string{"identity:list_roles"}, body.Data.Verification.Missing)
+		assert.False(t, body.Data.Verification.Operations["identity:list_roles"])
+		assert.True(t, body.Data.Verification.Operations["identity:list_users"])
+
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.Nil(t, cloudConfig)
+	})
+
+	t.Run("auth-failure", func(t *testing.T) {
+		userID, _ := uuid.GenerateUUID()
+		fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{})
+
+		b, s := testBackend(t)
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.CreateOperation,
+			Path:      pathCloudKey(testCloudName),
+			Data:      config(),
+		})
+		require.NoError(t, err)
+		require.True(t, res.IsError())
+	})
+}
+
 func testConfigCreateUpdate(t *testing.T, b logical.Backend, s logical.Storage, expected map[string]interface{}, name string) {
 	t.Helper()
-	_, err := b.HandleRequest(context.Background(), &logical.Request{
+	data := map[string]interface{}{
+		"verify_connection": false,
+		"rotate_on_create":  false,
+	}
+	for k, v := range expected {
+		data[k] = v
+	}
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
 		Storage:   s,
 		Operation: logical.CreateOperation,
 		Path:      pathCloudKey(name),
-		Data:      expected,
+		Data:      data,
 	})
 	require.NoError(t, err)
+	require.False(t, res.IsError(), "create failed: %s", res.Error())
 }
 
 func testConfigRead(t *testing.T, b logical.Backend, s logical.Storage, expected map[string]interface{}, name string) {
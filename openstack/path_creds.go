@@ -4,19 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/gophercloud/gophercloud/openstack/identity/v3/domains"
 	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
 	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/vars"
-	"net/http"
 	"time"
 
 	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/applicationcredentials"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/extensions/ec2credentials"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/extensions/trusts"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/groups"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/projects"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/roles"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/users"
-	"github.com/gophercloud/gophercloud/pagination"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -24,6 +24,11 @@ import (
 const (
 	pathCreds = "creds"
 
+	// userCleanupTimeout limits removal of temporary users and trusts whose credentials failed to be issued
+	userCleanupTimeout = 30 * time.Second
+	// ec2ServiceType is the catalog type of the service accepting EC2 credentials
+	ec2ServiceType = "s3"
+
 	credsHelpSyn  = "Manage the OpenStack credentials with roles."
 	credsHelpDesc = `
 This path allows you to create OpenStack token or temporary user using predefined roles.
@@ -35,9 +40,15 @@ type credsOpts struct {
 	Config           *OsCloud
 	PwdGenerator     *Passwords
 	UsernameTemplate string
+	// Trustee and Trustor are static roles of the trust users, Trustor is nil for trusts of the root user
+	Trustee *roleStaticEntry
+	Trustor *roleStaticEntry
 }
 
-var errRootNotToken = errors.New("can't generate non-token credentials for the root user")
+var (
+	errRootNotToken      = errors.New("can't generate non-token credentials for the root user")
+	errRootAppCredScoped = errors.New("can't set a scope for the root user authenticated with an application credential")
+)
 
 func secretToken(b *backend) *framework.Secret {
 	return &framework.Secret{
@@ -73,6 +84,23 @@ func secretUser(b *backend) *framework.Secret {
 	}
 }
 
+func secretTrust(b *backend) *framework.Secret {
+	return &framework.Secret{
+		Type: backendSecretTypeTrust,
+		Fields: map[string]*framework.FieldSchema{
+			"cloud": {
+				Type:        framework.TypeString,
+				Description: "Used cloud.",
+			},
+			"auth": {
+				Type:        framework.TypeMap,
+				Description: "Auth entry for OpenStack clouds.yaml",
+			},
+		},
+		Revoke: b.trustDelete,
+	}
+}
+
 func (b *backend) pathCreds() *framework.Path {
 	return &framework.Path{
 		Pattern: fmt.Sprintf("%s/%s", pathCreds, framework.GenericNameRegex("role")),
@@ -93,7 +121,7 @@ func (b *backend) pathCreds() *framework.Path {
 	}
 }
 
-func getRootCredentials(client *gophercloud.ServiceClient, opts *credsOpts) (*logical.Response, error) {
+func getRootCredentials(client IdentityClient, opts *credsOpts) (*logical.Response, error) {
 	if opts.Role.SecretType == SecretPassword {
 		return nil, errRootNotToken
 	}
@@ -103,10 +131,22 @@ func getRootCredentials(client *gophercloud.ServiceClient, opts *credsOpts) (*lo
 		DomainName: opts.Config.UserDomainName,
 		Scope:      getScopeFromRole(opts.Role),
 	}
+	if opts.Config.AuthType == AuthTypeApplicationCredential {
+		if tokenOpts.Scope != (tokens.Scope{}) {
+			return nil, errRootAppCredScoped
+		}
+		tokenOpts = &tokens.AuthOptions{
+			Username:                    opts.Config.Username,
+			DomainName:                  opts.Config.UserDomainName,
+			ApplicationCredentialID:     opts.Config.ApplicationCredentialID,
+			ApplicationCredentialName:   opts.Config.ApplicationCredentialName,
+			ApplicationCredentialSecret: opts.Config.ApplicationCredentialSecret,
+		}
+	}
 
 	token, err := createToken(client, tokenOpts)
 	if err != nil {
-		return nil, logical.CodedError(http.StatusConflict, common.LogHttpError(err).Error())
+		return nil, err
 	}
 
 	authResponse := &authResponseData{
@@ -122,6 +162,8 @@ func getRootCredentials(client *gophercloud.ServiceClient, opts *credsOpts) (*lo
 		),
 		"auth_type": "token",
 	}
+	setTLSData(data, opts.Config)
+
 	secret := &logical.Secret{
 		LeaseOptions: logical.LeaseOptions{
 			TTL:       time.Until(token.ExpiresAt),
@@ -131,12 +173,14 @@ func getRootCredentials(client *gophercloud.ServiceClient, opts *credsOpts) (*lo
 			"secret_type": backendSecretTypeToken,
 			"cloud":       opts.Config.Name,
 			"expires_at":  token.ExpiresAt.String(),
+			"audit_id":    token.AuditID(),
+			"member":      opts.Config.member,
 		},
 	}
 	return &logical.Response{Data: data, Secret: secret}, nil
 }
 
-func getUserCredentials(client *gophercloud.ServiceClient, opts *credsOpts) (*logical.Response, error) {
+func getUserCredentials(client IdentityClient, opts *credsOpts) (*logical.Response, error) {
 	password, err := opts.PwdGenerator.Generate(context.Background())
 	if err != nil {
 		return nil, err
@@ -165,7 +209,8 @@ func getUserCredentials(client *gophercloud.ServiceClient, opts *credsOpts) (*lo
 
 		token, err := createToken(client, tokenOpts)
 		if err != nil {
-			return nil, logical.CodedError(http.StatusConflict, common.LogHttpError(err).Error())
+			removeUser(client, user.ID)
+			return nil, err
 		}
 
 		authResponse := &authResponseData{
@@ -207,10 +252,62 @@ func getUserCredentials(client *gophercloud.ServiceClient, opts *credsOpts) (*lo
 			"user_id":     user.ID,
 			"cloud":       opts.Config.Name,
 		}
+	case SecretApplicationCredential:
+		credential, err := createApplicationCredential(client, user, password, opts.Role)
+		if err != nil {
+			removeUser(client, user.ID)
+			return nil, err
+		}
+
+		authResponse := &authResponseData{
+			AuthURL:                     opts.Config.AuthURL,
+			ApplicationCredentialID:     credential.ID,
+			ApplicationCredentialSecret: credential.Secret,
+		}
+		data = map[string]interface{}{
+			"auth": formAuthResponse(
+				opts.Role,
+				authResponse,
+			),
+			"auth_type": "v3applicationcredential",
+		}
+
+		secretInternal = map[string]interface{}{
+			"secret_type":               backendSecretTypeUser,
+			"user_id":                   user.ID,
+			"cloud":                     opts.Config.Name,
+			"application_credential_id": credential.ID,
+			"expires_at":                credential.ExpiresAt.String(),
+		}
+	case SecretEC2:
+		credential, endpoint, err := createEC2Credential(client, user)
+		if err != nil {
+			removeUser(client, user.ID)
+			return nil, err
+		}
+
+		data = map[string]interface{}{
+			"access":     credential.Access,
+			"secret":     credential.Secret,
+			"project_id": credential.TenantID,
+			"auth_url":   opts.Config.AuthURL,
+		}
+		if endpoint != "" {
+			data["endpoint"] = endpoint
+		}
+
+		secretInternal = map[string]interface{}{
+			"secret_type": backendSecretTypeUser,
+			"user_id":     user.ID,
+			"cloud":       opts.Config.Name,
+			"ec2_access":  credential.Access,
+		}
 	default:
 		return nil, fmt.Errorf("invalid secret type: %s", r)
 	}
 
+	setTLSData(data, opts.Config)
+
 	for extensionKey, extensionValue := range opts.Role.Extensions {
 		data[extensionKey] = extensionValue
 	}
@@ -227,36 +324,170 @@ func getUserCredentials(client *gophercloud.ServiceClient, opts *credsOpts) (*lo
 	}, nil
 }
 
+// getTrustCredentials delegates roles of the role to the trustee with a trust expiring together with the lease
+// and returns a trust-scoped token of the trustee
+func getTrustCredentials(client IdentityClient, opts *credsOpts) (*logical.Response, error) {
+	projectID, err := getProjectID(client, opts.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	trustorClient, trustorID, err := getTrustorClient(client, opts.Trustor)
+	if err != nil {
+		return nil, err
+	}
+
+	trustRoles := make([]trusts.Role, 0, len(opts.Role.UserRoles))
+	for _, name := range opts.Role.UserRoles {
+		trustRoles = append(trustRoles, trusts.Role{Name: name})
+	}
+	expiresAt := time.Now().Add(opts.Role.TTL * time.Second).UTC()
+
+	trust, err := trustorClient.CreateTrust(trusts.CreateOpts{
+		TrustorUserID: trustorID,
+		TrusteeUserID: opts.Trustee.UserID,
+		ProjectID:     projectID,
+		Roles:         trustRoles,
+		ExpiresAt:     &expiresAt,
+	})
+	if err != nil {
+		return nil, common.KeystoneErrorf(err, "error creating a trust")
+	}
+
+	token, err := client.CreateTrustToken(&tokens.AuthOptions{
+		UserID:   opts.Trustee.UserID,
+		Password: opts.Trustee.Secret,
+	}, trust.ID)
+	if err != nil {
+		removeTrust(client, trust.ID)
+		return nil, common.KeystoneErrorf(err, "error creating a trust-scoped token")
+	}
+
+	// trust-scoped tokens can't be rescoped, so the scope of the role is not returned
+	data := map[string]interface{}{
+		"auth": map[string]interface{}{
+			"auth_url": opts.Config.AuthURL,
+			"token":    token.ID,
+		},
+		"auth_type": "token",
+	}
+	setTLSData(data, opts.Config)
+
+	for extensionKey, extensionValue := range opts.Role.Extensions {
+		data[extensionKey] = extensionValue
+	}
+
+	return &logical.Response{
+		Data: data,
+		Secret: &logical.Secret{
+			LeaseOptions: logical.LeaseOptions{
+				TTL:       opts.Role.TTL * time.Second,
+				IssueTime: time.Now(),
+			},
+			InternalData: map[string]interface{}{
+				"secret_type": backendSecretTypeTrust,
+				"trust_id":    trust.ID,
+				"cloud":       opts.Config.Name,
+				"expires_at":  token.ExpiresAt.String(),
+			},
+		},
+	}, nil
+}
+
+// getTrustorClient returns the client acting on behalf of the trustor together with the trustor user ID.
+// The root user is the trustor if no trustor static role is configured.
+func getTrustorClient(client IdentityClient, trustor *roleStaticEntry) (IdentityClient, string, error) {
+	if trustor == nil {
+		token, err := client.GetAuthToken()
+		if err != nil {
+			return nil, "", common.KeystoneErrorf(err, "error reading root token")
+		}
+		return client, token.User.ID, nil
+	}
+
+	token, err := createToken(client, &tokens.AuthOptions{
+		UserID:   trustor.UserID,
+		Password: trustor.Secret,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return client.WithToken(token.ID), trustor.UserID, nil
+}
+
+// getTrustStaticRoles returns static roles of the trustee and the trustor of the role
+func getTrustStaticRoles(ctx context.Context, r *logical.Request, role *roleEntry) (*roleStaticEntry, *roleStaticEntry, error) {
+	var staticRoles []*roleStaticEntry
+	for _, name := range []string{role.TrusteeStaticRole, role.TrustorStaticRole} {
+		if name == "" {
+			staticRoles = append(staticRoles, nil)
+			continue
+		}
+		staticRole, err := getStaticRoleByName(ctx, name, r)
+		if err != nil {
+			return nil, nil, err
+		}
+		if staticRole == nil || staticRole.Cloud != role.Cloud {
+			return nil, nil, common.NewError(common.KindNotFound, "static role `%s` doesn't exist in the cloud `%s`", name, role.Cloud)
+		}
+		staticRoles = append(staticRoles, staticRole)
+	}
+	return staticRoles[0], staticRoles[1], nil
+}
+
 func (b *backend) pathCredsRead(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	roleName := d.Get("role").(string)
 	role, err := getRoleByName(ctx, roleName, r.Storage)
 	if err != nil {
 		return nil, fmt.Errorf(vars.ErrRoleGetName)
 	}
+	if role == nil {
+		return nil, common.NewError(common.KindNotFound, "role `%s` doesn't exist", roleName)
+	}
 
 	sharedCloud := b.getSharedCloud(role.Cloud)
-	cloudConfig, err := sharedCloud.getCloudConfig(ctx, r.Storage)
-	if err != nil {
-		return nil, fmt.Errorf(vars.ErrCloudConf)
-	}
 
-	client, err := sharedCloud.getClient(ctx, r.Storage)
+	// root credentials and the trustor have to belong to the identity the client authenticates as
+	ctx, requestIDs := withKeystoneRequestIDs(ctx)
+	client, cloudConfig, err := sharedCloud.getPoolMember(ctx, r.Storage)
 	if err != nil {
-		return nil, logical.CodedError(http.StatusConflict, common.LogHttpError(err).Error())
+		return nil, common.KeystoneErrorf(err, "error getting root client")
 	}
 
 	opts := &credsOpts{
 		Role:             role,
 		Config:           cloudConfig,
-		PwdGenerator:     sharedCloud.passwords,
+		PwdGenerator:     sharedCloud.passwordsFor(cloudConfig),
 		UsernameTemplate: cloudConfig.UsernameTemplate,
 	}
 
-	if role.Root {
-		return getRootCredentials(client, opts)
+	var resp *logical.Response
+	switch {
+	case role.Root:
+		resp, err = getRootCredentials(client, opts)
+	case role.SecretType == SecretTrust:
+		opts.Trustee, opts.Trustor, err = getTrustStaticRoles(ctx, r, role)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = getTrustCredentials(client, opts)
+	default:
+		resp, err = getUserCredentials(client, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.Secret != nil {
+		resp.Secret.InternalData["keystone_request_ids"] = requestIDs.list()
+	}
+
+	if lease := leaseFromResponse(role, resp); lease != nil {
+		if err := saveLease(ctx, r.Storage, lease); err != nil {
+			return nil, fmt.Errorf("error saving lease: %w", err)
+		}
 	}
 
-	return getUserCredentials(client, opts)
+	return resp, nil
 }
 
 func (b *backend) tokenRevoke(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
@@ -274,16 +505,29 @@ func (b *backend) tokenRevoke(ctx context.Context, r *logical.Request, d *framew
 	}
 
 	cloudName := cloudNameRaw.(string)
+	auditID, _ := r.Secret.InternalData["audit_id"].(string)
 
 	sharedCloud := b.getSharedCloud(cloudName)
-	client, err := sharedCloud.getClient(ctx, r.Storage)
+	if removed, err := b.cloudRemoved(ctx, r.Storage, sharedCloud, auditID); err != nil || removed {
+		return &logical.Response{}, err
+	}
+
+	client, err := sharedCloud.getPoolClient(ctx, r.Storage)
 	if err != nil {
-		return nil, logical.CodedError(http.StatusConflict, common.LogHttpError(err).Error())
+		return nil, common.KeystoneErrorf(err, "error getting root client")
 	}
 
-	err = tokens.Revoke(client, token).Err
+	err = client.RevokeToken(token)
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		// the token is expired or has been revoked after root rotation
+		err = nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("unable to revoke token: %w", err)
+		return nil, common.KeystoneErrorf(err, "unable to revoke token")
+	}
+
+	if err := deleteLease(ctx, r.Storage, cloudName, auditID); err != nil {
+		return nil, err
 	}
 
 	return &logical.Response{}, nil
@@ -305,43 +549,97 @@ func (b *backend) userDelete(ctx context.Context, r *logical.Request, _ *framewo
 	cloudName := cloudNameRaw.(string)
 
 	sharedCloud := b.getSharedCloud(cloudName)
-	client, err := sharedCloud.getClient(ctx, r.Storage)
+	if removed, err := b.cloudRemoved(ctx, r.Storage, sharedCloud, userID); err != nil || removed {
+		return &logical.Response{}, err
+	}
+
+	client, err := sharedCloud.getPoolClient(ctx, r.Storage)
+	if err != nil {
+		return nil, common.KeystoneErrorf(err, "error getting root client")
+	}
+
+	ec2Access, _ := r.Secret.InternalData["ec2_access"].(string)
+	err = deleteUser(client, userID, ec2Access)
+	if err != nil {
+		return nil, common.KeystoneErrorf(err, "unable to delete user")
+	}
+
+	if err := deleteLease(ctx, r.Storage, cloudName, userID); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{}, nil
+}
+
+func (b *backend) trustDelete(ctx context.Context, r *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	trustID, ok := r.Secret.InternalData["trust_id"].(string)
+	if !ok {
+		return nil, errors.New("internal data 'trust_id' not found")
+	}
+
+	cloudName, ok := r.Secret.InternalData["cloud"].(string)
+	if !ok {
+		return nil, errors.New("internal data 'cloud' not found")
+	}
+
+	sharedCloud := b.getSharedCloud(cloudName)
+	if removed, err := b.cloudRemoved(ctx, r.Storage, sharedCloud, trustID); err != nil || removed {
+		return &logical.Response{}, err
+	}
+
+	client, err := sharedCloud.getPoolClient(ctx, r.Storage)
 	if err != nil {
-		return nil, logical.CodedError(http.StatusConflict, common.LogHttpError(err).Error())
+		return nil, common.KeystoneErrorf(err, "error getting root client")
 	}
 
-	err = users.Delete(client, userID).ExtractErr()
+	err = client.DeleteTrust(trustID)
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		// the trust is expired
+		err = nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("unable to delete user: %w", err)
+		return nil, common.KeystoneErrorf(err, "unable to delete trust")
+	}
+
+	if err := deleteLease(ctx, r.Storage, cloudName, trustID); err != nil {
+		return nil, err
 	}
 
 	return &logical.Response{}, nil
 }
 
-func createUser(client *gophercloud.ServiceClient, username, password string, role *roleEntry) (*users.User, error) {
+// cloudRemoved checks if the cloud of the lease doesn't exist anymore and its credentials are already revoked.
+// Credentials of the lease are revoked together with its record during cascade removal of the cloud, while
+// credentials of the lease whose record is left can't be revoked until the cloud is configured again.
+func (b *backend) cloudRemoved(ctx context.Context, s logical.Storage, sCloud *sharedCloud, leaseID string) (bool, error) {
+	cloudConfig, err := sCloud.getCloudConfig(ctx, s)
+	if err != nil {
+		return false, fmt.Errorf(vars.ErrCloudConf)
+	}
+	if cloudConfig != nil {
+		return false, nil
+	}
+	lease, err := getLease(ctx, s, sCloud.name, leaseID)
+	if err != nil {
+		return false, fmt.Errorf("error reading lease: %w", err)
+	}
+	if lease != nil {
+		return false, fmt.Errorf("cloud `%s` doesn't exist, OpenStack credentials of the lease can't be revoked", sCloud.name)
+	}
+	b.Logger().Warn("cloud doesn't exist, credentials are already revoked", "cloud", sCloud.name)
+	return true, nil
+}
+
+func createUser(client IdentityClient, username, password string, role *roleEntry) (*users.User, error) {
 	userDomainID, err := getUserDomain(client, role)
 	if err != nil {
 		return nil, err
 	}
 	// TODO: implement situation where userDomainId != currentDomainID
 
-	projectID := role.ProjectID
-	if projectID == "" && role.ProjectName != "" {
-		err := projects.List(client, projects.ListOpts{Name: role.ProjectName}).EachPage(func(page pagination.Page) (bool, error) {
-			project, err := projects.ExtractProjects(page)
-			if err != nil {
-				return false, err
-			}
-			if len(project) > 0 {
-				projectID = project[0].ID
-				return true, nil
-			}
-
-			return false, fmt.Errorf("failed to find project with the name: %s", role.ProjectName)
-		})
-		if err != nil {
-			return nil, err
-		}
+	projectID, err := getProjectID(client, role)
+	if err != nil {
+		return nil, err
 	}
 
 	userCreateOpts := users.CreateOpts{
@@ -352,64 +650,245 @@ func createUser(client *gophercloud.ServiceClient, username, password string, ro
 		Password:         password,
 	}
 
-	newUser, err := users.Create(client, userCreateOpts).Extract()
+	newUser, err := client.CreateUser(userCreateOpts)
+	if err != nil {
+		return nil, common.KeystoneErrorf(err, "error creating a temporary user")
+	}
+
+	if err := setupUser(client, newUser, projectID, userDomainID, role); err != nil {
+		removeUser(client, newUser.ID)
+		return nil, err
+	}
+
+	return newUser, nil
+}
+
+// getProjectID returns ID of the project of the role, if any
+func getProjectID(client IdentityClient, role *roleEntry) (string, error) {
+	if role.ProjectID != "" || role.ProjectName == "" {
+		return role.ProjectID, nil
+	}
+	projectList, err := client.ListProjects(projects.ListOpts{Name: role.ProjectName})
 	if err != nil {
-		errorMessage := fmt.Sprintf("error creating a temporary user: %s", common.LogHttpError(err).Error())
-		return nil, logical.CodedError(http.StatusConflict, errorMessage)
+		return "", common.KeystoneErrorf(err, "error querying projects")
 	}
+	if len(projectList) == 0 {
+		return "", common.NewError(common.KindBadRequest, "failed to find project with the name: %s", role.ProjectName)
+	}
+	return projectList[0].ID, nil
+}
 
+// resolveRoleAssignment returns options assigning the role in the project or the domain of the role assignment.
+// Names of the project or the domain are resolved to IDs.
+func resolveRoleAssignment(client IdentityClient, assignment roleAssignment) (*roles.AssignOpts, error) {
+	opts := &roles.AssignOpts{
+		ProjectID: assignment.ProjectID,
+		DomainID:  assignment.DomainID,
+	}
+	switch {
+	case assignment.ProjectName != "":
+		projectList, err := client.ListProjects(projects.ListOpts{Name: assignment.ProjectName})
+		if err != nil {
+			return nil, common.KeystoneErrorf(err, "error querying projects of role assignments")
+		}
+		if len(projectList) == 0 {
+			return nil, common.NewError(common.KindBadRequest, "project `%s` doesn't exist", assignment.ProjectName)
+		}
+		opts.ProjectID = projectList[0].ID
+	case assignment.DomainName != "":
+		domainList, err := client.ListAvailableDomains()
+		if err != nil {
+			return nil, common.KeystoneErrorf(err, "error querying domains of role assignments")
+		}
+		for _, domain := range domainList {
+			if domain.Name == assignment.DomainName {
+				opts.DomainID = domain.ID
+				break
+			}
+		}
+		if opts.DomainID == "" {
+			return nil, common.NewError(common.KindBadRequest, "domain `%s` doesn't exist", assignment.DomainName)
+		}
+	}
+	return opts, nil
+}
+
+// setupUser assigns roles and groups of the role to the temporary user
+func setupUser(client IdentityClient, newUser *users.User, projectID, userDomainID string, role *roleEntry) error {
 	rolesToAdd, err := filterRoles(client, role.UserRoles)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	for _, identityRole := range rolesToAdd {
+		if role.SystemScope != "" {
+			if err := client.AssignSystemRole(identityRole.ID, newUser.ID); err != nil {
+				return common.KeystoneErrorf(err, "cannot assign a system role `%s` to a temporary user", identityRole.Name)
+			}
+			continue
+		}
 		assignOpts := roles.AssignOpts{
 			UserID:    newUser.ID,
 			ProjectID: projectID,
 		}
-		if err := roles.Assign(client, identityRole.ID, assignOpts).ExtractErr(); err != nil {
-			return nil, fmt.Errorf("cannot assign a role `%s` to a temporary user: %w", identityRole.Name, err)
+		if err := client.AssignRole(identityRole.ID, assignOpts); err != nil {
+			return common.KeystoneErrorf(err, "cannot assign a role `%s` to a temporary user", identityRole.Name)
 		}
 	}
 
+	if err := assignRoles(client, newUser.ID, role.RoleAssignments); err != nil {
+		return err
+	}
+
 	groupsToAssign, err := filterGroups(client, userDomainID, role.UserGroups)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	for _, group := range groupsToAssign {
-		if err := users.AddToGroup(client, group.ID, newUser.ID).ExtractErr(); err != nil {
-			return nil, fmt.Errorf("cannot add a temporary user to a group `%s`: %w", group.Name, err)
+		if err := client.AddUserToGroup(group.ID, newUser.ID); err != nil {
+			return common.KeystoneErrorf(err, "cannot add a temporary user to a group `%s`", group.Name)
 		}
 	}
 
-	return newUser, nil
+	return nil
 }
 
-func createToken(client *gophercloud.ServiceClient, opts tokens.AuthOptionsBuilder) (*tokens.Token, error) {
-	token, err := tokens.Create(client, opts).Extract()
+// assignRoles assigns roles of the role assignments to the user in their projects or domains,
+// inherited assignments are applied to the projects of the domain or the subtree of the project
+func assignRoles(client IdentityClient, userID string, assignments []roleAssignment) error {
+	if len(assignments) == 0 {
+		return nil
+	}
+
+	roleList, err := client.ListRoles()
 	if err != nil {
-		errorMessage := fmt.Sprintf("error creating a token: %s", common.LogHttpError(err).Error())
-		return nil, logical.CodedError(http.StatusConflict, errorMessage)
+		return common.KeystoneErrorf(err, "unable to query roles")
+	}
+	roleIDs := make(map[string]string, len(roleList))
+	for _, identityRole := range roleList {
+		roleIDs[identityRole.Name] = identityRole.ID
 	}
 
-	return token, nil
+	for _, assignment := range assignments {
+		roleID, ok := roleIDs[assignment.Role]
+		if !ok {
+			return common.NewError(common.KindBadRequest, "role %s doesn't exist", assignment.Role)
+		}
+		assignOpts, err := resolveRoleAssignment(client, assignment)
+		if err != nil {
+			return err
+		}
+		assignOpts.UserID = userID
+		if assignment.Inherited {
+			err = client.AssignInheritedRole(roleID, *assignOpts)
+		} else {
+			err = client.AssignRole(roleID, *assignOpts)
+		}
+		if err != nil {
+			return common.KeystoneErrorf(err, "cannot assign a role `%s` to a temporary user", assignment.Role)
+		}
+	}
+	return nil
 }
 
-func filterRoles(client *gophercloud.ServiceClient, roleNames []string) ([]roles.Role, error) {
-	if len(roleNames) == 0 {
-		return nil, nil
+// deleteUser deletes the temporary user together with its EC2 credential, if any
+func deleteUser(client IdentityClient, userID, ec2Access string) error {
+	if ec2Access != "" {
+		err := client.DeleteEC2Credential(userID, ec2Access)
+		if _, ok := err.(gophercloud.ErrDefault404); err != nil && !ok {
+			return common.KeystoneErrorf(err, "unable to delete EC2 credential")
+		}
 	}
+	return client.DeleteUser(userID)
+}
 
-	rolePages, err := roles.List(client, nil).AllPages()
+// removeUser deletes the temporary user whose credentials failed to be issued. It isn't bound to
+// the request context, so users aren't left behind by cancelled requests.
+func removeUser(client IdentityClient, userID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), userCleanupTimeout)
+	defer cancel()
+	_ = client.WithContext(ctx).DeleteUser(userID)
+}
+
+// removeTrust deletes the trust whose token failed to be issued, like removeUser does for temporary users
+func removeTrust(client IdentityClient, trustID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), userCleanupTimeout)
+	defer cancel()
+	_ = client.WithContext(ctx).DeleteTrust(trustID)
+}
+
+// createApplicationCredential creates application credential of the temporary user for the project of the role.
+// The credential expires together with the lease and is removed with the user on revocation.
+func createApplicationCredential(client IdentityClient, user *users.User, password string, role *roleEntry) (*applicationcredentials.ApplicationCredential, error) {
+	// application credentials are created by the user itself and bound to the project of its token
+	token, err := createToken(client, &tokens.AuthOptions{
+		UserID:   user.ID,
+		Password: password,
+		Scope:    getScopeFromRole(role),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to query roles: %w", err)
+		return nil, err
+	}
+
+	accessRules := make([]applicationcredentials.AccessRule, 0, len(role.AccessRules))
+	for _, rule := range role.AccessRules {
+		accessRules = append(accessRules, applicationcredentials.AccessRule{
+			Service: rule.Service,
+			Method:  rule.Method,
+			Path:    rule.Path,
+		})
+	}
+	expiresAt := time.Now().Add(role.TTL * time.Second).UTC()
+
+	credential, err := client.WithToken(token.ID).CreateApplicationCredential(user.ID, applicationcredentials.CreateOpts{
+		Name:         user.Name,
+		Description:  "Vault's temporary application credential",
+		Unrestricted: role.Unrestricted,
+		AccessRules:  accessRules,
+		ExpiresAt:    &expiresAt,
+	})
+	if err != nil {
+		return nil, common.KeystoneErrorf(err, "error creating an application credential")
+	}
+	if credential.ExpiresAt.IsZero() {
+		credential.ExpiresAt = expiresAt
+	}
+	return credential, nil
+}
+
+// createEC2Credential creates EC2 credential of the temporary user for its project and returns it together
+// with the public endpoint of the S3 service from the catalog, if any
+func createEC2Credential(client IdentityClient, user *users.User) (*ec2credentials.Credential, string, error) {
+	credential, err := client.CreateEC2Credential(user.ID, user.DefaultProjectID)
+	if err != nil {
+		return nil, "", common.KeystoneErrorf(err, "error creating an EC2 credential")
+	}
+
+	token, err := client.GetAuthToken()
+	if err != nil {
+		return nil, "", common.KeystoneErrorf(err, "error reading root token")
+	}
+	return credential, token.PublicEndpoint(ec2ServiceType), nil
+}
+
+// createToken issues a new token
+func createToken(client IdentityClient, opts *tokens.AuthOptions) (*IdentityToken, error) {
+	token, err := client.CreateToken(opts)
+	if err != nil {
+		return nil, common.KeystoneErrorf(err, "error creating a token")
+	}
+	return token, nil
+}
+
+func filterRoles(client IdentityClient, roleNames []string) ([]roles.Role, error) {
+	if len(roleNames) == 0 {
+		return nil, nil
 	}
 
-	roleList, err := roles.ExtractRoles(rolePages)
+	roleList, err := client.ListRoles()
 	if err != nil {
-		return nil, fmt.Errorf("unable to retrieve roles: %w", err)
+		return nil, common.KeystoneErrorf(err, "unable to query roles")
 	}
 
 	var filteredRoles []roles.Role
@@ -424,21 +903,16 @@ func filterRoles(client *gophercloud.ServiceClient, roleNames []string) ([]roles
 	return filteredRoles, nil
 }
 
-func filterGroups(client *gophercloud.ServiceClient, domainID string, groupNames []string) ([]groups.Group, error) {
+func filterGroups(client IdentityClient, domainID string, groupNames []string) ([]groups.Group, error) {
 	if len(groupNames) == 0 {
 		return nil, nil
 	}
 
-	groupPages, err := groups.List(client, groups.ListOpts{
+	groupList, err := client.ListGroups(groups.ListOpts{
 		DomainID: domainID,
-	}).AllPages()
-	if err != nil {
-		return nil, err
-	}
-
-	groupList, err := groups.ExtractGroups(groupPages)
+	})
 	if err != nil {
-		return nil, err
+		return nil, common.KeystoneErrorf(err, "unable to query groups")
 	}
 
 	var filteredGroups []groups.Group
@@ -456,6 +930,10 @@ func filterGroups(client *gophercloud.ServiceClient, domainID string, groupNames
 func getScopeFromRole(role *roleEntry) tokens.Scope {
 	var scope tokens.Scope
 	switch {
+	case role.SystemScope != "":
+		scope = tokens.Scope{
+			System: true,
+		}
 	case role.ProjectID != "":
 		scope = tokens.Scope{
 			ProjectID: role.ProjectID,
@@ -493,11 +971,23 @@ type authResponseData struct {
 	Token      string
 	DomainID   string
 	DomainName string
+
+	ApplicationCredentialID     string
+	ApplicationCredentialSecret string
 }
 
 func formAuthResponse(role *roleEntry, authResponse *authResponseData) map[string]interface{} {
 	var auth map[string]interface{}
 
+	if authResponse.ApplicationCredentialID != "" {
+		// application credentials are bound to the project, so no scope can be requested
+		return map[string]interface{}{
+			"auth_url":                      authResponse.AuthURL,
+			"application_credential_id":     authResponse.ApplicationCredentialID,
+			"application_credential_secret": authResponse.ApplicationCredentialSecret,
+		}
+	}
+
 	switch {
 	case role.ProjectID != "":
 		auth = map[string]interface{}{
@@ -527,6 +1017,10 @@ func formAuthResponse(role *roleEntry, authResponse *authResponseData) map[strin
 		}
 	}
 
+	if role.SystemScope != "" {
+		auth["system_scope"] = role.SystemScope
+	}
+
 	if authResponse.Token != "" {
 		auth["token"] = authResponse.Token
 	} else {
@@ -539,7 +1033,7 @@ func formAuthResponse(role *roleEntry, authResponse *authResponseData) map[strin
 	return auth
 }
 
-func getUserDomain(client *gophercloud.ServiceClient, role *roleEntry) (string, error) {
+func getUserDomain(client IdentityClient, role *roleEntry) (string, error) {
 	var userDomainID string
 	var err error
 
@@ -551,36 +1045,39 @@ func getUserDomain(client *gophercloud.ServiceClient, role *roleEntry) (string,
 			return "", err
 		}
 	} else {
-		token := tokens.Get(client, client.Token())
-		domain, err := token.ExtractDomain()
+		userDomainID, err = getTokenDomainID(client)
 		if err != nil {
-			return userDomainID, fmt.Errorf("error extracting the domain from token: %w", err)
+			return "", err
 		}
-		userDomainID = domain.ID
 	}
 	return userDomainID, nil
 }
 
-func getDomainByName(client *gophercloud.ServiceClient, domainName string) (string, error) {
-	var userDomainID string
-	err := domains.ListAvailable(client).EachPage(func(page pagination.Page) (bool, error) {
-		availDomains, err := domains.ExtractDomains(page)
-		if err != nil {
-			return false, err
-		}
-		if len(availDomains) == 0 {
-			return false, fmt.Errorf("failed to find domain with name: %s", domainName)
-		}
-		for _, domain := range availDomains {
-			if domain.Name == domainName {
-				userDomainID = domain.ID
-				return false, nil
-			}
-		}
-		return false, fmt.Errorf("failed to find domain with the name: %s", domainName)
-	})
+// getTokenDomainID returns ID of the domain the client token is scoped to.
+// For project-scoped tokens the domain of the project is used.
+func getTokenDomainID(client IdentityClient) (string, error) {
+	token, err := client.GetAuthToken()
 	if err != nil {
-		return "", err
+		return "", common.KeystoneErrorf(err, "error extracting the domain from token")
 	}
-	return userDomainID, nil
+	if token.Domain != nil {
+		return token.Domain.ID, nil
+	}
+	if token.Project == nil {
+		return "", fmt.Errorf("token is neither domain nor project scoped")
+	}
+	return token.Project.Domain.ID, nil
+}
+
+func getDomainByName(client IdentityClient, domainName string) (string, error) {
+	availDomains, err := client.ListAvailableDomains()
+	if err != nil {
+		return "", common.KeystoneErrorf(err, "error querying domains")
+	}
+	for _, domain := range availDomains {
+		if domain.Name == domainName {
+			return domain.ID, nil
+		}
+	}
+	return "", common.NewError(common.KindBadRequest, "failed to find domain with the name: %s", domainName)
 }
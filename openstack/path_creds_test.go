@@ -3,10 +3,12 @@ package openstack
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"testing"
 	"time"
 
 	"github.com/gophercloud/gophercloud/acceptance/tools"
+	th "github.com/gophercloud/gophercloud/testhelper"
 	thClient "github.com/gophercloud/gophercloud/testhelper/client"
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -299,6 +301,113 @@ func TestCredentialsRevoke_error(t *testing.T) {
 	}
 }
 
+func TestCredentialsRevoke_lease(t *testing.T) {
+	cases := map[string]bool{
+		"root-token": true,
+		"user-token": false,
+	}
+
+	for name, root := range cases {
+		root := root
+		t.Run(name, func(t *testing.T) {
+			userID, _ := uuid.GenerateUUID()
+			projectName := tools.RandomString("p", 5)
+			fixtures.SetupKeystoneMock(t, userID, projectName, fixtures.EnabledMocks{
+				TokenPost:   true,
+				TokenGet:    true,
+				TokenDelete: true,
+				ProjectList: true,
+				UserPost:    true,
+				UserDelete:  true,
+			})
+
+			b, s := testBackend(t)
+
+			cloudEntry, err := logical.StorageEntryJSON(storageCloudKey(testCloudName), &OsCloud{
+				Name:             testCloudName,
+				AuthURL:          thClient.ServiceClient().Endpoint + "v3",
+				UserDomainName:   testUserDomainName,
+				Username:         testUsername,
+				Password:         testPassword1,
+				UsernameTemplate: DefaultUsernameTemplate,
+			})
+			require.NoError(t, err)
+			require.NoError(t, s.Put(context.Background(), cloudEntry))
+
+			roleName := createSaveRandomRole(t, s, root, projectName, "token")
+
+			res, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      credsPath(roleName),
+				Storage:   s,
+			})
+			require.NoError(t, err)
+			require.False(t, res.IsError(), "read failed: %s", res.Error())
+
+			leases, err := listLeases(context.Background(), s, testCloudName)
+			require.NoError(t, err)
+			require.Len(t, leases, 1)
+			assert.Equal(t, roleName, leases[0].Role)
+
+			_, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.RevokeOperation,
+				Path:      credsPath(roleName),
+				Secret:    res.Secret,
+				Data:      res.Data,
+				Storage:   s,
+			})
+			require.NoError(t, err)
+
+			leases, err = listLeases(context.Background(), s, testCloudName)
+			require.NoError(t, err)
+			assert.Empty(t, leases)
+		})
+	}
+
+	t.Run("removed-cloud", func(t *testing.T) {
+		b, s := testBackend(t)
+
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Path:      credsPath(randomRoleName()),
+			Secret: &logical.Secret{
+				InternalData: map[string]interface{}{
+					"secret_type": backendSecretTypeUser,
+					"user_id":     tools.RandomString("u", 5),
+					"cloud":       testCloudName,
+				},
+			},
+			Storage: s,
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestGetTokenDomainID(t *testing.T) {
+	cases := map[string]string{
+		"domain-scoped":  `{"token": {"domain": {"id": "domain-id", "name": "domain"}}}`,
+		"project-scoped": `{"token": {"project": {"id": "project-id", "name": "project", "domain": {"id": "domain-id", "name": "domain"}}}}`,
+	}
+
+	for name, body := range cases {
+		body := body
+		t.Run(name, func(t *testing.T) {
+			th.SetupHTTP()
+			t.Cleanup(th.TeardownHTTP)
+
+			th.Mux.HandleFunc("/auth/tokens", func(w http.ResponseWriter, r *http.Request) {
+				th.TestMethod(t, r, "GET")
+				w.WriteHeader(http.StatusOK)
+				_, _ = fmt.Fprint(w, body)
+			})
+
+			domainID, err := getTokenDomainID(NewGophercloudIdentityClient(thClient.ServiceClient()))
+			require.NoError(t, err)
+			assert.Equal(t, "domain-id", domainID)
+		})
+	}
+}
+
 func createSaveRandomRole(t *testing.T, s logical.Storage, root bool, projectName, sType string) string {
 	roleName := randomRoleName()
 	role := map[string]interface{}{
@@ -331,3 +440,82 @@ func createSaveUserDomainIDRole(t *testing.T, s logical.Storage, root bool, proj
 
 	return roleName
 }
+
+func TestCredentialsRead_errors(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+	project := keystone.AddProject(fixtures.KeystoneDefaultDomainID, tools.RandomString("p", 5))
+
+	readCreds := func(roleName string) logical.HTTPCodedError {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      credsPath(roleName),
+			Storage:   s,
+		})
+		var coded logical.HTTPCodedError
+		require.ErrorAs(t, err, &coded)
+		return coded
+	}
+
+	t.Run("role-not-found", func(t *testing.T) {
+		err := readCreds(randomRoleName())
+		assert.Equal(t, http.StatusNotFound, err.Code())
+	})
+
+	roleName := randomRoleName()
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      rolePath(roleName),
+		Data: map[string]interface{}{
+			"cloud":       testCloudName,
+			"project_id":  project.ID,
+			"secret_type": "password",
+		},
+		Storage: s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	t.Run("keystone-forbidden", func(t *testing.T) {
+		keystone.InjectFault(fixtures.Fault{
+			Method:     http.MethodPost,
+			PathPrefix: "/v3/users",
+			StatusCode: http.StatusForbidden,
+			Times:      1,
+		})
+
+		err := readCreds(roleName)
+		assert.Equal(t, http.StatusBadGateway, err.Code())
+		assert.Contains(t, err.Error(), "error creating a temporary user: Keystone responded with 403 Forbidden")
+		assert.Regexp(t, `\(request ID: req-[\w-]+\)`, err.Error())
+	})
+
+	t.Run("keystone-role-lookup", func(t *testing.T) {
+		keystone.AddRole("member")
+		roleName := randomRoleName()
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      rolePath(roleName),
+			Data: map[string]interface{}{
+				"cloud":       testCloudName,
+				"project_id":  project.ID,
+				"secret_type": "password",
+				"user_roles":  []string{"member"},
+			},
+			Storage: s,
+		})
+		require.NoError(t, err)
+		require.False(t, res.IsError(), res.Error())
+
+		keystone.InjectFault(fixtures.Fault{
+			Method:     http.MethodGet,
+			PathPrefix: "/v3/roles",
+			StatusCode: http.StatusForbidden,
+			Times:      1,
+		})
+
+		coded := readCreds(roleName)
+		assert.Equal(t, http.StatusBadGateway, coded.Code())
+		assert.Contains(t, coded.Error(), "unable to query roles: Keystone responded with 403 Forbidden")
+		assert.Regexp(t, `\(request ID: req-[\w-]+\)`, coded.Error())
+	})
+}
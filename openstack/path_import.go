@@ -0,0 +1,328 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/utils/openstack/clientconfig"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/vars"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	pathImportClouds = "import/clouds"
+
+	importCloudsHelpSyn  = "Import OpenStack clouds from a clouds.yaml document."
+	importCloudsHelpDesc = `
+Create or update clouds using entries of clouds.yaml and secure.yaml documents.
+Each named cloud of the document is stored as a separate cloud configuration.
+`
+)
+
+var cloudNameRegex = regexp.MustCompile(fmt.Sprintf("^%s$", framework.GenericNameWithAtRegex("name")))
+
+func (b *backend) pathImportClouds() *framework.Path {
+	return &framework.Path{
+		Pattern: pathImportClouds,
+		Fields: map[string]*framework.FieldSchema{
+			"clouds": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: "Content of clouds.yaml document.",
+			},
+			"secure": {
+				Type:        framework.TypeString,
+				Description: "Content of secure.yaml document.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"overwrite_credentials": {
+				Type:        framework.TypeBool,
+				Description: "Replace root credentials of existing clouds with the credentials of the document.",
+				Default:     false,
+			},
+			"verify_connection": {
+				Type:        framework.TypeBool,
+				Default:     true,
+				Description: "Verify that the root user of each cloud can authenticate and has required permissions before saving the cloud.",
+			},
+			"rotate_on_create": {
+				Type:        framework.TypeBool,
+				Description: "Rotate the root credentials of each cloud right after it is saved. Enabled by default for new clouds.",
+			},
+			"dry_run": {
+				Type:        framework.TypeBool,
+				Description: "Report the changes without saving any cloud.",
+				Default:     false,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathImportCloudsUpdate,
+			},
+		},
+		HelpSynopsis:    importCloudsHelpSyn,
+		HelpDescription: importCloudsHelpDesc,
+	}
+}
+
+// cloudsYAML implements clientconfig.YAMLOptsBuilder using documents provided in the request
+type cloudsYAML struct {
+	clouds map[string]clientconfig.Cloud
+	secure map[string]clientconfig.Cloud
+}
+
+func (c cloudsYAML) LoadCloudsYAML() (map[string]clientconfig.Cloud, error) {
+	return c.clouds, nil
+}
+
+func (c cloudsYAML) LoadSecureCloudsYAML() (map[string]clientconfig.Cloud, error) {
+	return c.secure, nil
+}
+
+func (c cloudsYAML) LoadPublicCloudsYAML() (map[string]clientconfig.Cloud, error) {
+	return nil, nil
+}
+
+func parseCloudsYAML(content string) (map[string]clientconfig.Cloud, error) {
+	var clouds clientconfig.Clouds
+	if err := yaml.Unmarshal([]byte(content), &clouds); err != nil {
+		return nil, err
+	}
+	return clouds.Clouds, nil
+}
+
+func (b *backend) pathImportCloudsUpdate(ctx context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	dryRun := d.Get("dry_run").(bool)
+	overwriteCredentials := d.Get("overwrite_credentials").(bool)
+	verify := d.Get("verify_connection").(bool)
+	if _, set := d.GetOk("verify_connection"); dryRun && !set {
+		// dry run doesn't contact Keystone unless the verification is requested explicitly
+		verify = false
+	}
+	rotateOnCreate, rotateSet := d.GetOk("rotate_on_create")
+
+	clouds, err := parseCloudsYAML(d.Get("clouds").(string))
+	if err != nil {
+		return logical.ErrorResponse("error parsing clouds.yaml: %s", err), nil
+	}
+	if len(clouds) == 0 {
+		return logical.ErrorResponse("no clouds found in clouds.yaml"), nil
+	}
+
+	docs := cloudsYAML{clouds: clouds}
+	if secure, ok := d.GetOk("secure"); ok {
+		docs.secure, err = parseCloudsYAML(secure.(string))
+		if err != nil {
+			return logical.ErrorResponse("error parsing secure.yaml: %s", err), nil
+		}
+	}
+
+	names := make([]string, 0, len(clouds))
+	for name := range clouds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// entries are stored under lowercase names, so names differing only by case would overwrite each other
+	lowerNames := make(map[string]string, len(names))
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		if other, ok := lowerNames[lower]; ok {
+			return logical.ErrorResponse("clouds `%s` and `%s` have the same name `%s`", other, name, lower), nil
+		}
+		lowerNames[lower] = name
+	}
+
+	created := make([]string, 0)
+	updated := make([]string, 0)
+	rejected := make(map[string]interface{})
+	resp := &logical.Response{}
+
+	for _, name := range names {
+		cloud, err := clientconfig.GetCloudFromYAML(&clientconfig.ClientOpts{
+			Cloud:    name,
+			YAMLOpts: docs,
+		})
+		if err != nil {
+			rejected[name] = err.Error()
+			continue
+		}
+
+		cloudName := strings.ToLower(name)
+		if !cloudNameRegex.MatchString(cloudName) {
+			rejected[name] = fmt.Sprintf("`%s` is not a valid cloud name", name)
+			continue
+		}
+
+		sCloud := b.getSharedCloud(cloudName)
+		cloudConfig, err := sCloud.getCloudConfig(ctx, r.Storage)
+		if err != nil {
+			return nil, fmt.Errorf(vars.ErrCloudConf)
+		}
+		exists := cloudConfig != nil
+		var stored OsCloud
+		if exists {
+			stored = *cloudConfig
+		} else {
+			cloudConfig = &OsCloud{
+				Name:             cloudName,
+				UsernameTemplate: DefaultUsernameTemplate,
+				RootPasswordTTL:  defaultRootPasswordTTL,
+				RetryMaxAttempts: defaultRetryMaxAttempts,
+				RetryBackoff:     defaultRetryBackoff,
+				RetryMaxBackoff:  defaultRetryMaxBackoff,
+				RetryJitter:      true,
+			}
+		}
+
+		warnings, err := cloudConfig.applyClientConfig(cloud)
+		if err != nil {
+			rejected[name] = err.Error()
+			continue
+		}
+		if exists && !overwriteCredentials {
+			// the stored root secret may be already rotated, so the bootstrap one of the document is not used
+			if err := cloudConfig.keepCredentials(&stored); err != nil {
+				rejected[name] = err.Error()
+				continue
+			}
+		}
+		if err := cloudConfig.validate(); err != nil {
+			rejected[name] = err.Error()
+			continue
+		}
+		if verify {
+			if _, err := sCloud.requireCloudPermissions(ctx, cloudConfig); err != nil {
+				rejected[name] = err.Error()
+				continue
+			}
+		}
+		for _, warning := range warnings {
+			resp.AddWarning(fmt.Sprintf("cloud `%s`: %s", name, warning))
+		}
+
+		if exists {
+			updated = append(updated, cloudName)
+		} else {
+			created = append(created, cloudName)
+		}
+
+		if dryRun {
+			continue
+		}
+
+		cloudConfig.RootPasswordExpirationDate = cloudConfig.nextRotation(time.Now())
+
+		if err := cloudConfig.save(ctx, r.Storage); err != nil {
+			return nil, fmt.Errorf("error saving cloud `%s`: %w", cloudName, err)
+		}
+		b.evictCloud(cloudName)
+
+		rotate := !exists
+		if rotateSet {
+			rotate = rotateOnCreate.(bool)
+		}
+		if rotate {
+			sCloud = b.getSharedCloud(cloudName)
+			if err := b.rotateRoot(ctx, r.Storage, sCloud, cloudConfig, rotationTriggerCreate, ""); err != nil {
+				resp.AddWarning(fmt.Sprintf(
+					"cloud `%s` is saved with the supplied root credentials, but their rotation failed: %s", name, err,
+				))
+			}
+		}
+	}
+
+	resp.Data = map[string]interface{}{
+		"dry_run":  dryRun,
+		"created":  created,
+		"updated":  updated,
+		"rejected": rejected,
+	}
+	return resp, nil
+}
+
+// applyClientConfig sets values of clouds.yaml entry to the cloud configuration.
+// Returned warnings describe the settings of the entry which were ignored.
+func (cloud *OsCloud) applyClientConfig(src *clientconfig.Cloud) ([]string, error) {
+	var warnings []string
+
+	auth := src.AuthInfo
+	if auth == nil {
+		return nil, fmt.Errorf("auth section is missing")
+	}
+	if auth.AuthURL == "" {
+		return nil, fmt.Errorf("auth_url is missing")
+	}
+
+	switch src.AuthType {
+	case "", clientconfig.AuthPassword, clientconfig.AuthV3Password:
+		if src.AuthType == "" && auth.ApplicationCredentialSecret != "" {
+			cloud.AuthType = AuthTypeApplicationCredential
+		} else {
+			cloud.AuthType = AuthTypePassword
+		}
+	case clientconfig.AuthV3ApplicationCredential:
+		cloud.AuthType = AuthTypeApplicationCredential
+	default:
+		return nil, fmt.Errorf("unsupported auth_type: %s", src.AuthType)
+	}
+
+	if auth.UserID != "" && auth.Username == "" {
+		return nil, fmt.Errorf("user_id is not supported, username has to be used")
+	}
+	if auth.UserDomainID != "" && auth.UserDomainName == "" {
+		return nil, fmt.Errorf("user_domain_id is not supported, user_domain_name has to be used")
+	}
+	if auth.ProjectDomainID != "" && auth.ProjectDomainName == "" {
+		return nil, fmt.Errorf("project_domain_id is not supported, project_domain_name has to be used")
+	}
+
+	userDomainName := auth.UserDomainName
+	if userDomainName == "" {
+		userDomainName = auth.DefaultDomain
+	}
+
+	cloud.AuthURL = auth.AuthURL
+	cloud.Username = auth.Username
+	cloud.UserDomainName = userDomainName
+	cloud.Password = auth.Password
+	cloud.ApplicationCredentialID = auth.ApplicationCredentialID
+	cloud.ApplicationCredentialName = auth.ApplicationCredentialName
+	cloud.ApplicationCredentialSecret = auth.ApplicationCredentialSecret
+	cloud.ProjectID = auth.ProjectID
+	cloud.ProjectName = auth.ProjectName
+	cloud.ProjectDomainName = auth.ProjectDomainName
+
+	if src.Verify != nil {
+		cloud.Insecure = !*src.Verify
+	}
+	if src.CACertFile != "" || src.ClientCertFile != "" || src.ClientKeyFile != "" {
+		warnings = append(warnings, "certificate file paths are ignored, set `ca_cert`, `client_cert` and `client_key` of the cloud instead")
+	}
+	if src.RegionName != "" || len(src.Regions) > 0 {
+		warnings = append(warnings, "region settings are ignored")
+	}
+
+	return warnings, nil
+}
+
+// keepCredentials restores root secrets of the stored cloud configuration. The secrets can only be kept
+// if the document refers to the same root user.
+func (cloud *OsCloud) keepCredentials(stored *OsCloud) error {
+	if cloud.AuthType != stored.AuthType || cloud.Username != stored.Username || cloud.UserDomainName != stored.UserDomainName {
+		return fmt.Errorf("root user differs from the existing cloud, set `overwrite_credentials` to replace its credentials")
+	}
+	cloud.Password = stored.Password
+	cloud.ApplicationCredentialID = stored.ApplicationCredentialID
+	cloud.ApplicationCredentialName = stored.ApplicationCredentialName
+	cloud.ApplicationCredentialSecret = stored.ApplicationCredentialSecret
+	return nil
+}
@@ -0,0 +1,358 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	thClient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/fixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testCloudsYAML = `
+clouds:
+  prod:
+    auth:
+      auth_url: https://prod.example.com/v3
+      username: admin
+      user_domain_name: Default
+      project_name: admin
+    verify: false
+  ci:
+    auth_type: v3applicationcredential
+    auth:
+      auth_url: https://ci.example.com/v3
+      application_credential_id: 0b0e6e1a8c2d4f57a1ff1b9b01f0f3ca
+  legacy:
+    auth_type: v2password
+    auth:
+      auth_url: https://legacy.example.com/v2.0
+      username: admin
+`
+	testSecureYAML = `
+clouds:
+  prod:
+    auth:
+      password: prod-password
+  ci:
+    auth:
+      application_credential_secret: ci-secret
+`
+)
+
+func TestImportClouds(t *testing.T) {
+	t.Run("dry-run", func(t *testing.T) {
+		b, s := testBackend(t)
+
+		existing := &OsCloud{
+			Name:                        "ci",
+			AuthURL:                     "https://old.example.com/v3",
+			AuthType:                    AuthTypeApplicationCredential,
+			ApplicationCredentialID:     "rotated-id",
+			ApplicationCredentialSecret: "rotated-secret",
+		}
+		require.NoError(t, existing.save(context.Background(), s))
+
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.UpdateOperation,
+			Path:      pathImportClouds,
+			Data: map[string]interface{}{
+				"clouds":            testCloudsYAML,
+				"secure":            testSecureYAML,
+				"dry_run":           true,
+				"verify_connection": false,
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, res.IsError(), "import failed: %s", res.Error())
+
+		assert.Equal(t, []string{"prod"}, res.Data["created"])
+		assert.Equal(t, []string{"ci"}, res.Data["updated"])
+		rejected := res.Data["rejected"].(map[string]interface{})
+		assert.Contains(t, rejected["legacy"], "unsupported auth_type")
+
+		clouds, err := s.List(context.Background(), pathCloud+"/")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ci"}, clouds)
+
+		cloudConfig, err := b.getSharedCloud("ci").getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.Equal(t, "https://old.example.com/v3", cloudConfig.AuthURL)
+	})
+
+	t.Run("import", func(t *testing.T) {
+		b, s := testBackend(t)
+
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.UpdateOperation,
+			Path:      pathImportClouds,
+			Data: map[string]interface{}{
+				"clouds":            testCloudsYAML,
+				"secure":            testSecureYAML,
+				"verify_connection": false,
+				"rotate_on_create":  false,
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, res.IsError(), "import failed: %s", res.Error())
+		assert.Equal(t, []string{"ci", "prod"}, res.Data["created"])
+
+		prod, err := b.getSharedCloud("prod").getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.Equal(t, AuthTypePassword, prod.AuthType)
+		assert.Equal(t, "https://prod.example.com/v3", prod.AuthURL)
+		assert.Equal(t, "admin", prod.Username)
+		assert.Equal(t, "prod-password", prod.Password)
+		assert.Equal(t, "Default", prod.UserDomainName)
+		assert.Equal(t, "admin", prod.ProjectName)
+		assert.True(t, prod.Insecure)
+		assert.Equal(t, DefaultUsernameTemplate, prod.UsernameTemplate)
+		assert.Equal(t, defaultRootPasswordTTL, prod.RootPasswordTTL)
+
+		ci, err := b.getSharedCloud("ci").getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.Equal(t, AuthTypeApplicationCredential, ci.AuthType)
+		assert.Equal(t, "0b0e6e1a8c2d4f57a1ff1b9b01f0f3ca", ci.ApplicationCredentialID)
+		assert.Equal(t, "ci-secret", ci.ApplicationCredentialSecret)
+
+		legacy, err := b.getSharedCloud("legacy").getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.Nil(t, legacy)
+	})
+
+	t.Run("existing-credentials", func(t *testing.T) {
+		importClouds := func(t *testing.T, b *backend, s logical.Storage, overwrite bool) *logical.Response {
+			res, err := b.HandleRequest(context.Background(), &logical.Request{
+				Storage:   s,
+				Operation: logical.UpdateOperation,
+				Path:      pathImportClouds,
+				Data: map[string]interface{}{
+					"clouds":                testCloudsYAML,
+					"secure":                testSecureYAML,
+					"overwrite_credentials": overwrite,
+					"verify_connection":     false,
+				},
+			})
+			require.NoError(t, err)
+			require.False(t, res.IsError(), "import failed: %s", res.Error())
+			return res
+		}
+
+		existing := func(t *testing.T, s logical.Storage) {
+			prod := &OsCloud{
+				Name:           "prod",
+				AuthURL:        "https://old.example.com/v3",
+				AuthType:       AuthTypePassword,
+				Username:       "admin",
+				UserDomainName: "Default",
+				Password:       "rotated-password",
+			}
+			require.NoError(t, prod.save(context.Background(), s))
+			ci := &OsCloud{
+				Name:     "ci",
+				AuthURL:  "https://old.example.com/v3",
+				AuthType: AuthTypePassword,
+				Username: "ci",
+				Password: "ci-password",
+			}
+			require.NoError(t, ci.save(context.Background(), s))
+		}
+
+		t.Run("kept", func(t *testing.T) {
+			b, s := testBackend(t)
+			existing(t, s)
+
+			res := importClouds(t, b, s, false)
+			assert.Equal(t, []string{"prod"}, res.Data["updated"])
+			rejected := res.Data["rejected"].(map[string]interface{})
+			assert.Contains(t, rejected["ci"], "overwrite_credentials")
+
+			prod, err := b.getSharedCloud("prod").getCloudConfig(context.Background(), s)
+			require.NoError(t, err)
+			assert.Equal(t, "https://prod.example.com/v3", prod.AuthURL)
+			assert.Equal(t, "rotated-password", prod.Password)
+
+			ci, err := b.getSharedCloud("ci").getCloudConfig(context.Background(), s)
+			require.NoError(t, err)
+			assert.Equal(t, AuthTypePassword, ci.AuthType)
+			assert.Equal(t, "ci-password", ci.Password)
+		})
+
+		t.Run("overwritten", func(t *testing.T) {
+			b, s := testBackend(t)
+			existing(t, s)
+
+			res := importClouds(t, b, s, true)
+			assert.Equal(t, []string{"ci", "prod"}, res.Data["updated"])
+
+			prod, err := b.getSharedCloud("prod").getCloudConfig(context.Background(), s)
+			require.NoError(t, err)
+			assert.Equal(t, "prod-password", prod.Password)
+
+			ci, err := b.getSharedCloud("ci").getCloudConfig(context.Background(), s)
+			require.NoError(t, err)
+			assert.Equal(t, AuthTypeApplicationCredential, ci.AuthType)
+			assert.Equal(t, "ci-secret", ci.ApplicationCredentialSecret)
+		})
+	})
+
+	t.Run("verify-and-rotate", func(t *testing.T) {
+		userID, _ := uuid.GenerateUUID()
+		fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{
+			TokenPost:      true,
+			TokenGet:       true,
+			UserList:       true,
+			GroupList:      true,
+			ProjectList:    true,
+			RoleList:       true,
+			PasswordChange: true,
+		})
+
+		b, s := testBackend(t)
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.UpdateOperation,
+			Path:      pathImportClouds,
+			Data: map[string]interface{}{
+				"clouds": testMockCloudsYAML(),
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, res.IsError(), "import failed: %s", res.Error())
+		assert.Equal(t, []string{testCloudName}, res.Data["created"])
+		assert.Empty(t, res.Warnings)
+
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.NotEqual(t, testPassword1, cloudConfig.Password)
+
+		status, err := getRotationStatus(context.Background(), s, testCloudName)
+		require.NoError(t, err)
+		require.Len(t, status.History, 1)
+		assert.Equal(t, rotationTriggerCreate, status.History[0].Trigger)
+	})
+
+	t.Run("verification-failed", func(t *testing.T) {
+		userID, _ := uuid.GenerateUUID()
+		fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{})
+
+		b, s := testBackend(t)
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.UpdateOperation,
+			Path:      pathImportClouds,
+			Data: map[string]interface{}{
+				"clouds": testMockCloudsYAML(),
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, res.IsError(), "import failed: %s", res.Error())
+		assert.Empty(t, res.Data["created"])
+		rejected := res.Data["rejected"].(map[string]interface{})
+		assert.Contains(t, rejected[testCloudName], "error verifying cloud connection")
+
+		clouds, err := s.List(context.Background(), pathCloud+"/")
+		require.NoError(t, err)
+		assert.Empty(t, clouds)
+	})
+
+	t.Run("dry-run-no-verification", func(t *testing.T) {
+		userID, _ := uuid.GenerateUUID()
+		fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{})
+
+		b, s := testBackend(t)
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.UpdateOperation,
+			Path:      pathImportClouds,
+			Data: map[string]interface{}{
+				"clouds":  testMockCloudsYAML(),
+				"dry_run": true,
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, res.IsError(), "import failed: %s", res.Error())
+		assert.Equal(t, []string{testCloudName}, res.Data["created"])
+		assert.Empty(t, res.Data["rejected"])
+
+		res, err = b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.UpdateOperation,
+			Path:      pathImportClouds,
+			Data: map[string]interface{}{
+				"clouds":            testMockCloudsYAML(),
+				"dry_run":           true,
+				"verify_connection": true,
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, res.IsError(), "import failed: %s", res.Error())
+		rejected := res.Data["rejected"].(map[string]interface{})
+		assert.Contains(t, rejected[testCloudName], "error verifying cloud connection")
+	})
+
+	t.Run("name-collision", func(t *testing.T) {
+		b, s := testBackend(t)
+
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.UpdateOperation,
+			Path:      pathImportClouds,
+			Data: map[string]interface{}{
+				"clouds": `
+clouds:
+  prod:
+    auth:
+      auth_url: https://prod.example.com/v3
+  Prod:
+    auth:
+      auth_url: https://other.example.com/v3
+`,
+				"verify_connection": false,
+				"rotate_on_create":  false,
+			},
+		})
+		require.NoError(t, err)
+		require.True(t, res.IsError())
+		assert.Contains(t, res.Error().Error(), "`Prod` and `prod`")
+
+		clouds, err := s.List(context.Background(), pathCloud+"/")
+		require.NoError(t, err)
+		assert.Empty(t, clouds)
+	})
+
+	t.Run("invalid-yaml", func(t *testing.T) {
+		b, s := testBackend(t)
+
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   s,
+			Operation: logical.UpdateOperation,
+			Path:      pathImportClouds,
+			Data: map[string]interface{}{
+				"clouds": "clouds: [",
+			},
+		})
+		require.NoError(t, err)
+		assert.True(t, res.IsError())
+	})
+}
+
+// testMockCloudsYAML returns clouds.yaml document with the cloud authenticated by the Keystone mock
+func testMockCloudsYAML() string {
+	return fmt.Sprintf(`
+clouds:
+  %s:
+    auth:
+      auth_url: %s
+      username: %s
+      user_domain_name: %s
+      password: %s
+`, testCloudName, thClient.ServiceClient().Endpoint+"v3", testUsername, testUserDomainName, testPassword1)
+}
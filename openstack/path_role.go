@@ -2,18 +2,18 @@ package openstack
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
 	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/vars"
-	"net/http"
+	"reflect"
 	"time"
 
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/groups"
-	"github.com/gophercloud/gophercloud/openstack/identity/v3/roles"
-	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
 )
 
 const (
@@ -88,9 +88,27 @@ func (b *backend) pathRole() *framework.Path {
 			"secret_type": {
 				Type:          framework.TypeLowerCaseString,
 				Description:   "Specifies what kind of secret will configuration contain.",
-				AllowedValues: []interface{}{"token", "password"},
+				AllowedValues: []interface{}{"token", "password", "application_credential", "ec2", "trust"},
 				Default:       SecretToken,
 			},
+			"access_rules": {
+				Type: framework.TypeSlice,
+				Description: "Specifies list of access rules of application credentials. Each rule is an object " +
+					"with `service`, `method` and `path` keys, the list can be passed as a JSON string.",
+			},
+			"unrestricted": {
+				Type:        framework.TypeBool,
+				Description: "Specifies whenever application credentials can create other application credentials and trusts.",
+				Default:     false,
+			},
+			"trustee_static_role": {
+				Type:        framework.TypeString,
+				Description: "Specifies the static role of the user the roles are delegated to with a trust.",
+			},
+			"trustor_static_role": {
+				Type:        framework.TypeString,
+				Description: "Specifies the static role of the user delegating the roles with a trust. The root user is used by default.",
+			},
 			"user_groups": {
 				Type:        framework.TypeCommaStringSlice,
 				Description: "Specifies list of existing OpenStack groups this Vault role is allowed to assume.",
@@ -99,6 +117,12 @@ func (b *backend) pathRole() *framework.Path {
 				Type:        framework.TypeCommaStringSlice,
 				Description: "Specifies list of existing OpenStack roles this Vault role is allowed to assume.",
 			},
+			"role_assignments": {
+				Type: framework.TypeSlice,
+				Description: "Specifies list of roles assigned to the dynamic user. Each assignment is an object " +
+					"with `role`, one of `project_id`, `project_name`, `domain_id` or `domain_name`, and optional " +
+					"`inherited` keys, the list can be passed as a JSON string.",
+			},
 			"project_id": {
 				Type:        framework.TypeLowerCaseString,
 				Description: "Specifies a project ID for project-scoped role.",
@@ -115,6 +139,10 @@ func (b *backend) pathRole() *framework.Path {
 				Type:        framework.TypeNameString,
 				Description: "Specifies a domain name for domain-scoped role.",
 			},
+			"system_scope": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Specifies a system scope for system-scoped role, the only supported value is `all`.",
+			},
 			"user_domain_id": {
 				Type:        framework.TypeLowerCaseString,
 				Description: "Specifies a domain ID for dynamic user creation.",
@@ -168,10 +196,31 @@ func (b *backend) roleExistenceCheck(ctx context.Context, r *logical.Request, d
 type secretType string
 
 const (
-	SecretPassword secretType = "password"
-	SecretToken    secretType = "token"
+	SecretPassword              secretType = "password"
+	SecretToken                 secretType = "token"
+	SecretApplicationCredential secretType = "application_credential"
+	SecretEC2                   secretType = "ec2"
+	SecretTrust                 secretType = "trust"
 )
 
+// accessRule limits API requests the application credential can be used for
+type accessRule struct {
+	Service string `json:"service"`
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+}
+
+// roleAssignment is a role assigned to the dynamic user in the project or the domain,
+// inherited assignments apply to the projects of the domain or to the subprojects of the project
+type roleAssignment struct {
+	Role        string `json:"role" mapstructure:"role"`
+	ProjectID   string `json:"project_id,omitempty" mapstructure:"project_id"`
+	ProjectName string `json:"project_name,omitempty" mapstructure:"project_name"`
+	DomainID    string `json:"domain_id,omitempty" mapstructure:"domain_id"`
+	DomainName  string `json:"domain_name,omitempty" mapstructure:"domain_name"`
+	Inherited   bool   `json:"inherited,omitempty" mapstructure:"inherited"`
+}
+
 type roleEntry struct {
 	Name              string            `json:"name"`
 	Cloud             string            `json:"cloud"`
@@ -180,15 +229,21 @@ type roleEntry struct {
 	SecretType        secretType        `json:"secret_type"`
 	UserGroups        []string          `json:"user_groups"`
 	UserRoles         []string          `json:"user_roles"`
+	RoleAssignments   []roleAssignment  `json:"role_assignments"`
 	ProjectID         string            `json:"project_id"`
 	ProjectName       string            `json:"project_name"`
 	DomainID          string            `json:"domain_id"`
 	DomainName        string            `json:"domain_name"`
+	SystemScope       string            `json:"system_scope"`
 	UserDomainID      string            `json:"user_domain_id"`
 	UserDomainName    string            `json:"user_domain_name"`
 	ProjectDomainID   string            `json:"project_domain_id"`
 	ProjectDomainName string            `json:"project_domain_name"`
 	Extensions        map[string]string `json:"extensions"`
+	AccessRules       []accessRule      `json:"access_rules"`
+	Unrestricted      bool              `json:"unrestricted"`
+	TrusteeStaticRole string            `json:"trustee_static_role"`
+	TrustorStaticRole string            `json:"trustor_static_role"`
 }
 
 func roleStoragePath(name string) string {
@@ -233,16 +288,175 @@ func roleToMap(src *roleEntry) map[string]interface{} {
 		"secret_type":         string(src.SecretType),
 		"user_groups":         src.UserGroups,
 		"user_roles":          src.UserRoles,
+		"role_assignments":    roleAssignmentsToList(src.RoleAssignments),
 		"project_id":          src.ProjectID,
 		"project_name":        src.ProjectName,
 		"domain_id":           src.DomainID,
 		"domain_name":         src.DomainName,
+		"system_scope":        src.SystemScope,
 		"user_domain_id":      src.UserDomainID,
 		"user_domain_name":    src.UserDomainName,
 		"project_domain_id":   src.ProjectDomainID,
 		"project_domain_name": src.ProjectDomainName,
 		"extensions":          src.Extensions,
+		"access_rules":        accessRulesToList(src.AccessRules),
+		"unrestricted":        src.Unrestricted,
+		"trustee_static_role": src.TrusteeStaticRole,
+		"trustor_static_role": src.TrustorStaticRole,
+	}
+}
+
+func accessRulesToList(rules []accessRule) []map[string]interface{} {
+	if rules == nil {
+		return nil
+	}
+	list := make([]map[string]interface{}, 0, len(rules))
+	for _, rule := range rules {
+		list = append(list, map[string]interface{}{
+			"service": rule.Service,
+			"method":  rule.Method,
+			"path":    rule.Path,
+		})
+	}
+	return list
+}
+
+// decodeListField decodes the list field given either as objects or as JSON strings into the slice `out` points to.
+// Each string may hold either a single object or a list of them. `item` names the list items in errors.
+func decodeListField(raw []interface{}, out interface{}, item string) error {
+	list := reflect.ValueOf(out).Elem()
+	elemType := list.Type().Elem()
+	for _, entry := range raw {
+		switch v := entry.(type) {
+		case string:
+			parsed := reflect.New(list.Type())
+			if err := json.Unmarshal([]byte(v), parsed.Interface()); err == nil {
+				list = reflect.AppendSlice(list, parsed.Elem())
+				continue
+			}
+			elem := reflect.New(elemType)
+			if err := json.Unmarshal([]byte(v), elem.Interface()); err != nil {
+				return fmt.Errorf("invalid %s %q: %w", item, v, err)
+			}
+			list = reflect.Append(list, elem.Elem())
+		case map[string]interface{}:
+			elem := reflect.New(elemType)
+			if err := mapstructure.Decode(v, elem.Interface()); err != nil {
+				return fmt.Errorf("invalid %s: %w", item, err)
+			}
+			list = reflect.Append(list, elem.Elem())
+		default:
+			return fmt.Errorf("invalid %s: %v", item, entry)
+		}
+	}
+	reflect.ValueOf(out).Elem().Set(list)
+	return nil
+}
+
+// parseAccessRules parses the list of access rules given either as objects or as JSON strings
+func parseAccessRules(raw []interface{}) ([]accessRule, error) {
+	rules := make([]accessRule, 0, len(raw))
+	if err := decodeListField(raw, &rules, "access rule"); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if rule.Service == "" || rule.Method == "" || rule.Path == "" {
+			return nil, fmt.Errorf("access rule requires service, method and path")
+		}
+	}
+	return rules, nil
+}
+
+func roleAssignmentsToList(assignments []roleAssignment) []map[string]interface{} {
+	if assignments == nil {
+		return nil
+	}
+	list := make([]map[string]interface{}, 0, len(assignments))
+	for _, assignment := range assignments {
+		list = append(list, map[string]interface{}{
+			"role":         assignment.Role,
+			"project_id":   assignment.ProjectID,
+			"project_name": assignment.ProjectName,
+			"domain_id":    assignment.DomainID,
+			"domain_name":  assignment.DomainName,
+			"inherited":    assignment.Inherited,
+		})
+	}
+	return list
+}
+
+// parseRoleAssignments parses the list of role assignments given either as objects or as JSON strings
+func parseRoleAssignments(raw []interface{}) ([]roleAssignment, error) {
+	assignments := make([]roleAssignment, 0, len(raw))
+	if err := decodeListField(raw, &assignments, "role assignment"); err != nil {
+		return nil, err
+	}
+	for _, assignment := range assignments {
+		if assignment.Role == "" {
+			return nil, fmt.Errorf("role assignment requires a role")
+		}
+		targets := 0
+		for _, target := range []string{assignment.ProjectID, assignment.ProjectName, assignment.DomainID, assignment.DomainName} {
+			if target != "" {
+				targets++
+			}
+		}
+		if targets != 1 {
+			return nil, fmt.Errorf("role assignment of `%s` requires exactly one of project_id, project_name, domain_id or domain_name", assignment.Role)
+		}
+	}
+	return assignments, nil
+}
+
+// validateSystemScope checks the system scope of the role isn't combined with other scopes
+func validateSystemScope(systemScope string, scoped bool) error {
+	switch {
+	case systemScope == "":
+		return nil
+	case systemScope != "all":
+		return fmt.Errorf("invalid system scope `%s`, the only supported value is `all`", systemScope)
+	case scoped:
+		return errors.New("system scope can't be combined with project or domain scope")
+	}
+	return nil
+}
+
+// checkRoleAssignments checks roles, projects and domains of the role assignments exist
+func checkRoleAssignments(client IdentityClient, assignments []roleAssignment) error {
+	roleList, err := client.ListRoles()
+	if err != nil {
+		return common.KeystoneErrorf(err, "error querying roles of role assignments")
+	}
+	roleNames := make([]string, 0, len(assignments))
+	for _, assignment := range assignments {
+		roleNames = append(roleNames, assignment.Role)
+	}
+	if v := common.CheckRolesSlices(roleList, roleNames); len(v) > 0 {
+		return common.NewError(common.KindBadRequest, "role %s doesn't exist", v)
+	}
+	for _, assignment := range assignments {
+		if _, err := resolveRoleAssignment(client, assignment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRootSystemRoles checks the root user has roles assigned on the system, which is required
+// to issue system-scoped tokens and to assign system roles to temporary users
+func checkRootSystemRoles(client IdentityClient) error {
+	token, err := client.GetAuthToken()
+	if err != nil {
+		return common.KeystoneErrorf(err, "error reading root token")
 	}
+	systemRoles, err := client.ListSystemRoles(token.User.ID)
+	if err != nil {
+		return common.KeystoneErrorf(err, "error querying system roles of the root user")
+	}
+	if len(systemRoles) == 0 {
+		return common.NewError(common.KindBadRequest, "root user has no role assignments on the system")
+	}
+	return nil
 }
 
 func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
@@ -309,7 +523,7 @@ func (b *backend) pathRoleUpdate(ctx context.Context, req *logical.Request, d *f
 	}
 
 	if typ, ok := d.GetOk("secret_type"); ok {
-		if entry.Root && typ != SecretToken {
+		if entry.Root && secretType(typ.(string)) != SecretToken {
 			return logical.ErrorResponse(errInvalidForRoot, "secret type"), nil
 		}
 		entry.SecretType = secretType(typ.(string))
@@ -333,6 +547,11 @@ func (b *backend) pathRoleUpdate(ctx context.Context, req *logical.Request, d *f
 		entry.DomainID = id.(string)
 	}
 
+	systemScope, systemScopeSet := d.GetOk("system_scope")
+	if systemScopeSet {
+		entry.SystemScope = systemScope.(string)
+	}
+
 	if name, ok := d.GetOk("user_domain_name"); ok {
 		entry.UserDomainName = name.(string)
 	}
@@ -353,35 +572,104 @@ func (b *backend) pathRoleUpdate(ctx context.Context, req *logical.Request, d *f
 		entry.Extensions = ext.(map[string]string)
 	}
 
-	if userGroups, ok := d.GetOk("user_groups"); ok {
+	if rules, ok := d.GetOk("access_rules"); ok {
+		accessRules, err := parseAccessRules(rules.([]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		entry.AccessRules = accessRules
+	}
+
+	if unrestricted, ok := d.GetOk("unrestricted"); ok {
+		entry.Unrestricted = unrestricted.(bool)
+	}
+
+	assignmentsRaw, assignmentsSet := d.GetOk("role_assignments")
+	if assignmentsSet {
 		if entry.Root {
-			return logical.ErrorResponse(errInvalidForRoot, "user groups"), nil
+			return logical.ErrorResponse(errInvalidForRoot, "role assignments"), nil
+		}
+		roleAssignments, err := parseRoleAssignments(assignmentsRaw.([]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
 		}
+		entry.RoleAssignments = roleAssignments
+	}
+
+	if trustee, ok := d.GetOk("trustee_static_role"); ok {
+		entry.TrusteeStaticRole = trustee.(string)
+	}
+
+	if trustor, ok := d.GetOk("trustor_static_role"); ok {
+		entry.TrustorStaticRole = trustor.(string)
+	}
+
+	projectScoped := entry.ProjectID != "" || entry.ProjectName != ""
+	if err := validateSystemScope(entry.SystemScope, projectScoped || entry.DomainID != "" || entry.DomainName != ""); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	switch {
+	case entry.SecretType == SecretApplicationCredential && !projectScoped:
+		return logical.ErrorResponse("application credentials require a project-scoped role"), nil
+	case entry.SecretType == SecretEC2 && !projectScoped:
+		return logical.ErrorResponse("EC2 credentials require a project-scoped role"), nil
+	case entry.SecretType != SecretApplicationCredential && (len(entry.AccessRules) > 0 || entry.Unrestricted):
+		return logical.ErrorResponse("access rules and unrestricted flag can only be set for application credentials"), nil
+	case entry.SecretType == SecretTrust && !projectScoped:
+		return logical.ErrorResponse("trusts require a project-scoped role"), nil
+	case entry.SecretType == SecretTrust && entry.TrusteeStaticRole == "":
+		return logical.ErrorResponse("trusts require a trustee static role"), nil
+	case entry.SecretType != SecretTrust && (entry.TrusteeStaticRole != "" || entry.TrustorStaticRole != ""):
+		return logical.ErrorResponse("trustee and trustor can only be set for trusts"), nil
+	}
+
+	for _, staticRoleName := range []string{entry.TrusteeStaticRole, entry.TrustorStaticRole} {
+		if staticRoleName == "" {
+			continue
+		}
+		staticRole, err := getStaticRoleByName(ctx, staticRoleName, req)
+		if err != nil {
+			return nil, err
+		}
+		if staticRole == nil || staticRole.Cloud != entry.Cloud {
+			return logical.ErrorResponse("static role `%s` doesn't exist in the cloud `%s`", staticRoleName, entry.Cloud), nil
+		}
+	}
+
+	if systemScopeSet && entry.SystemScope != "" {
 		client, err := cloud.getClient(ctx, req.Storage)
 		if err != nil {
-			return nil, logical.CodedError(http.StatusUnauthorized, common.LogHttpError(err).Error())
+			return nil, common.KeystoneErrorf(err, "error getting root client")
+		}
+		if err := checkRootSystemRoles(client); err != nil {
+			return nil, err
 		}
+	}
 
-		token := tokens.Get(client, client.Token())
-		domain, err := token.ExtractDomain()
+	if userGroups, ok := d.GetOk("user_groups"); ok {
+		if entry.Root {
+			return logical.ErrorResponse(errInvalidForRoot, "user groups"), nil
+		}
+		client, err := cloud.getClient(ctx, req.Storage)
 		if err != nil {
-			return nil, fmt.Errorf("error extracting the domain from token: %w", err)
+			return nil, common.KeystoneErrorf(err, "error getting root client")
 		}
 
-		groupPages, err := groups.List(client, groups.ListOpts{
-			DomainID: domain.ID,
-		}).AllPages()
+		domainID, err := getTokenDomainID(client)
 		if err != nil {
-			return nil, fmt.Errorf("error querying user groups of dynamic role: %w", err)
+			return nil, err
 		}
 
-		groupList, err := groups.ExtractGroups(groupPages)
+		groupList, err := client.ListGroups(groups.ListOpts{
+			DomainID: domainID,
+		})
 		if err != nil {
-			return nil, fmt.Errorf("error extracting groups of a dynamic role: %w", err)
+			return nil, common.KeystoneErrorf(err, "error querying user groups of dynamic role")
 		}
 
 		if v := common.CheckGroupSlices(groupList, userGroups.([]string)); len(v) > 0 {
-			return nil, logical.CodedError(http.StatusConflict, fmt.Sprintf("group %s doesn't exist", v))
+			return nil, common.NewError(common.KindBadRequest, "group %s doesn't exist", v)
 		}
 		entry.UserGroups = userGroups.([]string)
 	}
@@ -392,24 +680,41 @@ func (b *backend) pathRoleUpdate(ctx context.Context, req *logical.Request, d *f
 		}
 		client, err := cloud.getClient(ctx, req.Storage)
 		if err != nil {
-			return nil, logical.CodedError(http.StatusUnauthorized, common.LogHttpError(err).Error())
+			return nil, common.KeystoneErrorf(err, "error getting root client")
 		}
-		rolePages, err := roles.List(client, nil).AllPages()
+		roleList, err := client.ListRoles()
 		if err != nil {
-			return nil, fmt.Errorf("error querying user roles of dynamic role: %w", err)
-		}
-
-		roleList, err := roles.ExtractRoles(rolePages)
-		if err != nil {
-			return nil, fmt.Errorf("error extracting user roles of a dynamic role: %w", err)
+			return nil, common.KeystoneErrorf(err, "error querying user roles of dynamic role")
 		}
 
 		if v := common.CheckRolesSlices(roleList, userRoles.([]string)); len(v) > 0 {
-			return nil, logical.CodedError(http.StatusConflict, fmt.Sprintf("role %s doesn't exist", v))
+			return nil, common.NewError(common.KindBadRequest, "role %s doesn't exist", v)
 		}
 		entry.UserRoles = userRoles.([]string)
 	}
 
+	if entry.SecretType == SecretTrust {
+		if len(entry.UserGroups) > 0 {
+			return logical.ErrorResponse("user groups can't be set for trusts"), nil
+		}
+		if len(entry.RoleAssignments) > 0 {
+			return logical.ErrorResponse("role assignments can't be set for trusts"), nil
+		}
+		if len(entry.UserRoles) == 0 {
+			return logical.ErrorResponse("trusts require user roles to delegate"), nil
+		}
+	}
+
+	if assignmentsSet && len(entry.RoleAssignments) > 0 {
+		client, err := cloud.getClient(ctx, req.Storage)
+		if err != nil {
+			return nil, common.KeystoneErrorf(err, "error getting root client")
+		}
+		if err := checkRoleAssignments(client, entry.RoleAssignments); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := saveRole(ctx, entry, req.Storage); err != nil {
 		return nil, fmt.Errorf("error during role save: %w", err)
 	}
@@ -49,6 +49,7 @@ func expectedRoleData(cloudName string) (*roleEntry, map[string]interface{}) {
 		"project_name":        expected.ProjectName,
 		"domain_id":           "",
 		"domain_name":         expected.DomainName,
+		"system_scope":        "",
 		"user_domain_id":      "",
 		"user_domain_name":    expected.UserDomainName,
 		"project_domain_id":   "",
@@ -58,6 +59,11 @@ func expectedRoleData(cloudName string) (*roleEntry, map[string]interface{}) {
 		"secret_type":         "token",
 		"user_groups":         []string{},
 		"user_roles":          []string{},
+		"access_rules":        []map[string]interface{}{},
+		"role_assignments":    []map[string]interface{}{},
+		"unrestricted":        false,
+		"trustee_static_role": "",
+		"trustor_static_role": "",
 	}
 	return expected, expectedMap
 }
@@ -436,6 +442,115 @@ func TestRoleCreate(t *testing.T) {
 				},
 				errorRegex: notForRootRe,
 			},
+			"root-application-credential": {
+				roleEntry: &roleEntry{
+					Cloud:      cloudName,
+					Root:       true,
+					SecretType: SecretApplicationCredential,
+				},
+				errorRegex: notForRootRe,
+			},
+			"application-credential-without-project": {
+				roleEntry: &roleEntry{
+					Cloud:      cloudName,
+					SecretType: SecretApplicationCredential,
+				},
+				errorRegex: regexp.MustCompile(`application credentials require a project-scoped role`),
+			},
+			"ec2-without-project": {
+				roleEntry: &roleEntry{
+					Cloud:      cloudName,
+					SecretType: SecretEC2,
+				},
+				errorRegex: regexp.MustCompile(`EC2 credentials require a project-scoped role`),
+			},
+			"access-rules-for-token": {
+				roleEntry: &roleEntry{
+					Cloud:       cloudName,
+					ProjectID:   "project",
+					AccessRules: []accessRule{{Service: "compute", Method: "GET", Path: "/v2.1/servers"}},
+				},
+				errorRegex: regexp.MustCompile(`can only be set for application credentials`),
+			},
+			"incomplete-access-rule": {
+				roleEntry: &roleEntry{
+					Cloud:       cloudName,
+					ProjectID:   "project",
+					SecretType:  SecretApplicationCredential,
+					AccessRules: []accessRule{{Service: "compute"}},
+				},
+				errorRegex: regexp.MustCompile(`access rule requires service, method and path`),
+			},
+			"role-assignment-without-target": {
+				roleEntry: &roleEntry{
+					Cloud:           cloudName,
+					RoleAssignments: []roleAssignment{{Role: "reader"}},
+				},
+				errorRegex: regexp.MustCompile(`requires exactly one of project_id, project_name, domain_id or domain_name`),
+			},
+			"role-assignment-with-two-targets": {
+				roleEntry: &roleEntry{
+					Cloud:           cloudName,
+					RoleAssignments: []roleAssignment{{Role: "reader", ProjectID: "project", DomainID: "domain"}},
+				},
+				errorRegex: regexp.MustCompile(`requires exactly one of project_id, project_name, domain_id or domain_name`),
+			},
+			"role-assignments-for-root": {
+				roleEntry: &roleEntry{
+					Cloud:           cloudName,
+					Root:            true,
+					RoleAssignments: []roleAssignment{{Role: "reader", ProjectID: "project"}},
+				},
+				errorRegex: regexp.MustCompile(`impossible to set role assignments for the root user`),
+			},
+			"system-scope-with-project": {
+				roleEntry: &roleEntry{
+					Cloud:       cloudName,
+					ProjectID:   "project",
+					SystemScope: "all",
+				},
+				errorRegex: regexp.MustCompile(`system scope can't be combined with project or domain scope`),
+			},
+			"invalid-system-scope": {
+				roleEntry: &roleEntry{
+					Cloud:       cloudName,
+					SystemScope: "region",
+				},
+				errorRegex: regexp.MustCompile(`invalid system scope`),
+			},
+			"trust-without-project": {
+				roleEntry: &roleEntry{
+					Cloud:             cloudName,
+					SecretType:        SecretTrust,
+					TrusteeStaticRole: "trustee",
+				},
+				errorRegex: regexp.MustCompile(`trusts require a project-scoped role`),
+			},
+			"trust-without-trustee": {
+				roleEntry: &roleEntry{
+					Cloud:      cloudName,
+					ProjectID:  "project",
+					SecretType: SecretTrust,
+				},
+				errorRegex: regexp.MustCompile(`trusts require a trustee static role`),
+			},
+			"trust-not-existing-trustee": {
+				roleEntry: &roleEntry{
+					Cloud:             cloudName,
+					ProjectID:         "project",
+					SecretType:        SecretTrust,
+					TrusteeStaticRole: randomRoleName(),
+				},
+				errorRegex: regexp.MustCompile(`static role .+ doesn't exist in the cloud`),
+			},
+			"trustee-for-token": {
+				roleEntry: &roleEntry{
+					Cloud:             cloudName,
+					ProjectID:         "project",
+					TrusteeStaticRole: "trustee",
+				},
+				errorRegex: regexp.MustCompile(`trustee and trustor can only be set for trusts`),
+			},
 			"without-cloud": {
 				roleEntry:  &roleEntry{},
 				errorRegex: regexp.MustCompile(`cloud is required when creating a role`),
@@ -578,3 +693,33 @@ func fillRoleDefaultFields(b *backend, entry *roleEntry) {
 	}
 	entry.TTL /= time.Second
 }
+
+func TestDecodeListField(t *testing.T) {
+	rule := accessRule{Service: "compute", Method: "GET", Path: "/v2.1/servers"}
+
+	t.Run("ok", func(t *testing.T) {
+		var rules []accessRule
+		require.NoError(t, decodeListField([]interface{}{
+			map[string]interface{}{"service": "compute", "method": "GET", "path": "/v2.1/servers"},
+			`{"service": "compute", "method": "GET", "path": "/v2.1/servers"}`,
+			`[{"service": "compute", "method": "GET", "path": "/v2.1/servers"}, {"service": "compute", "method": "GET", "path": "/v2.1/servers"}]`,
+		}, &rules, "access rule"))
+		assert.Equal(t, []accessRule{rule, rule, rule, rule}, rules)
+	})
+
+	cases := map[string]struct {
+		raw      interface{}
+		expected string
+	}{
+		"invalid-json": {`{"service":`, `invalid access rule "{\"service\":"`},
+		"invalid-type": {42, "invalid access rule: 42"},
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			var rules []accessRule
+			err := decodeListField([]interface{}{data.raw}, &rules, "access rule")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), data.expected)
+		})
+	}
+}
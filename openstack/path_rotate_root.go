@@ -6,9 +6,11 @@ import (
 	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
 	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/vars"
 
-	"net/http"
+	"sync/atomic"
+	"time"
 
-	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/applicationcredentials"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/users"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -20,7 +22,17 @@ const (
 Rotate the cloud's root user credentials.
 
 Once this method is called, Vault will now be the only entity that knows the password used to access OpenStack instance.
+
+Reading the endpoint returns the status of the root credentials rotation of the cloud.
 `
+
+	rotationStatusStoragePath = "rotation-status"
+	maxRotationHistory        = 10
+
+	rotationTriggerManual    = "manual"
+	rotationTriggerScheduled = "scheduled"
+	rotationTriggerCreate    = "create"
+	rotationTriggerOperator  = "operator"
 )
 
 var (
@@ -36,8 +48,22 @@ func (b *backend) pathRotateRoot() *framework.Path {
 				Required:    true,
 				Description: "Specifies name of the cloud which credentials will be rotated.",
 			},
+			"member": {
+				Type:        framework.TypeString,
+				Description: "Name of the root identity of the cloud's pool. The primary root identity is used if not set.",
+			},
+			"new_password": {
+				Type:        framework.TypeString,
+				Description: "New root password or application credential secret. Generated using the cloud's password policy if not set.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.rotateRootStatusRead,
+			},
 			logical.CreateOperation: &framework.PathOperation{
 				Callback: b.rotateRootCredentials,
 			},
@@ -52,44 +78,339 @@ func (b *backend) pathRotateRoot() *framework.Path {
 
 func (b *backend) rotateRootCredentials(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	cloudName := d.Get("cloud").(string)
+	member := d.Get("member").(string)
 
 	sharedCloud := b.getSharedCloud(cloudName)
-	client, err := sharedCloud.getClient(ctx, req.Storage)
+	cloudConfig, err := sharedCloud.getCloudMemberConfig(ctx, req.Storage, member)
 	if err != nil {
-		return nil, logical.CodedError(http.StatusConflict, common.LogHttpError(err).Error())
+		return nil, fmt.Errorf(vars.ErrCloudConf)
 	}
-	user, err := tokens.Get(client, client.Token()).ExtractUser()
-	if err != nil {
-		return nil, logical.CodedError(http.StatusConflict, common.LogHttpError(err).Error())
+	if cloudConfig == nil {
+		return logical.ErrorResponse("cloud `%s` or its root identity `%s` doesn't exist", cloudName, member), nil
 	}
 
-	cloudConfig, err := sharedCloud.getCloudConfig(ctx, req.Storage)
+	trigger := rotationTriggerManual
+	var newPassword string
+	if password, ok := d.GetOk("new_password"); ok {
+		newPassword = password.(string)
+		if err := sharedCloud.passwordsFor(cloudConfig).Validate(ctx, newPassword); err != nil {
+			return logical.ErrorResponse("invalid new_password: %s", err), nil
+		}
+		trigger = rotationTriggerOperator
+		b.Logger().Warn("root credentials are set by operator",
+			"cloud", cloudConfig.identity(), "request_id", req.ID, "display_name", req.DisplayName)
+	}
+
+	if err := b.rotateRoot(ctx, req.Storage, sharedCloud, cloudConfig, trigger, newPassword); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{}, nil
+}
+
+func (b *backend) rotateRootStatusRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	cloudName := d.Get("cloud").(string)
+	member := d.Get("member").(string)
+
+	cloudConfig, err := b.getSharedCloud(cloudName).getCloudMemberConfig(ctx, req.Storage, member)
 	if err != nil {
 		return nil, fmt.Errorf(vars.ErrCloudConf)
 	}
+	if cloudConfig == nil {
+		return logical.ErrorResponse("cloud `%s` or its root identity `%s` doesn't exist", cloudName, member), nil
+	}
 
-	newPassword, err := sharedCloud.passwords.Generate(ctx)
+	status, err := getRotationStatus(ctx, req.Storage, cloudConfig.identity())
 	if err != nil {
 		return nil, err
 	}
 
-	// make sure we don't use this cloud until the password is changed
-	sharedCloud.lock.Lock()
-	defer sharedCloud.lock.Unlock()
+	history := make([]map[string]interface{}, len(status.History))
+	for i, record := range status.History {
+		history[i] = map[string]interface{}{
+			"time":    record.Time,
+			"trigger": record.Trigger,
+			"error":   record.Error,
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"last_rotation":        optionalTime(status.LastRotation),
+			"next_rotation":        cloudConfig.RootPasswordExpirationDate,
+			"last_attempt":         optionalTime(status.LastAttempt),
+			"last_error":           status.LastError,
+			"consecutive_failures": status.ConsecutiveFailures,
+			"history":              history,
+		},
+	}, nil
+}
+
+// rotationStatus is the state of the root credentials rotation of the cloud
+type rotationStatus struct {
+	LastRotation        time.Time        `json:"last_rotation"`
+	LastAttempt         time.Time        `json:"last_attempt"`
+	LastError           string           `json:"last_error"`
+	ConsecutiveFailures int              `json:"consecutive_failures"`
+	History             []rotationRecord `json:"history"`
+}
+
+// rotationRecord is a single rotation attempt, successful unless Error is set
+type rotationRecord struct {
+	Time    time.Time `json:"time"`
+	Trigger string    `json:"trigger"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func rotationStatusStorageKey(cloud string) string {
+	return fmt.Sprintf("%s/%s", rotationStatusStoragePath, cloud)
+}
+
+func getRotationStatus(ctx context.Context, s logical.Storage, cloud string) (*rotationStatus, error) {
+	status := new(rotationStatus)
+	entry, err := s.Get(ctx, rotationStatusStorageKey(cloud))
+	if err != nil {
+		return nil, fmt.Errorf("error reading rotation status: %w", err)
+	}
+	if entry == nil {
+		return status, nil
+	}
+	if err := entry.DecodeJSON(status); err != nil {
+		return nil, fmt.Errorf("error decoding rotation status: %w", err)
+	}
+	return status, nil
+}
+
+// recordRotation adds the rotation attempt to the status of the cloud
+func recordRotation(ctx context.Context, s logical.Storage, cloud, trigger string, rotationErr error) error {
+	status, err := getRotationStatus(ctx, s, cloud)
+	if err != nil {
+		return err
+	}
 
-	err = users.ChangePassword(client, user.ID, users.ChangePasswordOpts{
-		Password:         newPassword,
+	record := rotationRecord{
+		Time:    time.Now(),
+		Trigger: trigger,
+	}
+	status.LastAttempt = record.Time
+	if rotationErr != nil {
+		record.Error = rotationErr.Error()
+		status.LastError = record.Error
+		status.ConsecutiveFailures++
+	} else {
+		status.LastRotation = record.Time
+		status.LastError = ""
+		status.ConsecutiveFailures = 0
+	}
+
+	status.History = append(status.History, record)
+	if len(status.History) > maxRotationHistory {
+		status.History = status.History[len(status.History)-maxRotationHistory:]
+	}
+
+	entry, err := logical.StorageEntryJSON(rotationStatusStorageKey(cloud), status)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func optionalTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// rotateRoot rotates the root credentials of the cloud and records the attempt in the rotation status.
+// New password is generated unless provided.
+func (b *backend) rotateRoot(ctx context.Context, s logical.Storage, sCloud *sharedCloud, cloudConfig *OsCloud, trigger, newPassword string) error {
+	previousToken, err := b.replaceRootCredentials(ctx, s, sCloud, cloudConfig, newPassword)
+	if statusErr := recordRotation(ctx, s, cloudConfig.identity(), trigger, err); statusErr != nil {
+		b.Logger().Error("error saving rotation status", "cloud", cloudConfig.identity(), "error", statusErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	if cloudConfig.RevokeRootTokens {
+		revoked, err := b.revokeRootTokens(ctx, s, sCloud, cloudConfig, previousToken)
+		if err != nil {
+			// the credentials are rotated already, so the rotation is not failed
+			b.Logger().Error("error revoking root tokens", "cloud", cloudConfig.identity(), "error", err, "request_id", common.RequestIDOf(err))
+		} else {
+			b.Logger().Info("root tokens revoked", "cloud", cloudConfig.identity(), "count", revoked)
+		}
+	}
+	return nil
+}
+
+// replaceRootCredentials replaces the root credentials of the cloud in OpenStack and
+// persists the new ones in the cloud configuration. Returns the root token issued
+// for the previous credentials.
+func (b *backend) replaceRootCredentials(ctx context.Context, s logical.Storage, sCloud *sharedCloud, cloudConfig *OsCloud, newSecret string) (string, error) {
+	client, err := sCloud.getMemberClient(ctx, s, cloudConfig.member)
+	if err != nil {
+		return "", common.KeystoneErrorf(err, "error getting root client")
+	}
+	token, err := client.GetAuthToken()
+	if err != nil {
+		return "", common.KeystoneErrorf(err, "error reading root token")
+	}
+
+	if newSecret == "" {
+		newSecret, err = sCloud.passwordsFor(cloudConfig).Generate(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// make sure we don't use this identity until the password is changed,
+	// the pool uses other identities meanwhile
+	rc := sCloud.rootClientFor(cloudConfig.member)
+	atomic.StoreInt32(&rc.rotating, 1)
+	defer atomic.StoreInt32(&rc.rotating, 0)
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if err := b.changeRootCredentials(ctx, s, sCloud, client, token.User.ID, cloudConfig, newSecret); err != nil {
+		return "", err
+	}
+	// the cached client has to authenticate using new credentials
+	rc.client = nil
+	return client.TokenID(), nil
+}
+
+// changeRootCredentials sets the new root password or application credential secret
+// and saves it to the cloud configuration
+func (b *backend) changeRootCredentials(ctx context.Context, s logical.Storage, sCloud *sharedCloud, client IdentityClient, userID string, cloudConfig *OsCloud, newSecret string) error {
+	cloudConfig.RootPasswordExpirationDate = cloudConfig.nextRotation(time.Now())
+
+	if cloudConfig.AuthType == AuthTypeApplicationCredential {
+		return b.rotateRootApplicationCredential(ctx, s, sCloud, client, userID, cloudConfig, newSecret)
+	}
+
+	walID, err := framework.PutWAL(ctx, s, walRotateRootKind, &walRotateRoot{
+		Cloud:       cloudConfig.Name,
+		Member:      cloudConfig.member,
+		UserID:      userID,
+		NewPassword: newSecret,
+	})
+	if err != nil {
+		return fmt.Errorf("error writing WAL entry: %w", err)
+	}
+
+	err = client.ChangePassword(userID, users.ChangePasswordOpts{
+		Password:         newSecret,
 		OriginalPassword: cloudConfig.Password,
-	}).ExtractErr()
+	})
 	if err != nil {
-		errorMessage := fmt.Sprintf("error changing root password: %s", common.LogHttpError(err).Error())
-		return nil, logical.CodedError(http.StatusConflict, errorMessage)
+		if _, ok := err.(gophercloud.StatusCodeError); ok {
+			// the password was rejected by Keystone, so there is nothing to roll back
+			b.deleteWAL(ctx, s, walID)
+		}
+		return common.KeystoneErrorf(err, "error changing root password")
 	}
-	cloudConfig.Password = newPassword
 
-	if err := cloudConfig.save(ctx, req.Storage); err != nil {
-		return nil, err
+	pending := *cloudConfig
+	pending.Password = newSecret
+	if _, _, err := sCloud.authenticate(ctx, &pending); err != nil {
+		return fmt.Errorf("error authenticating with the new root password, the rotation will be finished or rolled back later: %w", err)
 	}
 
-	return &logical.Response{}, nil
+	cloudConfig.Password = newSecret
+	if err := cloudConfig.save(ctx, s); err != nil {
+		return err
+	}
+	b.deleteWAL(ctx, s, walID)
+	return nil
+}
+
+// rotateRootApplicationCredential replaces the root application credential with a new one,
+// as application credentials can't be changed in place.
+func (b *backend) rotateRootApplicationCredential(ctx context.Context, s logical.Storage, sCloud *sharedCloud, client IdentityClient, userID string, cloudConfig *OsCloud, secret string) error {
+	oldID := cloudConfig.ApplicationCredentialID
+	if oldID == "" {
+		id, err := getApplicationCredentialID(client, userID, cloudConfig.ApplicationCredentialName)
+		if err != nil {
+			return err
+		}
+		oldID = id
+	}
+
+	newName := fmt.Sprintf("vault-%s-%d", cloudConfig.Name, time.Now().Unix())
+	walID, err := framework.PutWAL(ctx, s, walRotateRootKind, &walRotateRoot{
+		Cloud:                        cloudConfig.Name,
+		UserID:                       userID,
+		NewPassword:                  secret,
+		NewApplicationCredentialName: newName,
+		OldApplicationCredentialID:   oldID,
+	})
+	if err != nil {
+		return fmt.Errorf("error writing WAL entry: %w", err)
+	}
+
+	newCredential, err := client.CreateApplicationCredential(userID, applicationcredentials.CreateOpts{
+		Name:        newName,
+		Description: "Vault's root application credential",
+		// the credential must be able to create its successor on the next rotation
+		Unrestricted: true,
+		Secret:       secret,
+	})
+	if err != nil {
+		if _, ok := err.(gophercloud.StatusCodeError); ok {
+			b.deleteWAL(ctx, s, walID)
+		}
+		return common.KeystoneErrorf(err, "error creating root application credential")
+	}
+
+	pending := *cloudConfig
+	pending.ApplicationCredentialID = newCredential.ID
+	pending.ApplicationCredentialName = newCredential.Name
+	pending.ApplicationCredentialSecret = newCredential.Secret
+	if _, _, err := sCloud.authenticate(ctx, &pending); err != nil {
+		return fmt.Errorf("error authenticating with the new root application credential, the rotation will be finished or rolled back later: %w", err)
+	}
+
+	*cloudConfig = pending
+	if err := cloudConfig.save(ctx, s); err != nil {
+		return err
+	}
+	b.deleteWAL(ctx, s, walID)
+
+	return deleteRootApplicationCredential(sCloud, client, userID, oldID)
+}
+
+// deleteRootApplicationCredential removes replaced root application credential
+func deleteRootApplicationCredential(sCloud *sharedCloud, client IdentityClient, userID, id string) error {
+	err := client.DeleteApplicationCredential(userID, id)
+	// tokens issued for the old credential are revoked together with it
+	sCloud.client = nil
+	if err != nil {
+		return common.KeystoneErrorf(err, "error deleting previous root application credential")
+	}
+	return nil
+}
+
+func getApplicationCredentialID(client IdentityClient, userID, name string) (string, error) {
+	credential, err := findApplicationCredential(client, userID, name)
+	if err != nil {
+		return "", err
+	}
+	if credential == nil {
+		return "", fmt.Errorf("application credential `%s` doesn't exist", name)
+	}
+	return credential.ID, nil
+}
+
+// findApplicationCredential returns application credential of the user by name, or nil if there is none
+func findApplicationCredential(client IdentityClient, userID, name string) (*applicationcredentials.ApplicationCredential, error) {
+	credentials, err := client.ListApplicationCredentials(userID, applicationcredentials.ListOpts{Name: name})
+	if err != nil {
+		return nil, common.KeystoneErrorf(err, "error querying application credentials")
+	}
+	if len(credentials) == 0 {
+		return nil, nil
+	}
+	return &credentials[0], nil
 }
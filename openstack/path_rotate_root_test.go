@@ -2,13 +2,16 @@ package openstack
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/gophercloud/gophercloud/acceptance/tools"
 	thClient "github.com/gophercloud/gophercloud/testhelper/client"
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/fixtures"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -42,6 +45,45 @@ func TestRotateRootCredentials_ok(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestRotateRootCredentials_applicationCredential(t *testing.T) {
+	userID, _ := uuid.GenerateUUID()
+	projectName := tools.RandomString("p", 5)
+	fixtures.SetupKeystoneMock(t, userID, projectName, fixtures.EnabledMocks{
+		TokenPost: true, TokenGet: true, AppCredPost: true, AppCredList: true, AppCredDelete: true,
+	})
+
+	b, s := testBackend(t)
+
+	cloud := &sharedCloud{name: tools.RandomString("cl", 5)}
+
+	testClient := thClient.ServiceClient()
+	authURL := testClient.Endpoint + "v3"
+
+	entry, err := logical.StorageEntryJSON(storageCloudKey(cloud.name), OsCloud{
+		Name:                        cloud.name,
+		AuthURL:                     authURL,
+		AuthType:                    AuthTypeApplicationCredential,
+		Username:                    tools.RandomString("u", 5),
+		UserDomainName:              tools.RandomString("d", 5),
+		ApplicationCredentialName:   tools.RandomString("ac", 5),
+		ApplicationCredentialSecret: tools.MakeNewPassword(""),
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Put(context.Background(), entry))
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Path:      "rotate-root/" + cloud.name,
+		Operation: logical.CreateOperation,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+
+	cloudConfig, err := b.getSharedCloud(cloud.name).getCloudConfig(context.Background(), s)
+	require.NoError(t, err)
+	require.Equal(t, "58d61ff8e6e34accb35874016d1dba8b", cloudConfig.ApplicationCredentialID)
+	require.Equal(t, "rEaqvJka48mpv", cloudConfig.ApplicationCredentialSecret)
+}
+
 func TestRotateRootCredentials_error(t *testing.T) {
 	t.Run("read-fail", func(t *testing.T) {
 		userID, _ := uuid.GenerateUUID()
@@ -71,6 +113,14 @@ func TestRotateRootCredentials_error(t *testing.T) {
 			TokenPost: true, PasswordChange: true,
 		},
 	}
+	appCredCases := map[string]fixtures.EnabledMocks{
+		"no-app-cred-create": {
+			TokenPost: true, TokenGet: true, AppCredDelete: true,
+		},
+		"no-app-cred-delete": {
+			TokenPost: true, TokenGet: true, AppCredPost: true,
+		},
+	}
 
 	for name, data := range cases {
 		t.Run(name, func(t *testing.T) {
@@ -104,4 +154,265 @@ func TestRotateRootCredentials_error(t *testing.T) {
 			require.Error(t, err)
 		})
 	}
+
+	for name, data := range appCredCases {
+		t.Run(name, func(t *testing.T) {
+			data := data
+			userID, _ := uuid.GenerateUUID()
+			projectName := tools.RandomString("p", 5)
+			fixtures.SetupKeystoneMock(t, userID, projectName, data)
+
+			b, s := testBackend(t)
+
+			cloud := &sharedCloud{name: tools.RandomString("cl", 5)}
+
+			testClient := thClient.ServiceClient()
+			authURL := testClient.Endpoint + "v3"
+
+			entry, err := logical.StorageEntryJSON(storageCloudKey(cloud.name), OsCloud{
+				Name:                        cloud.name,
+				AuthURL:                     authURL,
+				AuthType:                    AuthTypeApplicationCredential,
+				ApplicationCredentialID:     tools.RandomString("ac", 5),
+				ApplicationCredentialSecret: tools.MakeNewPassword(""),
+			})
+			require.NoError(t, err)
+			require.NoError(t, s.Put(context.Background(), entry))
+
+			_, err = b.HandleRequest(context.Background(), &logical.Request{
+				Path:      "rotate-root/" + cloud.name,
+				Operation: logical.CreateOperation,
+				Storage:   s,
+			})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestRotateRootStatus(t *testing.T) {
+	userID, _ := uuid.GenerateUUID()
+
+	setup := func(t *testing.T, enabled fixtures.EnabledMocks) (*backend, logical.Storage) {
+		fixtures.SetupKeystoneMock(t, userID, "", enabled)
+
+		b, s := testBackend(t)
+
+		cloud := &OsCloud{
+			Name:            testCloudName,
+			AuthURL:         thClient.ServiceClient().Endpoint + "v3",
+			UserDomainName:  testUserDomainName,
+			Username:        testUsername,
+			Password:        testPassword1,
+			RootPasswordTTL: time.Hour,
+		}
+		require.NoError(t, cloud.save(context.Background(), s))
+		return b, s
+	}
+
+	request := func(t *testing.T, b *backend, s logical.Storage, operation logical.Operation) *logical.Response {
+		res, _ := b.HandleRequest(context.Background(), &logical.Request{
+			Path:      "rotate-root/" + testCloudName,
+			Operation: operation,
+			Storage:   s,
+		})
+		return res
+	}
+
+	t.Run("success", func(t *testing.T) {
+		b, s := setup(t, fixtures.EnabledMocks{TokenPost: true, TokenGet: true, PasswordChange: true})
+
+		request(t, b, s, logical.UpdateOperation)
+
+		res := request(t, b, s, logical.ReadOperation)
+		require.NotNil(t, res)
+		require.False(t, res.IsError())
+
+		assert.NotNil(t, res.Data["last_rotation"])
+		assert.Equal(t, res.Data["last_rotation"], res.Data["last_attempt"])
+		assert.Empty(t, res.Data["last_error"])
+		assert.Equal(t, 0, res.Data["consecutive_failures"])
+		assert.WithinDuration(t, time.Now().Add(time.Hour), res.Data["next_rotation"].(time.Time), time.Minute)
+
+		history := res.Data["history"].([]map[string]interface{})
+		require.Len(t, history, 1)
+		assert.Equal(t, rotationTriggerManual, history[0]["trigger"])
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		b, s := setup(t, fixtures.EnabledMocks{TokenPost: true, TokenGet: true})
+
+		request(t, b, s, logical.UpdateOperation)
+		request(t, b, s, logical.UpdateOperation)
+
+		res := request(t, b, s, logical.ReadOperation)
+		require.NotNil(t, res)
+		require.False(t, res.IsError())
+
+		assert.Nil(t, res.Data["last_rotation"])
+		assert.NotNil(t, res.Data["last_attempt"])
+		assert.NotEmpty(t, res.Data["last_error"])
+		assert.Equal(t, 2, res.Data["consecutive_failures"])
+	})
+
+	t.Run("missing-cloud", func(t *testing.T) {
+		b, s := testBackend(t)
+
+		res := request(t, b, s, logical.ReadOperation)
+		require.NotNil(t, res)
+		assert.True(t, res.IsError())
+	})
+}
+
+func TestRecordRotation_historyLimit(t *testing.T) {
+	_, s := testBackend(t)
+
+	for i := 0; i < maxRotationHistory+5; i++ {
+		require.NoError(t, recordRotation(context.Background(), s, testCloudName, rotationTriggerScheduled, nil))
+	}
+
+	status, err := getRotationStatus(context.Background(), s, testCloudName)
+	require.NoError(t, err)
+	assert.Len(t, status.History, maxRotationHistory)
+}
+
+type staticPasswordGenerator string
+
+func (g staticPasswordGenerator) GeneratePasswordFromPolicy(_ context.Context, _ string) (string, error) {
+	if g == "" {
+		return "", fmt.Errorf("policy doesn't exist")
+	}
+	return string(g), nil
+}
+
+func TestPasswords_Validate(t *testing.T) {
+	noPolicy := Passwords{}
+	require.NoError(t, noPolicy.Validate(context.Background(), "0123456789abcdef"))
+	err := noPolicy.Validate(context.Background(), "Xy9!")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "16 characters")
+
+	policy := Passwords{
+		PolicyGenerator: staticPasswordGenerator("aB3$aB3$aB3$aB3$aB3$"),
+		PolicyName:      "test",
+	}
+	require.NoError(t, policy.Validate(context.Background(), "Xy9!Xy9!Xy9!Xy9!Xy9!"))
+	err = policy.Validate(context.Background(), "Xy9!Xy9!Xy9!Xy9!")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "20 characters")
+
+	missing := Passwords{
+		PolicyGenerator: staticPasswordGenerator(""),
+		PolicyName:      "missing",
+	}
+	err = missing.Validate(context.Background(), "Xy9!Xy9!Xy9!Xy9!Xy9!")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error loading password policy `missing`")
+}
+
+func TestRotateRootCredentials_newPassword(t *testing.T) {
+	userID, _ := uuid.GenerateUUID()
+	fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{TokenPost: true, TokenGet: true, PasswordChange: true})
+
+	b, s := testBackend(t)
+
+	cloud := &OsCloud{
+		Name:           testCloudName,
+		AuthURL:        thClient.ServiceClient().Endpoint + "v3",
+		UserDomainName: testUserDomainName,
+		Username:       testUsername,
+		Password:       testPassword1,
+	}
+	require.NoError(t, cloud.save(context.Background(), s))
+
+	rotate := func(password string) *logical.Response {
+		res, err := b.HandleRequest(context.Background(), &logical.Request{
+			Path:      "rotate-root/" + testCloudName,
+			Operation: logical.UpdateOperation,
+			Storage:   s,
+			Data:      map[string]interface{}{"new_password": password},
+		})
+		require.NoError(t, err)
+		return res
+	}
+
+	res := rotate("short")
+	require.True(t, res.IsError())
+
+	newPassword := tools.MakeNewPassword("") + "Ab1"
+	res = rotate(newPassword)
+	require.False(t, res.IsError())
+
+	cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+	require.NoError(t, err)
+	assert.Equal(t, newPassword, cloudConfig.Password)
+
+	status, err := getRotationStatus(context.Background(), s, testCloudName)
+	require.NoError(t, err)
+	require.Len(t, status.History, 1)
+	assert.Equal(t, rotationTriggerOperator, status.History[0].Trigger)
+
+	t.Run("missing-policy", func(t *testing.T) {
+		cloud.Password = newPassword
+		cloud.PasswordPolicy = "missing"
+		require.NoError(t, cloud.save(context.Background(), s))
+
+		res := rotate(tools.MakeNewPassword("") + "Cd2")
+		require.True(t, res.IsError())
+		assert.Contains(t, res.Error().Error(), "error loading password policy `missing`")
+
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.Equal(t, newPassword, cloudConfig.Password)
+	})
+}
+
+func TestRotateRootCredentials_revokeRootTokens(t *testing.T) {
+	userID, _ := uuid.GenerateUUID()
+	fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{
+		TokenPost: true, TokenGet: true, TokenDelete: true, PasswordChange: true,
+	})
+
+	b, s := testBackend(t)
+
+	cloud := &OsCloud{
+		Name:             testCloudName,
+		AuthURL:          thClient.ServiceClient().Endpoint + "v3",
+		UserDomainName:   testUserDomainName,
+		Username:         testUsername,
+		Password:         testPassword1,
+		RevokeRootTokens: true,
+	}
+	require.NoError(t, cloud.save(context.Background(), s))
+
+	leases := []*leaseEntry{
+		{ID: "root", Cloud: testCloudName, SecretType: backendSecretTypeToken, Root: true, Token: "root-token"},
+		{ID: "member", Cloud: testCloudName, SecretType: backendSecretTypeToken, Root: true, Member: testMemberName, Token: "member-token"},
+		{ID: "user", Cloud: testCloudName, SecretType: backendSecretTypeToken, Token: "user-token"},
+		{ID: userID, Cloud: testCloudName, SecretType: backendSecretTypeUser, UserID: userID},
+	}
+	for _, lease := range leases {
+		require.NoError(t, saveLease(context.Background(), s, lease))
+	}
+
+	sCloud := b.getSharedCloud(testCloudName)
+	_, err := sCloud.getClient(context.Background(), s)
+	require.NoError(t, err)
+	previousClient := sCloud.client
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Path:      "rotate-root/" + testCloudName,
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+
+	remaining, err := listLeases(context.Background(), s, testCloudName)
+	require.NoError(t, err)
+	ids := make([]string, len(remaining))
+	for i, lease := range remaining {
+		ids[i] = lease.ID
+	}
+	assert.ElementsMatch(t, []string{"member", "user", userID}, ids)
+
+	assert.NotSame(t, previousClient, sCloud.client)
 }
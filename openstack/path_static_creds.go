@@ -9,7 +9,6 @@ import (
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
 	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/vars"
-	"net/http"
 )
 
 const (
@@ -78,6 +77,9 @@ func (b *backend) pathStaticCredsRead(ctx context.Context, r *logical.Request, d
 	if err != nil {
 		return nil, err
 	}
+	if role == nil {
+		return nil, common.NewError(common.KindNotFound, "static role `%s` doesn't exist", roleName)
+	}
 
 	sharedCloud := b.getSharedCloud(role.Cloud)
 	cloudConfig, err := sharedCloud.getCloudConfig(ctx, r.Storage)
@@ -85,15 +87,14 @@ func (b *backend) pathStaticCredsRead(ctx context.Context, r *logical.Request, d
 		return nil, fmt.Errorf(vars.ErrCloudConf)
 	}
 
-	client, err := sharedCloud.getClient(ctx, r.Storage)
+	client, err := sharedCloud.getPoolClient(ctx, r.Storage)
 	if err != nil {
-		return nil, logical.CodedError(http.StatusConflict, common.LogHttpError(err).Error())
+		return nil, common.KeystoneErrorf(err, "error getting root client")
 	}
 
-	user, err := users.Get(client, role.UserID).Extract()
+	user, err := client.GetUser(role.UserID)
 	if err != nil {
-		errorMessage := fmt.Sprintf("error querying static user: %s", common.LogHttpError(err).Error())
-		return nil, logical.CodedError(http.StatusConflict, errorMessage)
+		return nil, common.KeystoneErrorf(err, "error querying static user")
 	}
 
 	var data map[string]interface{}
@@ -145,6 +146,8 @@ func (b *backend) pathStaticCredsRead(ctx context.Context, r *logical.Request, d
 		return nil, fmt.Errorf("invalid secret type: %s", r)
 	}
 
+	setTLSData(data, cloudConfig)
+
 	for extensionKey, extensionValue := range role.Extensions {
 		data[extensionKey] = extensionValue
 	}
@@ -158,15 +161,18 @@ func (b *backend) rotateStaticCreds(ctx context.Context, r *logical.Request, d *
 	if err != nil {
 		return nil, err
 	}
+	if role == nil {
+		return nil, common.NewError(common.KindNotFound, "static role `%s` doesn't exist", roleName)
+	}
 
 	sharedCloud := b.getSharedCloud(role.Cloud)
 	if err != nil {
 		return nil, err
 	}
 
-	client, err := sharedCloud.getClient(ctx, r.Storage)
+	client, err := sharedCloud.getPoolClient(ctx, r.Storage)
 	if err != nil {
-		return nil, logical.CodedError(http.StatusConflict, common.LogHttpError(err).Error())
+		return nil, common.KeystoneErrorf(err, "error getting root client")
 	}
 
 	newPassword, err := Passwords{}.Generate(ctx)
@@ -174,10 +180,9 @@ func (b *backend) rotateStaticCreds(ctx context.Context, r *logical.Request, d *
 		return nil, err
 	}
 
-	_, err = users.Update(client, role.UserID, users.UpdateOpts{Password: newPassword}).Extract()
+	_, err = client.UpdateUser(role.UserID, users.UpdateOpts{Password: newPassword})
 	if err != nil {
-		errorMessage := fmt.Sprintf("error rotating user password for user `%s`: %s", role.Username, common.LogHttpError(err))
-		return nil, logical.CodedError(http.StatusConflict, errorMessage)
+		return nil, common.KeystoneErrorf(err, "error rotating user password for user `%s`", role.Username)
 	}
 
 	role.Secret = newPassword
@@ -192,6 +197,10 @@ func (b *backend) rotateStaticCreds(ctx context.Context, r *logical.Request, d *
 func getScopeFromStaticRole(role *roleStaticEntry) tokens.Scope {
 	var scope tokens.Scope
 	switch {
+	case role.SystemScope != "":
+		scope = tokens.Scope{
+			System: true,
+		}
 	case role.ProjectID != "":
 		scope = tokens.Scope{
 			ProjectID: role.ProjectID,
@@ -235,6 +244,10 @@ func formStaticAuthResponse(role *roleStaticEntry, authResponse *authStaticRespo
 	var auth map[string]interface{}
 
 	switch {
+	case role.SystemScope != "":
+		auth = map[string]interface{}{
+			"system_scope": role.SystemScope,
+		}
 	case role.ProjectID != "":
 		auth = map[string]interface{}{
 			"project_id": role.ProjectID,
@@ -274,30 +287,25 @@ func (b *backend) rotateUserPassword(ctx context.Context, req *logical.Request,
 	var userId string
 	client, err := cloud.getClient(ctx, req.Storage)
 	if err != nil {
-		return userId, common.LogHttpError(err)
+		return userId, common.KeystoneErrorf(err, "error getting root client")
 	}
 	opts := users.ListOpts{Name: user}
-	allPages, err := users.List(client, opts).AllPages()
-	if err != nil {
-		return userId, fmt.Errorf("provided user doesn't exist")
-	}
-
-	allUsers, err := users.ExtractUsers(allPages)
+	allUsers, err := client.ListUsers(opts)
 	if err != nil {
-		return userId, fmt.Errorf("page can't be extracted for given username: %s (%s)", user, err)
+		return userId, common.KeystoneErrorf(err, "error querying user `%s`", user)
 	}
 
 	if len(allUsers) > 1 {
-		return userId, fmt.Errorf("given username is not unique")
+		return userId, common.NewError(common.KindBadRequest, "given username is not unique")
 	} else if len(allUsers) == 0 {
-		return userId, fmt.Errorf("user `%s` doesn't exist", user)
+		return userId, common.NewError(common.KindNotFound, "user `%s` doesn't exist", user)
 	}
 
 	userId = allUsers[0].ID
 
-	_, err = users.Update(client, userId, users.UpdateOpts{Password: password}).Extract()
+	_, err = client.UpdateUser(userId, users.UpdateOpts{Password: password})
 	if err != nil {
-		return userId, fmt.Errorf("error rotating user password for user `%s`: %s", user, common.LogHttpError(err))
+		return userId, common.KeystoneErrorf(err, "error rotating user password for user `%s`", user)
 	}
 	return userId, nil
 }
@@ -3,6 +3,7 @@ package openstack
 import (
 	"context"
 	"fmt"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
 	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/vars"
 	"time"
 
@@ -106,6 +107,10 @@ func (b *backend) pathStaticRole() *framework.Path {
 				Type:        framework.TypeNameString,
 				Description: "Specifies a domain name for domain-scoped role.",
 			},
+			"system_scope": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Specifies a system scope for system-scoped role, the only supported value is `all`.",
+			},
 			"user_domain_id": {
 				Type:        framework.TypeLowerCaseString,
 				Description: "Specifies a domain name of a static user.",
@@ -161,6 +166,7 @@ type roleStaticEntry struct {
 	ProjectName       string            `json:"project_name"`
 	DomainID          string            `json:"domain_id"`
 	DomainName        string            `json:"domain_name"`
+	SystemScope       string            `json:"system_scope"`
 	UserDomainID      string            `json:"user_domain_id"`
 	UserDomainName    string            `json:"user_domain_name"`
 	ProjectDomainID   string            `json:"project_domain_id"`
@@ -213,6 +219,7 @@ func staticRoleToMap(src *roleStaticEntry) map[string]interface{} {
 		"project_name":        src.ProjectName,
 		"domain_id":           src.DomainID,
 		"domain_name":         src.DomainName,
+		"system_scope":        src.SystemScope,
 		"user_domain_id":      src.UserDomainID,
 		"user_domain_name":    src.UserDomainName,
 		"project_domain_id":   src.ProjectDomainID,
@@ -289,7 +296,7 @@ func (b *backend) pathStaticRoleUpdate(ctx context.Context, req *logical.Request
 		// TODO: implement situation where userDomainId != currentDomainID
 		userId, err := b.rotateUserPassword(ctx, req, cloud, username.(string), password)
 		if err != nil {
-			return logical.ErrorResponse("error during role creation: %w", err), nil
+			return nil, common.KeystoneErrorf(err, "error during role creation")
 		}
 
 		entry.UserID = userId
@@ -341,6 +348,24 @@ func (b *backend) pathStaticRoleUpdate(ctx context.Context, req *logical.Request
 		entry.Extensions = ext.(map[string]string)
 	}
 
+	systemScope, systemScopeSet := d.GetOk("system_scope")
+	if systemScopeSet {
+		entry.SystemScope = systemScope.(string)
+	}
+	scoped := entry.ProjectID != "" || entry.ProjectName != "" || entry.DomainID != "" || entry.DomainName != ""
+	if err := validateSystemScope(entry.SystemScope, scoped); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if systemScopeSet && entry.SystemScope != "" {
+		client, err := cloud.getClient(ctx, req.Storage)
+		if err != nil {
+			return nil, common.KeystoneErrorf(err, "error getting root client")
+		}
+		if err := checkRootSystemRoles(client); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := saveStaticRole(ctx, entry, req); err != nil {
 		return nil, err
 	}
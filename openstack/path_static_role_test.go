@@ -42,6 +42,7 @@ func expectedStaticRoleData(cloudName string) (*roleStaticEntry, map[string]inte
 		"project_name":        expected.ProjectName,
 		"domain_id":           "",
 		"domain_name":         expected.DomainName,
+		"system_scope":        "",
 		"project_domain_id":   "",
 		"project_domain_name": "",
 		"user_domain_id":      "",
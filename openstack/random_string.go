@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"fmt"
+	"unicode/utf8"
 
 	"github.com/hashicorp/vault/sdk/helper/base62"
 	"github.com/hashicorp/vault/sdk/helper/template"
@@ -60,3 +61,23 @@ func (p Passwords) Generate(ctx context.Context) (string, error) {
 	}
 	return p.PolicyGenerator.GeneratePasswordFromPolicy(ctx, p.PolicyName)
 }
+
+// Validate checks that the password supplied by the operator can replace a generated one. The password
+// policy is loaded the same way passwords are generated, so a missing policy is an error. Every password
+// of a policy has the length set by the policy, and the password has to be at least that long, or at least
+// PasswordLength long without a policy.
+func (p Passwords) Validate(ctx context.Context, password string) error {
+	minLength := PasswordLength
+	if p.PolicyName != "" {
+		policyPassword, err := p.Generate(ctx)
+		if err != nil {
+			return fmt.Errorf("error loading password policy `%s`: %w", p.PolicyName, err)
+		}
+		minLength = utf8.RuneCountInString(policyPassword)
+	}
+
+	if utf8.RuneCountInString(password) < minLength {
+		return fmt.Errorf("password has to be at least %d characters long", minLength)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package openstack
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
+)
+
+type vaultRequestIDKey struct{}
+
+type keystoneRequestIDsKey struct{}
+
+// withVaultRequestID returns the context of the Vault request with the given ID
+func withVaultRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, vaultRequestIDKey{}, id)
+}
+
+// globalRequestID returns the Vault request ID of the context in the format of OpenStack request IDs,
+// so Keystone logs it as the global request ID
+func globalRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(vaultRequestIDKey{}).(string)
+	if id == "" || strings.HasPrefix(id, "req-") {
+		return id
+	}
+	return "req-" + id
+}
+
+// keystoneRequestIDs collects IDs of Keystone responses received within the context
+type keystoneRequestIDs struct {
+	lock sync.Mutex
+	ids  []string
+}
+
+// withKeystoneRequestIDs returns the context collecting IDs of Keystone responses
+func withKeystoneRequestIDs(ctx context.Context) (context.Context, *keystoneRequestIDs) {
+	ids := new(keystoneRequestIDs)
+	return context.WithValue(ctx, keystoneRequestIDsKey{}, ids), ids
+}
+
+func (r *keystoneRequestIDs) add(id string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.ids = append(r.ids, id)
+}
+
+func (r *keystoneRequestIDs) list() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]string(nil), r.ids...)
+}
+
+// requestIDTransport sends the global request ID with Keystone requests and collects
+// IDs of Keystone responses
+type requestIDTransport struct {
+	transport http.RoundTripper
+}
+
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if id := globalRequestID(ctx); id != "" && req.Header.Get(common.RequestIDHeader) == "" {
+		req = req.Clone(ctx)
+		req.Header.Set(common.RequestIDHeader, id)
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if ids, ok := ctx.Value(keystoneRequestIDsKey{}).(*keystoneRequestIDs); ok {
+		if id := resp.Header.Get(common.RequestIDHeader); id != "" {
+			ids.add(id)
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,95 @@
+package openstack
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/acceptance/tools"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/fixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobalRequestID(t *testing.T) {
+	assert.Empty(t, globalRequestID(context.Background()))
+	assert.Equal(t, "req-1234", globalRequestID(withVaultRequestID(context.Background(), "1234")))
+	assert.Equal(t, "req-1234", globalRequestID(withVaultRequestID(context.Background(), "req-1234")))
+}
+
+func createKeystoneRole(t *testing.T, b *backend, s logical.Storage, data map[string]interface{}) string {
+	t.Helper()
+
+	roleName := randomRoleName()
+	data["cloud"] = testCloudName
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      rolePath(roleName),
+		Data:      data,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+	return roleName
+}
+
+func TestCredentialsRead_requestIDs(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+	project := keystone.AddProject(fixtures.KeystoneDefaultDomainID, tools.RandomString("p", 5))
+	roleName := createKeystoneRole(t, b, s, map[string]interface{}{
+		"project_id":  project.ID,
+		"secret_type": "password",
+	})
+
+	vaultRequestID, err := uuid.GenerateUUID()
+	require.NoError(t, err)
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		ID:        vaultRequestID,
+		Operation: logical.ReadOperation,
+		Path:      credsPath(roleName),
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	assert.Contains(t, keystone.GlobalRequestIDs(), "req-"+vaultRequestID)
+
+	requestIDs, ok := res.Secret.InternalData["keystone_request_ids"].([]string)
+	require.True(t, ok)
+	require.NotEmpty(t, requestIDs)
+	for _, id := range requestIDs {
+		assert.Regexp(t, "^req-", id)
+		assert.NotEqual(t, "req-"+vaultRequestID, id)
+	}
+}
+
+func TestCredentialsRead_cancelled(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+	project := keystone.AddProject(fixtures.KeystoneDefaultDomainID, tools.RandomString("p", 5))
+	keystone.AddRole("member")
+	roleName := createKeystoneRole(t, b, s, map[string]interface{}{
+		"project_id":  project.ID,
+		"secret_type": "password",
+		"user_roles":  []string{"member"},
+	})
+
+	// warm up the root client, so only the credentials requests are delayed
+	_, err := b.getSharedCloud(testCloudName).getClient(context.Background(), s)
+	require.NoError(t, err)
+	users := len(keystone.Users())
+
+	keystone.InjectFault(fixtures.Fault{Method: "PUT", PathPrefix: "/v3/projects/", Latency: 200 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath(roleName),
+		Storage:   s,
+	})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Len(t, keystone.Users(), users, "temporary user has to be removed")
+}
@@ -0,0 +1,165 @@
+package openstack
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBackoff     = time.Second
+	defaultRetryMaxBackoff  = 30 * time.Second
+)
+
+// retryPolicy defines how requests failed with transient Keystone errors are repeated
+type retryPolicy struct {
+	// maxAttempts is the total number of attempts, 1 disables retries
+	maxAttempts int
+	// backoff is the delay before the first retry, it's doubled for each next retry
+	backoff    time.Duration
+	maxBackoff time.Duration
+	jitter     bool
+}
+
+// retryPolicy returns the retry policy of the cloud, unset values are replaced with defaults
+func (cloud *OsCloud) retryPolicy() *retryPolicy {
+	policy := &retryPolicy{
+		maxAttempts: cloud.RetryMaxAttempts,
+		backoff:     cloud.RetryBackoff,
+		maxBackoff:  cloud.RetryMaxBackoff,
+		jitter:      cloud.RetryJitter,
+	}
+	if policy.maxAttempts == 0 {
+		policy.maxAttempts = defaultRetryMaxAttempts
+	}
+	if policy.backoff == 0 {
+		policy.backoff = defaultRetryBackoff
+	}
+	if policy.maxBackoff == 0 {
+		policy.maxBackoff = defaultRetryMaxBackoff
+	}
+	return policy
+}
+
+func (cloud *OsCloud) validateRetryPolicy() error {
+	if cloud.RetryMaxAttempts < 0 {
+		return fmt.Errorf("retry_max_attempts can't be negative")
+	}
+	if cloud.RetryBackoff < 0 || cloud.RetryMaxBackoff < 0 {
+		return fmt.Errorf("retry_backoff and retry_max_backoff can't be negative")
+	}
+	if policy := cloud.retryPolicy(); policy.maxBackoff < policy.backoff {
+		return fmt.Errorf("retry_max_backoff can't be less than retry_backoff")
+	}
+	return nil
+}
+
+// retryable checks if the response status is a transient error the request can be repeated after.
+// Non-idempotent requests may be already applied by Keystone if a gateway failed, so they are only
+// repeated if Keystone rejected them.
+func retryable(method string, code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent(method)
+	}
+	return false
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// delay returns the delay before the given retry, starting from 1. Retry-After of the failed
+// response is respected, but the delay never exceeds the maximum backoff.
+func (p *retryPolicy) delay(retry int, resp *http.Response) time.Duration {
+	if after, ok := retryAfter(resp); ok {
+		if after > p.maxBackoff {
+			return p.maxBackoff
+		}
+		return after
+	}
+
+	delay := p.backoff
+	for i := 1; i < retry && delay < p.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.maxBackoff {
+		delay = p.maxBackoff
+	}
+	if p.jitter && delay > 1 {
+		// keep at least a half of the delay, so retries of the concurrent requests are spread
+		// without hitting the service right away
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+	return delay
+}
+
+// retryAfter parses Retry-After header, which is either a number of seconds or an HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		after := time.Until(date)
+		if after < 0 {
+			after = 0
+		}
+		return after, true
+	}
+	return 0, false
+}
+
+// retryTransport repeats requests failed with transient errors according to the policy
+type retryTransport struct {
+	transport http.RoundTripper
+	policy    *retryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.transport.RoundTrip(req)
+		if err != nil || !retryable(req.Method, resp.StatusCode) || attempt >= t.policy.maxAttempts {
+			return resp, err
+		}
+		// the body of the request has to be sent again
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return resp, nil
+		}
+
+		delay := t.policy.delay(attempt, resp)
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
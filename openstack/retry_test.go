@@ -0,0 +1,229 @@
+package openstack
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/acceptance/tools"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/users"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/fixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy_delay(t *testing.T) {
+	policy := (&OsCloud{RetryBackoff: time.Second, RetryMaxBackoff: 5 * time.Second}).retryPolicy()
+	noHeader := &http.Response{Header: http.Header{}}
+
+	assert.Equal(t, defaultRetryMaxAttempts, policy.maxAttempts)
+	assert.Equal(t, time.Second, policy.delay(1, noHeader))
+	assert.Equal(t, 2*time.Second, policy.delay(2, noHeader))
+	assert.Equal(t, 4*time.Second, policy.delay(3, noHeader))
+	assert.Equal(t, 5*time.Second, policy.delay(4, noHeader), "delay is limited by max backoff")
+
+	t.Run("jitter", func(t *testing.T) {
+		policy.jitter = true
+		defer func() { policy.jitter = false }()
+
+		for i := 0; i < 10; i++ {
+			delay := policy.delay(2, noHeader)
+			assert.GreaterOrEqual(t, delay, time.Second)
+			assert.LessOrEqual(t, delay, 2*time.Second)
+		}
+	})
+
+	t.Run("retry-after", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{"Retry-After": []string{"3"}}}
+		assert.Equal(t, 3*time.Second, policy.delay(1, resp))
+
+		resp.Header.Set("Retry-After", "120")
+		assert.Equal(t, 5*time.Second, policy.delay(1, resp), "Retry-After is limited by max backoff")
+
+		resp.Header.Set("Retry-After", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+		assert.Equal(t, time.Duration(0), policy.delay(1, resp))
+	})
+}
+
+func TestRetryPolicy_validate(t *testing.T) {
+	cases := map[string]*OsCloud{
+		"negative-attempts": {RetryMaxAttempts: -1},
+		"negative-backoff":  {RetryBackoff: -time.Second},
+		"max-below-backoff": {RetryBackoff: time.Minute, RetryMaxBackoff: time.Second},
+	}
+	for name, cloud := range cases {
+		cloud := cloud
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, cloud.validateRetryPolicy())
+		})
+	}
+	assert.NoError(t, (&OsCloud{}).validateRetryPolicy())
+}
+
+// retryKeystoneCloud returns the cloud of the emulator admin retrying with short delays
+func retryKeystoneCloud(keystone *fixtures.Keystone, attempts int) *OsCloud {
+	return &OsCloud{
+		AuthURL:          keystone.AuthURL(),
+		Username:         testUsername,
+		Password:         testPassword1,
+		UserDomainName:   "Default",
+		RetryMaxAttempts: attempts,
+		RetryBackoff:     time.Millisecond,
+		RetryMaxBackoff:  10 * time.Millisecond,
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	keystone := fixtures.SetupKeystone(t)
+	keystone.AddAdmin(fixtures.KeystoneDefaultDomainID, testUsername, testPassword1)
+
+	t.Run("authentication", func(t *testing.T) {
+		keystone.InjectFault(fixtures.Fault{
+			Method:     http.MethodPost,
+			PathPrefix: "/v3/auth/tokens",
+			StatusCode: http.StatusServiceUnavailable,
+			Times:      2,
+		})
+		before := keystone.RequestCount(http.MethodPost, "/v3/auth/tokens")
+
+		_, _, err := AuthenticateKeystone(context.Background(), retryKeystoneCloud(keystone, 3))
+		require.NoError(t, err)
+		assert.Equal(t, before+3, keystone.RequestCount(http.MethodPost, "/v3/auth/tokens"))
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		client, _, err := AuthenticateKeystone(context.Background(), retryKeystoneCloud(keystone, 2))
+		require.NoError(t, err)
+
+		keystone.InjectFault(fixtures.Fault{
+			PathPrefix: "/v3/roles",
+			StatusCode: http.StatusTooManyRequests,
+			RetryAfter: time.Second,
+		})
+		defer keystone.ClearFaults()
+		before := keystone.RequestCount(http.MethodGet, "/v3/roles")
+
+		start := time.Now()
+		_, err = client.ListRoles()
+		var tooMany gophercloud.ErrDefault429
+		assert.ErrorAs(t, err, &tooMany)
+		assert.Equal(t, before+2, keystone.RequestCount(http.MethodGet, "/v3/roles"))
+		assert.Less(t, time.Since(start), time.Second, "Retry-After must be limited by max backoff")
+	})
+
+	t.Run("not-retryable", func(t *testing.T) {
+		client, _, err := AuthenticateKeystone(context.Background(), retryKeystoneCloud(keystone, 3))
+		require.NoError(t, err)
+
+		keystone.InjectFault(fixtures.Fault{
+			PathPrefix: "/v3/roles",
+			StatusCode: http.StatusInternalServerError,
+			Times:      1,
+		})
+		before := keystone.RequestCount(http.MethodGet, "/v3/roles")
+
+		_, err = client.ListRoles()
+		assert.Error(t, err)
+		assert.Equal(t, before+1, keystone.RequestCount(http.MethodGet, "/v3/roles"))
+	})
+
+	t.Run("non-idempotent", func(t *testing.T) {
+		client, _, err := AuthenticateKeystone(context.Background(), retryKeystoneCloud(keystone, 3))
+		require.NoError(t, err)
+
+		keystone.InjectFault(fixtures.Fault{
+			Method:     http.MethodPost,
+			PathPrefix: "/v3/users",
+			StatusCode: http.StatusBadGateway,
+			Times:      1,
+		})
+		defer keystone.ClearFaults()
+		before := keystone.RequestCount(http.MethodPost, "/v3/users")
+
+		_, err = client.CreateUser(users.CreateOpts{
+			Name:     tools.RandomString("u", 5),
+			DomainID: fixtures.KeystoneDefaultDomainID,
+		})
+		var badGateway gophercloud.ErrDefault502
+		assert.ErrorAs(t, err, &badGateway)
+		assert.Equal(t, before+1, keystone.RequestCount(http.MethodPost, "/v3/users"))
+	})
+
+	t.Run("context", func(t *testing.T) {
+		transport := &retryTransport{
+			transport: http.DefaultTransport,
+			policy:    &retryPolicy{maxAttempts: 3, backoff: time.Minute, maxBackoff: time.Minute},
+		}
+		keystone.InjectFault(fixtures.Fault{StatusCode: http.StatusBadGateway, Times: 1})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, keystone.AuthURL(), nil)
+		require.NoError(t, err)
+
+		_, err = transport.RoundTrip(req)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestRetryTransport_userCredentials(t *testing.T) {
+	b, s, keystone := testKeystoneBackend(t)
+	project := keystone.AddProject(fixtures.KeystoneDefaultDomainID, tools.RandomString("p", 5))
+
+	cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+	require.NoError(t, err)
+	cloudConfig.RetryBackoff = time.Millisecond
+	cloudConfig.RetryMaxBackoff = time.Millisecond
+	require.NoError(t, cloudConfig.save(context.Background(), s))
+	b.evictCloud(testCloudName)
+
+	roleName := randomRoleName()
+	res, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      rolePath(roleName),
+		Data: map[string]interface{}{
+			"cloud":       testCloudName,
+			"project_id":  project.ID,
+			"secret_type": "password",
+		},
+		Storage: s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+
+	keystone.InjectFault(fixtures.Fault{
+		Method:     http.MethodPost,
+		PathPrefix: "/v3/users",
+		StatusCode: http.StatusServiceUnavailable,
+		Times:      2,
+	})
+	before := keystone.RequestCount(http.MethodPost, "/v3/users")
+
+	res, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      credsPath(roleName),
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.False(t, res.IsError(), res.Error())
+	assert.Equal(t, before+3, keystone.RequestCount(http.MethodPost, "/v3/users"))
+
+	keystone.InjectFault(fixtures.Fault{
+		Method:     http.MethodDelete,
+		PathPrefix: "/v3/users",
+		StatusCode: http.StatusGatewayTimeout,
+		Times:      1,
+	})
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Secret:    res.Secret,
+		Data:      res.Data,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	_, ok := keystone.User(res.Secret.InternalData["user_id"].(string))
+	assert.False(t, ok, "temporary user must be removed")
+}
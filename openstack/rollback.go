@@ -0,0 +1,127 @@
+package openstack
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
+)
+
+const walRotateRootKind = "rotate_root"
+
+// walRotateRoot is the WAL entry of the root credentials rotation in progress
+type walRotateRoot struct {
+	Cloud string
+	// Member is the name of the root pool member, empty for the primary root identity
+	Member string
+	UserID string
+	// NewPassword is the new root password or the secret of the new application credential
+	NewPassword string
+
+	NewApplicationCredentialName string
+	OldApplicationCredentialID   string
+}
+
+func (b *backend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
+	switch kind {
+	case walRotateRootKind:
+		return b.rollbackRotateRoot(ctx, req.Storage, data)
+	default:
+		return fmt.Errorf("unknown WAL entry kind: %s", kind)
+	}
+}
+
+// rollbackRotateRoot finishes the interrupted root rotation if the new credentials
+// are valid in OpenStack, otherwise drops the entry.
+func (b *backend) rollbackRotateRoot(ctx context.Context, s logical.Storage, data interface{}) error {
+	var entry walRotateRoot
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+
+	sCloud := b.getSharedCloud(entry.Cloud)
+	cloudConfig, err := sCloud.getCloudMemberConfig(ctx, s, entry.Member)
+	if err != nil {
+		return err
+	}
+	if cloudConfig == nil {
+		// the cloud has been removed
+		return nil
+	}
+
+	rc := sCloud.rootClientFor(entry.Member)
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if cloudConfig.AuthType == AuthTypeApplicationCredential {
+		return b.rollbackRotateRootApplicationCredential(ctx, s, sCloud, cloudConfig, &entry)
+	}
+
+	if cloudConfig.Password == entry.NewPassword {
+		return nil
+	}
+
+	pending := *cloudConfig
+	pending.Password = entry.NewPassword
+	if _, _, err := sCloud.authenticate(ctx, &pending); err == nil {
+		// the password was changed, but not persisted
+		b.Logger().Warn("finishing interrupted root rotation", "cloud", cloudConfig.identity())
+		cloudConfig.Password = entry.NewPassword
+		rc.client = nil
+		return cloudConfig.save(ctx, s)
+	}
+
+	if _, _, err := sCloud.authenticate(ctx, cloudConfig); err != nil {
+		return fmt.Errorf("neither current nor new root password of cloud `%s` can be used: %w", cloudConfig.identity(), err)
+	}
+	// the password wasn't changed
+	return nil
+}
+
+func (b *backend) rollbackRotateRootApplicationCredential(ctx context.Context, s logical.Storage, sCloud *sharedCloud, cloudConfig *OsCloud, entry *walRotateRoot) error {
+	if cloudConfig.ApplicationCredentialName == entry.NewApplicationCredentialName {
+		// the new credential is already persisted
+		return nil
+	}
+
+	client, _, err := sCloud.authenticate(ctx, cloudConfig)
+	if err != nil {
+		return err
+	}
+
+	credential, err := findApplicationCredential(client, entry.UserID, entry.NewApplicationCredentialName)
+	if err != nil {
+		return err
+	}
+	if credential == nil {
+		// the credential wasn't created
+		return nil
+	}
+
+	pending := *cloudConfig
+	pending.ApplicationCredentialID = credential.ID
+	pending.ApplicationCredentialName = credential.Name
+	pending.ApplicationCredentialSecret = entry.NewPassword
+	if _, _, err := sCloud.authenticate(ctx, &pending); err != nil {
+		b.Logger().Warn("removing application credential of interrupted root rotation", "cloud", entry.Cloud, "error", err, "request_id", common.RequestIDOf(err))
+		return deleteRootApplicationCredential(sCloud, client, entry.UserID, credential.ID)
+	}
+
+	b.Logger().Warn("finishing interrupted root rotation", "cloud", entry.Cloud)
+	*cloudConfig = pending
+	if err := cloudConfig.save(ctx, s); err != nil {
+		return err
+	}
+	return deleteRootApplicationCredential(sCloud, client, entry.UserID, entry.OldApplicationCredentialID)
+}
+
+// deleteWAL removes WAL entry of the finished operation. Entries which failed
+// to be removed are dropped by the rollback later.
+func (b *backend) deleteWAL(ctx context.Context, s logical.Storage, id string) {
+	if err := framework.DeleteWAL(ctx, s, id); err != nil {
+		b.Logger().Warn("error removing WAL entry", "id", id, "error", err)
+	}
+}
@@ -0,0 +1,132 @@
+package openstack
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/acceptance/tools"
+	thClient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/fixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateRoot_noWAL(t *testing.T) {
+	userID, _ := uuid.GenerateUUID()
+	fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{TokenPost: true, TokenGet: true, PasswordChange: true})
+
+	b, s := testBackend(t)
+
+	cloud := &OsCloud{
+		Name:           testCloudName,
+		AuthURL:        thClient.ServiceClient().Endpoint + "v3",
+		UserDomainName: testUserDomainName,
+		Username:       testUsername,
+		Password:       testPassword1,
+	}
+	require.NoError(t, cloud.save(context.Background(), s))
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Path:      "rotate-root/" + testCloudName,
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+
+	walIDs, err := framework.ListWAL(context.Background(), s)
+	require.NoError(t, err)
+	assert.Empty(t, walIDs)
+
+	cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+	require.NoError(t, err)
+	assert.NotEqual(t, testPassword1, cloudConfig.Password)
+}
+
+func TestWALRollback_rotateRoot(t *testing.T) {
+	userID, _ := uuid.GenerateUUID()
+	fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{TokenPost: true, TokenGet: true})
+
+	newPassword := tools.MakeNewPassword("")
+
+	t.Run("finish", func(t *testing.T) {
+		b, s := testBackend(t)
+
+		cloud := &OsCloud{
+			Name:           testCloudName,
+			AuthURL:        thClient.ServiceClient().Endpoint + "v3",
+			UserDomainName: testUserDomainName,
+			Username:       testUsername,
+			Password:       testPassword1,
+		}
+		require.NoError(t, cloud.save(context.Background(), s))
+
+		err := b.walRollback(context.Background(), &logical.Request{Storage: s}, walRotateRootKind, map[string]interface{}{
+			"Cloud":       testCloudName,
+			"UserID":      userID,
+			"NewPassword": newPassword,
+		})
+		require.NoError(t, err)
+
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.Equal(t, newPassword, cloudConfig.Password)
+	})
+
+	t.Run("removed-cloud", func(t *testing.T) {
+		b, s := testBackend(t)
+
+		err := b.walRollback(context.Background(), &logical.Request{Storage: s}, walRotateRootKind, map[string]interface{}{
+			"Cloud":       testCloudName,
+			"UserID":      userID,
+			"NewPassword": newPassword,
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("unknown-kind", func(t *testing.T) {
+		b, s := testBackend(t)
+
+		err := b.walRollback(context.Background(), &logical.Request{Storage: s}, "unknown", nil)
+		require.Error(t, err)
+	})
+}
+
+func TestWALRollback_rotateRootApplicationCredential(t *testing.T) {
+	userID, _ := uuid.GenerateUUID()
+	fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{
+		TokenPost: true, TokenGet: true, AppCredList: true, AppCredDelete: true,
+	})
+
+	b, s := testBackend(t)
+
+	cloud := &OsCloud{
+		Name:                        testCloudName,
+		AuthURL:                     thClient.ServiceClient().Endpoint + "v3",
+		AuthType:                    AuthTypeApplicationCredential,
+		Username:                    testUsername,
+		UserDomainName:              testUserDomainName,
+		ApplicationCredentialID:     tools.RandomString("id", 5),
+		ApplicationCredentialSecret: testPassword1,
+	}
+	require.NoError(t, cloud.save(context.Background(), s))
+
+	newName := tools.RandomString("vault-", 5)
+	newSecret := tools.MakeNewPassword("")
+	err := b.walRollback(context.Background(), &logical.Request{Storage: s}, walRotateRootKind, map[string]interface{}{
+		"Cloud":                        testCloudName,
+		"UserID":                       userID,
+		"NewPassword":                  newSecret,
+		"NewApplicationCredentialName": newName,
+		"OldApplicationCredentialID":   cloud.ApplicationCredentialID,
+	})
+	require.NoError(t, err)
+
+	cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+	require.NoError(t, err)
+	assert.Equal(t, "c4859fb437df4b87a51a8f5adcfb0bc7", cloudConfig.ApplicationCredentialID)
+	assert.Equal(t, newName, cloudConfig.ApplicationCredentialName)
+	assert.Equal(t, newSecret, cloudConfig.ApplicationCredentialSecret)
+}
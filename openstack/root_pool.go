@@ -0,0 +1,253 @@
+package openstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/common"
+)
+
+const (
+	rootPoolStoragePath = "root-pool"
+
+	// unhealthyPeriod is the time during which the pool doesn't use an identity failed to authenticate
+	unhealthyPeriod = 1 * time.Minute
+)
+
+// rootMember is an additional root identity of the cloud. Members share all the settings of
+// the cloud except the user credentials and are rotated independently.
+type rootMember struct {
+	Name                       string    `json:"name"`
+	Username                   string    `json:"username"`
+	Password                   string    `json:"password"`
+	RootPasswordExpirationDate time.Time `json:"root_password_expiration_date"`
+}
+
+func rootMemberStorageKey(cloud, member string) string {
+	return fmt.Sprintf("%s/%s/%s", rootPoolStoragePath, cloud, member)
+}
+
+func getRootMember(ctx context.Context, s logical.Storage, cloud, name string) (*rootMember, error) {
+	entry, err := s.Get(ctx, rootMemberStorageKey(cloud, name))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	member := new(rootMember)
+	if err := entry.DecodeJSON(member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+func listRootMembers(ctx context.Context, s logical.Storage, cloud string) ([]string, error) {
+	return s.List(ctx, fmt.Sprintf("%s/%s/", rootPoolStoragePath, cloud))
+}
+
+func (m *rootMember) save(ctx context.Context, s logical.Storage, cloud string) error {
+	entry, err := logical.StorageEntryJSON(rootMemberStorageKey(cloud, m.Name), m)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// forMember returns configuration of the cloud authenticating as the pool member
+func (cloud *OsCloud) forMember(member *rootMember) *OsCloud {
+	memberCloud := *cloud
+	memberCloud.member = member.Name
+	memberCloud.AuthType = AuthTypePassword
+	memberCloud.ApplicationCredentialID = ""
+	memberCloud.ApplicationCredentialName = ""
+	memberCloud.ApplicationCredentialSecret = ""
+	memberCloud.Username = member.Username
+	memberCloud.Password = member.Password
+	memberCloud.RootPasswordExpirationDate = member.RootPasswordExpirationDate
+	return &memberCloud
+}
+
+// identity returns name of the root identity the configuration authenticates as
+func (cloud *OsCloud) identity() string {
+	if cloud.member == "" {
+		return cloud.Name
+	}
+	return fmt.Sprintf("%s/%s", cloud.Name, cloud.member)
+}
+
+// getCloudMemberConfig returns the cloud configuration for the pool member,
+// or the primary configuration if no member is given
+func (c *sharedCloud) getCloudMemberConfig(ctx context.Context, s logical.Storage, member string) (*OsCloud, error) {
+	cloudConfig, err := c.getCloudConfig(ctx, s)
+	if err != nil || cloudConfig == nil || member == "" {
+		return cloudConfig, err
+	}
+	m, err := getRootMember(ctx, s, c.name, member)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, nil
+	}
+	return cloudConfig.forMember(m), nil
+}
+
+// rootClientFor returns client holder of the pool member, or the cloud itself if no member is given
+func (c *sharedCloud) rootClientFor(member string) *sharedCloud {
+	if member == "" {
+		return c
+	}
+
+	c.membersLock.Lock()
+	defer c.membersLock.Unlock()
+
+	if c.members == nil {
+		c.members = make(map[string]*sharedCloud)
+	}
+	rc, ok := c.members[member]
+	if !ok {
+		rc = &sharedCloud{name: fmt.Sprintf("%s/%s", c.name, member), newClient: c.newClient, breaker: c.breaker}
+		c.members[member] = rc
+	}
+	return rc
+}
+
+// evictMember drops the cached client of the pool member
+func (c *sharedCloud) evictMember(member string) {
+	c.membersLock.Lock()
+	defer c.membersLock.Unlock()
+
+	delete(c.members, member)
+}
+
+// getMemberClient returns initialized Keystone service client of the given root identity
+func (c *sharedCloud) getMemberClient(ctx context.Context, s logical.Storage, member string) (IdentityClient, error) {
+	if member == "" {
+		return c.getClient(ctx, s)
+	}
+
+	rc := c.rootClientFor(member)
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if rc.client != nil && time.Until(rc.expiresAt) > 120*time.Second {
+		return rc.client.WithContext(ctx), nil
+	}
+
+	cloudConfig, err := c.getCloudMemberConfig(ctx, s, member)
+	if err != nil {
+		return nil, err
+	}
+	if cloudConfig == nil {
+		return nil, fmt.Errorf("no root identity `%s` found in cloud %s", member, c.name)
+	}
+
+	client, expiresAt, err := rc.authenticate(ctx, cloudConfig)
+	if err != nil {
+		return nil, err
+	}
+	rc.client = client
+	rc.expiresAt = expiresAt
+	return client, nil
+}
+
+// getPoolClient returns Keystone service client of one of the root identities of the cloud.
+// Identities are used in turn, skipping ones being rotated or failed to authenticate recently.
+func (c *sharedCloud) getPoolClient(ctx context.Context, s logical.Storage) (IdentityClient, error) {
+	client, _, err := c.getPoolMember(ctx, s)
+	return client, err
+}
+
+// getPoolMember returns Keystone service client of one of the root identities of the cloud
+// together with the cloud configuration of the same identity, like getPoolClient does
+func (c *sharedCloud) getPoolMember(ctx context.Context, s logical.Storage) (IdentityClient, *OsCloud, error) {
+	members, err := listRootMembers(ctx, s, c.name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(members) == 0 {
+		return c.memberClientConfig(ctx, s, "")
+	}
+
+	// the primary identity is the empty name
+	identities := append([]string{""}, members...)
+	start := int(atomic.AddUint32(&c.next, 1))
+
+	var lastErr error
+	for i := range identities {
+		member := identities[(start+i)%len(identities)]
+		rc := c.rootClientFor(member)
+		if !rc.available() {
+			continue
+		}
+
+		client, cloudConfig, err := c.memberClientConfig(ctx, s, member)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			// identities aren't marked unhealthy during outages of the identity service
+			if !identityFailed(err) {
+				return nil, nil, err
+			}
+			rc.markUnhealthy()
+			lastErr = err
+			continue
+		}
+		return client, cloudConfig, nil
+	}
+
+	if lastErr != nil {
+		return nil, nil, fmt.Errorf("no root identity of cloud %s can be used: %w", c.name, lastErr)
+	}
+	return nil, nil, fmt.Errorf("no root identity of cloud %s is available", c.name)
+}
+
+// memberClientConfig returns client and cloud configuration of the given root identity
+func (c *sharedCloud) memberClientConfig(ctx context.Context, s logical.Storage, member string) (IdentityClient, *OsCloud, error) {
+	client, err := c.getMemberClient(ctx, s, member)
+	if err != nil {
+		return nil, nil, err
+	}
+	cloudConfig, err := c.getCloudMemberConfig(ctx, s, member)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cloudConfig == nil {
+		return nil, nil, fmt.Errorf("no root identity `%s` found in cloud %s", member, c.name)
+	}
+	return client, cloudConfig, nil
+}
+
+// identityFailed checks if the error is caused by the root identity itself, i.e. Keystone rejected
+// its credentials or failed to authenticate it. Rejections of the open breaker are not caused
+// by the identity, as the breaker is shared by all identities of the cloud.
+func identityFailed(err error) bool {
+	var open *circuitOpenError
+	if errors.As(err, &open) {
+		return false
+	}
+	var keystoneErr *common.KeystoneError
+	if !errors.As(err, &keystoneErr) {
+		return false
+	}
+	return keystoneErr.Kind == common.KindUnauthorized || keystoneErr.Kind == common.KindUnavailable
+}
+
+// available checks if the identity can be used by the pool
+func (c *sharedCloud) available() bool {
+	if atomic.LoadInt32(&c.rotating) != 0 {
+		return false
+	}
+	return time.Now().UnixNano() > atomic.LoadInt64(&c.unhealthyUntil)
+}
+
+func (c *sharedCloud) markUnhealthy() {
+	atomic.StoreInt64(&c.unhealthyUntil, time.Now().Add(unhealthyPeriod).UnixNano())
+}
@@ -0,0 +1,50 @@
+package openstack
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const defaultRotationWindow = 1 * time.Hour
+
+// schedule returns parsed root rotation schedule of the cloud, or nil if the rotation
+// is driven by root password TTL
+func (cloud *OsCloud) schedule() (cron.Schedule, error) {
+	if cloud.RotationSchedule == "" {
+		return nil, nil
+	}
+	schedule, err := cron.ParseStandard(cloud.RotationSchedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rotation schedule: %w", err)
+	}
+	return schedule, nil
+}
+
+func (cloud *OsCloud) rotationWindow() time.Duration {
+	if cloud.RotationWindow == 0 {
+		return defaultRotationWindow
+	}
+	return cloud.RotationWindow
+}
+
+// nextRotation returns the time of the next root rotation after the given time
+func (cloud *OsCloud) nextRotation(after time.Time) time.Time {
+	schedule, err := cloud.schedule()
+	if err != nil || schedule == nil {
+		return after.Add(cloud.RootPasswordTTL)
+	}
+	return schedule.Next(after)
+}
+
+// rotationAllowed checks if the root rotation can be started at the given time,
+// i.e. the time is inside the rotation window of the schedule
+func (cloud *OsCloud) rotationAllowed(now time.Time) bool {
+	schedule, err := cloud.schedule()
+	if err != nil || schedule == nil {
+		return true
+	}
+	windowStart := schedule.Next(now.Add(-cloud.rotationWindow()))
+	return !windowStart.After(now)
+}
@@ -0,0 +1,107 @@
+package openstack
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	thClient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/opentelekomcloud/vault-plugin-secrets-openstack/openstack/fixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOsCloud_rotationSchedule(t *testing.T) {
+	cloud := &OsCloud{
+		RootPasswordTTL:  24 * time.Hour,
+		RotationSchedule: "CRON_TZ=UTC 0 2 * * SUN",
+		RotationWindow:   2 * time.Hour,
+	}
+	_, err := cloud.schedule()
+	require.NoError(t, err)
+
+	// Saturday
+	now := time.Date(2022, 11, 19, 12, 0, 0, 0, time.UTC)
+	sunday := time.Date(2022, 11, 20, 2, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, sunday, cloud.nextRotation(now))
+
+	cases := map[time.Time]bool{
+		now:                          false,
+		sunday:                       true,
+		sunday.Add(90 * time.Minute): true,
+		sunday.Add(2 * time.Hour):    false,
+		sunday.Add(24 * time.Hour):   false,
+	}
+	for at, allowed := range cases {
+		assert.Equal(t, allowed, cloud.rotationAllowed(at), "rotation at %s", at)
+	}
+
+	t.Run("ttl", func(t *testing.T) {
+		cloud := &OsCloud{RootPasswordTTL: 24 * time.Hour}
+		assert.Equal(t, now.Add(24*time.Hour), cloud.nextRotation(now))
+		assert.True(t, cloud.rotationAllowed(now))
+	})
+
+	t.Run("default-window", func(t *testing.T) {
+		cloud := &OsCloud{RotationSchedule: "CRON_TZ=UTC 0 2 * * SUN"}
+		assert.True(t, cloud.rotationAllowed(sunday.Add(59*time.Minute)))
+		assert.False(t, cloud.rotationAllowed(sunday.Add(61*time.Minute)))
+	})
+}
+
+func TestRotateIfRequired_window(t *testing.T) {
+	// the window of the schedule is 02:00-03:00 on January 1st
+	windowStart := time.Date(2026, time.January, 1, 2, 0, 0, 0, time.UTC)
+
+	setup := func(t *testing.T) (*backend, logical.Storage, *OsCloud) {
+		userID, _ := uuid.GenerateUUID()
+		fixtures.SetupKeystoneMock(t, userID, "", fixtures.EnabledMocks{TokenPost: true, TokenGet: true, PasswordChange: true})
+
+		b, s := testBackend(t)
+		cloud := &OsCloud{
+			Name:             testCloudName,
+			AuthURL:          thClient.ServiceClient().Endpoint + "v3",
+			UserDomainName:   testUserDomainName,
+			Username:         testUsername,
+			Password:         testPassword1,
+			RotationSchedule: "0 2 1 1 *",
+			// missed rotation
+			RootPasswordExpirationDate: windowStart.Add(-24 * time.Hour),
+		}
+		require.NoError(t, cloud.save(context.Background(), s))
+		b.checkAutoRotateAfter = windowStart.Add(10 * 365 * 24 * time.Hour)
+		return b, s, cloud
+	}
+
+	t.Run("outside", func(t *testing.T) {
+		b, s, cloud := setup(t)
+		now := windowStart.Add(-time.Hour)
+
+		require.NoError(t, b.rotateIfRequired(context.Background(), &logical.Request{Storage: s}, b.getSharedCloud(testCloudName), now))
+
+		status, err := getRotationStatus(context.Background(), s, testCloudName)
+		require.NoError(t, err)
+		assert.Empty(t, status.History)
+		assert.Equal(t, windowStart, b.checkAutoRotateAfter)
+		assert.Equal(t, windowStart, cloud.nextRotation(now))
+	})
+
+	t.Run("inside", func(t *testing.T) {
+		b, s, _ := setup(t)
+		now := windowStart.Add(30 * time.Minute)
+
+		require.NoError(t, b.rotateIfRequired(context.Background(), &logical.Request{Storage: s}, b.getSharedCloud(testCloudName), now))
+
+		status, err := getRotationStatus(context.Background(), s, testCloudName)
+		require.NoError(t, err)
+		require.Len(t, status.History, 1)
+		assert.Equal(t, rotationTriggerScheduled, status.History[0].Trigger)
+
+		cloudConfig, err := b.getSharedCloud(testCloudName).getCloudConfig(context.Background(), s)
+		require.NoError(t, err)
+		assert.NotEqual(t, testPassword1, cloudConfig.Password)
+	})
+}
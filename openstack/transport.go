@@ -0,0 +1,69 @@
+package openstack
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/go-cleanhttp"
+)
+
+// tlsConfig returns TLS configuration for connections to the cloud
+func (cloud *OsCloud) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cloud.Insecure,
+	}
+
+	if cloud.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(cloud.CACert)) {
+			return nil, fmt.Errorf("failed to parse CA certificate")
+		}
+		config.RootCAs = pool
+	}
+
+	if cloud.ClientCert != "" || cloud.ClientKey != "" {
+		if cloud.ClientCert == "" || cloud.ClientKey == "" {
+			return nil, fmt.Errorf("both client_cert and client_key are required for client certificate authentication")
+		}
+		cert, err := tls.X509KeyPair([]byte(cloud.ClientCert), []byte(cloud.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// httpClient returns HTTP client dedicated to the cloud, which retries transient Keystone errors,
+// reports results of the requests to the circuit breaker of the cloud and passes request IDs
+func (cloud *OsCloud) httpClient() (*http.Client, error) {
+	tlsConfig, err := cloud.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := cleanhttp.DefaultPooledTransport()
+	transport.TLSClientConfig = tlsConfig
+
+	var roundTripper http.RoundTripper = &retryTransport{transport: transport, policy: cloud.retryPolicy()}
+	if cloud.breaker != nil {
+		roundTripper = &breakerTransport{transport: roundTripper, breaker: cloud.breaker, probeURL: cloud.AuthURL}
+	}
+	roundTripper = &requestIDTransport{transport: roundTripper}
+
+	return &http.Client{Transport: roundTripper}, nil
+}
+
+// setTLSData adds TLS settings required to reach the cloud to the credentials response
+func setTLSData(data map[string]interface{}, cloud *OsCloud) {
+	if cloud.CACert != "" {
+		data["cacert"] = cloud.CACert
+	}
+	if cloud.Insecure {
+		data["verify"] = false
+	}
+}
@@ -0,0 +1,64 @@
+package openstack
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOsCloud_httpClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	caCert := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}))
+
+	cases := map[string]struct {
+		cloud   *OsCloud
+		wantErr bool
+	}{
+		"ca-cert": {
+			cloud: &OsCloud{CACert: caCert},
+		},
+		"insecure": {
+			cloud: &OsCloud{Insecure: true},
+		},
+		"untrusted": {
+			cloud:   &OsCloud{},
+			wantErr: true,
+		},
+	}
+
+	for name, data := range cases {
+		data := data
+		t.Run(name, func(t *testing.T) {
+			client, err := data.cloud.httpClient()
+			require.NoError(t, err)
+
+			resp, err := client.Get(server.URL)
+			if data.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+		})
+	}
+}
+
+func TestSetTLSData(t *testing.T) {
+	data := map[string]interface{}{}
+	setTLSData(data, &OsCloud{})
+	assert.Empty(t, data)
+
+	setTLSData(data, &OsCloud{CACert: "cert", Insecure: true})
+	assert.Equal(t, map[string]interface{}{"cacert": "cert", "verify": false}, data)
+}